@@ -3,25 +3,34 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
-	"crypto/sha256"
-	"encoding/hex"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
+	"github.com/SerjZimmer/devops/internal/collector"
 	config "github.com/SerjZimmer/devops/internal/config/agent"
-	"github.com/SerjZimmer/devops/internal/storage"
+	"github.com/SerjZimmer/devops/internal/encryption"
+	"github.com/SerjZimmer/devops/internal/hash"
+	"github.com/SerjZimmer/devops/internal/tenant"
 )
 
 // Mock sendMetric function for testing
 var (
 	originalSendMetric = sendMetric
-	sendMetricT        = func(m storage.Metrics, c *config.Config) {}
+	sendMetricT        = func(m metric, c *config.Config) {}
 )
 
-var m storage.Metrics
+var m metric
 
 func Test_sendMetric(t *testing.T) {
 	c := config.New()
@@ -30,6 +39,7 @@ func Test_sendMetric(t *testing.T) {
 		w.WriteHeader(http.StatusOK)
 	}))
 	defer server.Close()
+	c.Address = server.Listener.Addr().String()
 
 	// Replace the sendMetric function with the original one after the test
 	defer func() { sendMetricT = originalSendMetric }()
@@ -114,16 +124,15 @@ func TestDoReq(t *testing.T) {
 		assert.Equal(t, contentType, r.Header.Get("Content-Type"))
 		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
 
-		// Проверка наличия хэша в запросе, если указан ключ в конфиге
+		// Проверка подписи сжатого тела запроса, если указан ключ в конфиге
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
 		if config.Key != "" {
-			hasher := sha256.New()
-			hasher.Write([]byte(config.Key))
-			expectedHash := hex.EncodeToString(hasher.Sum(nil))
-			assert.Equal(t, expectedHash, r.Header.Get("HashSHA256"))
+			assert.True(t, hash.Verify(config.Key, body, r.Header.Get(hash.Header)))
 		}
 
 		// Чтение тела запроса и проверка его содержимого
-		reader, err := gzip.NewReader(r.Body)
+		reader, err := gzip.NewReader(bytes.NewReader(body))
 		assert.NoError(t, err)
 		defer reader.Close()
 
@@ -162,3 +171,119 @@ func TestGetOrDefault(t *testing.T) {
 		assert.Equal(t, tc.expected, result, "Unexpected result for value=%s, defaultValue=%s", tc.value, tc.defaultValue)
 	}
 }
+
+func TestDoReq_Encrypted(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	publicKey = &key.PublicKey
+	defer func() { publicKey = nil }()
+
+	testData := []byte(`[{"id":"HeapAlloc","type":"gauge","value":1.5}]`)
+	c := &config.Config{Key: "secretKey"}
+
+	received := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = true
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, encryption.Scheme, r.Header.Get(encryption.Header))
+		assert.True(t, hash.Verify(c.Key, body, r.Header.Get(hash.Header)))
+
+		decrypted, err := encryption.Decrypt(key, body)
+		assert.NoError(t, err)
+		reader, err := gzip.NewReader(bytes.NewReader(decrypted))
+		assert.NoError(t, err)
+		plain, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.Equal(t, testData, plain)
+	}))
+	defer server.Close()
+	c.Address = server.Listener.Addr().String()
+
+	doReq(testData, "application/json", "updates", c)
+	assert.True(t, received)
+}
+
+func TestDoReq_TLS(t *testing.T) {
+	received := false
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.TLS != nil
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.NoError(t, os.WriteFile(caFile, caPEM, 0600))
+
+	c := &config.Config{Address: server.Listener.Addr().String(), TLSCA: caFile}
+	assert.NoError(t, setupTLS(c))
+	defer func() { tlsConfig, httpClient = nil, &http.Client{} }()
+
+	doReq([]byte("test data"), "application/json", "update", c)
+	assert.True(t, received)
+}
+
+func TestOutboundIP(t *testing.T) {
+	ip, err := outboundIP("127.0.0.1:8080")
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", ip)
+
+	_, err = outboundIP("missing-port")
+	assert.Error(t, err)
+}
+
+func TestDoReq_RealIP(t *testing.T) {
+	realIP = "192.168.1.10"
+	defer func() { realIP = "" }()
+
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("X-Real-IP")
+	}))
+	defer server.Close()
+
+	doReq([]byte("test data"), "application/json", "update", &config.Config{Address: server.Listener.Addr().String()})
+	assert.Equal(t, "192.168.1.10", got)
+}
+
+func TestDoReq_Tenant(t *testing.T) {
+	var name string
+	var verified bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		name = r.Header.Get(tenant.Header)
+		ts := r.Header.Get(tenant.TimestampHeader)
+		verified = tenant.CheckTimestamp(ts, time.Now()) == nil &&
+			hash.Verify("key-a", tenant.SignedData(r.Method, r.URL.RequestURI(), ts, body), r.Header.Get(hash.Header))
+	}))
+	defer server.Close()
+
+	doReq([]byte("test data"), "application/json", "update", &config.Config{Address: server.Listener.Addr().String(), Key: "key-a", Tenant: "team-a"})
+	assert.Equal(t, "team-a", name)
+	assert.True(t, verified)
+}
+
+func TestAgentLabels(t *testing.T) {
+	host, err := os.Hostname()
+	require.NoError(t, err)
+
+	l, err := agentLabels(&config.Config{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"host": host, "agent_id": host}, l)
+
+	l, err = agentLabels(&config.Config{AgentID: "agent-1"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"host": host, "agent_id": "agent-1"}, l)
+}
+
+func TestBuildReports_Labels(t *testing.T) {
+	labels = map[string]string{"host": "web-1", "agent_id": "agent-1"}
+	defer func() { labels = nil }()
+
+	b := collector.NewBuffer()
+	b.SetGauge("HeapAlloc", 1)
+	b.AddCounter("PollCount", 2)
+	for _, m := range buildReports(b.Snapshot()) {
+		assert.Equal(t, labels, m.Labels, m.ID)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	config "github.com/SerjZimmer/devops/internal/config/agent"
+	"github.com/SerjZimmer/devops/internal/hash"
+	pb "github.com/SerjZimmer/devops/internal/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// grpcSender отправляет метрики на сервер по gRPC вместо HTTP.
+type grpcSender struct {
+	conn   *grpc.ClientConn
+	client pb.MetricsClient
+	key    string
+	retry  retryPolicy
+}
+
+// newGRPCSender создает клиент gRPC-сервиса метрик по адресу из конфигурации.
+// При включенном TLS соединение защищается теми же настройками, что и HTTP.
+func newGRPCSender(c *config.Config) *grpcSender {
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	conn, err := grpc.NewClient(c.GRPCAddress, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		panic(err)
+	}
+	return &grpcSender{
+		conn:   conn,
+		client: pb.NewMetricsClient(conn),
+		key:    c.Key,
+		retry:  newRetryPolicy(c),
+	}
+}
+
+// send отправляет метрики на сервер одним вызовом UpdateMetrics.
+// Если задан ключ, сериализованный запрос подписывается HMAC-SHA256 в метаданных HashSHA256.
+// Вызов, завершившийся кодом Unavailable или ResourceExhausted, повторяется по политике из конфигурации.
+func (g *grpcSender) send(metrics []metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+	req := &pb.UpdateMetricsRequest{Metrics: make([]*pb.Metric, 0, len(metrics))}
+	for _, m := range metrics {
+		req.Metrics = append(req.Metrics, &pb.Metric{Id: m.ID, Type: m.MType, Delta: m.Delta, Value: m.Value, Labels: m.Labels})
+	}
+
+	md := metadata.MD{}
+	if realIP != "" {
+		md.Append("X-Real-IP", realIP)
+	}
+	if g.key != "" {
+		data, err := pb.MarshalSigned(req)
+		if err != nil {
+			return fmt.Errorf("ошибка при сериализации запроса: %w", err)
+		}
+		md.Append(hash.Header, hash.Sign(g.key, data))
+	}
+
+	return g.retry.do(func() error {
+		ctx, cancel := context.WithTimeout(metadata.NewOutgoingContext(context.Background(), md), 10*time.Second)
+		defer cancel()
+		_, err := g.client.UpdateMetrics(ctx, req)
+		return grpcError(err)
+	})
+}
+
+// grpcError оборачивает ошибку вызова gRPC в retryableError, если повтор может её исправить: сервер недоступен
+// или перегружен, истекло время вызова или он прерван, - так же, как transportError для HTTP.
+func grpcError(err error) error {
+	if err == nil {
+		return nil
+	}
+	code := status.Code(err)
+	err = fmt.Errorf("ошибка при отправке данных на сервер по gRPC: %w", err)
+	switch code {
+	case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded, codes.Aborted:
+		return &retryableError{err: err}
+	}
+	return err
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	config "github.com/SerjZimmer/devops/internal/config/agent"
+	"github.com/SerjZimmer/devops/internal/grpcapi"
+	"github.com/SerjZimmer/devops/internal/storage"
+)
+
+func TestGRPCSender(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	stor := storage.TestMetricStorage()
+	server := grpcapi.NewServer(stor, zap.NewNop(), "testkey", nil, false)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	sender := newGRPCSender(&config.Config{GRPCAddress: listener.Addr().String(), Key: "testkey"})
+	defer sender.conn.Close()
+
+	value := 1.5
+	delta := int64(3)
+	sender.send([]metric{
+		{ID: "HeapAlloc", MType: "gauge", Value: &value},
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+	})
+
+	gauge, err := stor.GetMetricByName(storage.Metrics{ID: "HeapAlloc", MType: "gauge"})
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, gauge)
+	counter, err := stor.GetMetricByName(storage.Metrics{ID: "PollCount", MType: "counter"})
+	require.NoError(t, err)
+	assert.Equal(t, float64(3), counter)
+}
+
+func TestGRPCError(t *testing.T) {
+	assert.NoError(t, grpcError(nil))
+	for _, code := range []codes.Code{codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded, codes.Aborted} {
+		assert.True(t, retryable(grpcError(status.Error(code, "try again"))), code)
+	}
+
+	// Ошибки, которые повтор не исправит, не повторяются и не сохраняются в очередь на диске
+	for _, code := range []codes.Code{codes.InvalidArgument, codes.Unauthenticated, codes.PermissionDenied} {
+		assert.False(t, retryable(grpcError(status.Error(code, "rejected"))), code)
+	}
+}
@@ -1,32 +1,97 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/SerjZimmer/devops/internal/collector"
 	config "github.com/SerjZimmer/devops/internal/config/agent"
-	"github.com/SerjZimmer/devops/internal/storage"
+	"github.com/SerjZimmer/devops/internal/encryption"
+	"github.com/SerjZimmer/devops/internal/spool"
 )
 
 // main является функцией точки входа в приложение.
-// Здесь инициализируются конфигурация, хранилище метрик,
-// а также запускаются горутины для периодического сбора и отправки данных.
+// Здесь инициализируются конфигурация, буфер метрик,
+// а также запускаются коллекторы метрик и пул горутин для отправки данных.
+// По сигналу завершения коллекторы останавливаются, а собранное после последнего отчета отправляется перед выходом.
 func main() {
 
 	printBuildInfo()
 
 	c := config.New()
-	s := storage.NewMetricsStorage(c.Storage)
-	go func() {
-		for {
-			poll(s)
-			time.Sleep(time.Second * time.Duration(c.PollInterval))
+	if c.CryptoKey != "" {
+		key, err := encryption.LoadPublicKey(c.CryptoKey)
+		if err != nil {
+			panic(err)
 		}
-	}()
+		publicKey = key
+	}
+	if c.Tenant != "" && c.GRPCAddress != "" {
+		panic("арендаторы поддерживаются только при отправке по HTTP")
+	}
+	if err := setupTLS(c); err != nil {
+		panic(err)
+	}
+	serverAddress := c.Address
+	if c.GRPCAddress != "" {
+		serverAddress = c.GRPCAddress
+	}
+	if ip, err := outboundIP(serverAddress); err != nil {
+		fmt.Println("Не удалось определить адрес исходящего интерфейса:", err)
+	} else {
+		realIP = ip
+	}
+	if l, err := agentLabels(c); err != nil {
+		fmt.Println("Не удалось определить имя хоста:", err)
+	} else {
+		labels = l
+	}
+	collectors, err := collector.DefaultRegistry().Parse(c.Collectors, time.Duration(c.PollInterval)*time.Second)
+	if err != nil {
+		panic(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	buffer := collector.NewBuffer()
+	collecting := collector.Start(ctx, buffer, collectors)
 
-	for {
-		send(s, c)
-		sendAllInBatches(s, c, 5)
-		time.Sleep(time.Duration(c.ReportInterval) * time.Second)
+	var queue *spool.Queue
+	if c.SpoolDir != "" {
+		q, err := spool.New(c.SpoolDir, int64(c.SpoolMaxSize), c.SpoolMaxAge)
+		if err != nil {
+			panic(err)
+		}
+		queue = q
+	}
+
+	// Отчеты ставятся в очередь, которую разбирают RateLimit отправителей
+	jobs := make(chan job, queueSize)
+	send, size := func(m []metric) error { return sendMetricsBatch(m, c) }, batchSize
+	if c.GRPCAddress != "" {
+		send, size = newGRPCSender(c).send, 0
 	}
+	deliver := deliverFunc(send, queue, jobs)
+	startWorkers(c.RateLimit, jobs, deliver)
 
+	overflow := func(batch []metric) { restoreCounters(buffer, batch) }
+	if queue != nil {
+		overflow = func(batch []metric) { spoolBatch(queue, batch) }
+		go drainSpool(queue, jobs)
+	}
+	for {
+		enqueue(jobs, buildReports(buffer.Snapshot()), size, overflow)
+		select {
+		case <-ctx.Done():
+			collecting.Wait()
+			for _, batch := range splitBatches(buildReports(buffer.Snapshot()), size) {
+				deliver(job{batch: batch})
+			}
+			return
+		case <-time.After(time.Duration(c.ReportInterval) * time.Second):
+		}
+	}
 }
@@ -0,0 +1,12 @@
+package main
+
+// metric - метрика в отчете агента. Агент отправляет только gauge и counter и не зависит от хранилища сервера,
+// но сериализуется в JSON так же, как метрика сервера, поэтому сервер принимает отчеты без преобразования,
+// а пакеты из очереди на диске, сохраненные прежними версиями агента, читаются как есть.
+type metric struct {
+	ID     string            `json:"id"`               // имя метрики
+	MType  string            `json:"type"`             // gauge или counter
+	Delta  *int64            `json:"delta,omitempty"`  // значение метрики в случае передачи counter
+	Value  *float64          `json:"value,omitempty"`  // значение метрики в случае передачи gauge
+	Labels map[string]string `json:"labels,omitempty"` // метки агента
+}
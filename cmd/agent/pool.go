@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// batchSize - число метрик в одном пакете, отправляемом по HTTP.
+const batchSize = 5
+
+// queueSize - емкость очереди отправки в пакетах. Очередь вмещает несколько полных отчетов,
+// чтобы пакеты отбрасывались, только если сервер действительно не успевает их принимать.
+const queueSize = 64
+
+// job - пакет метрик в очереди отправки. У пакета из очереди на диске done сообщает ей результат
+// отправки: done(true) удаляет пакет с диска, done(false) оставляет его там до следующей попытки.
+type job struct {
+	batch []metric
+	done  func(remove bool) error
+}
+
+// startWorkers запускает n горутин, отправляющих пакеты метрик из очереди jobs функцией send,
+// поэтому одновременно выполняется не больше n запросов к серверу. При n < 1 запускается одна горутина.
+// Горутины завершаются после закрытия очереди; возвращаемая группа позволяет дождаться их завершения.
+func startWorkers(n int, jobs <-chan job, send func(job)) *sync.WaitGroup {
+	if n < 1 {
+		n = 1
+	}
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				send(j)
+			}
+		}()
+	}
+	return &wg
+}
+
+// enqueue делит отчет на пакеты по size метрик (при size < 1 - один пакет) и ставит их в очередь jobs.
+// Сбор метрик не ждет отправки: если сервер не успевает принимать метрики и очередь заполнена,
+// пакет передается функции overflow, а если она не задана - отбрасывается. Gauge отброшенного пакета
+// уйдут со следующим отчетом, а приращения counter теряются, поэтому агент всегда задает overflow:
+// пакет сохраняется в очередь на диске или его приращения возвращаются в буфер (см. restoreCounters).
+// Возвращает число пакетов, не попавших в очередь.
+func enqueue(jobs chan<- job, metrics []metric, size int, overflow func([]metric)) int {
+	dropped := 0
+	for _, batch := range splitBatches(metrics, size) {
+		select {
+		case jobs <- job{batch: batch}:
+		default:
+			dropped++
+			if overflow != nil {
+				overflow(batch)
+			}
+		}
+	}
+	if dropped > 0 && overflow == nil {
+		fmt.Println("Очередь отправки заполнена, отброшено пакетов:", dropped)
+	}
+	return dropped
+}
+
+// splitBatches делит отчет на пакеты по size метрик; при size < 1 весь отчет образует один пакет.
+func splitBatches(metrics []metric, size int) [][]metric {
+	if size < 1 {
+		size = len(metrics)
+	}
+	var batches [][]metric
+	for len(metrics) > 0 {
+		n := min(size, len(metrics))
+		batches = append(batches, metrics[:n])
+		metrics = metrics[n:]
+	}
+	return batches
+}
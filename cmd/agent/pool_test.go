@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/SerjZimmer/devops/internal/collector"
+	config "github.com/SerjZimmer/devops/internal/config/agent"
+)
+
+// testReport возвращает отчет из n gauge-метрик.
+func testReport(n int) []metric {
+	metrics := make([]metric, n)
+	for i := range metrics {
+		v := float64(i)
+		metrics[i] = metric{ID: "gauge", MType: "gauge", Value: &v}
+	}
+	return metrics
+}
+
+func TestStartWorkers_RateLimit(t *testing.T) {
+	const limit = 3
+
+	var inFlight, maxInFlight, requests atomic.Int64
+	// Запросы ждут, пока одновременно выполняются limit из них, чтобы тест не зависел от планировщика
+	reached := make(chan struct{})
+	var once sync.Once
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		if n == limit {
+			once.Do(func() { close(reached) })
+		}
+		requests.Add(1)
+		select {
+		case <-reached:
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+	c := &config.Config{Address: server.Listener.Addr().String(), RateLimit: limit}
+
+	jobs := make(chan job, 20)
+	wg := startWorkers(c.RateLimit, jobs, func(j job) { assert.NoError(t, sendMetricsBatch(j.batch, c)) })
+	assert.Zero(t, enqueue(jobs, testReport(100), batchSize, nil))
+	close(jobs)
+	wg.Wait()
+
+	// Все пакеты отправлены, но не больше limit запросов одновременно
+	assert.Equal(t, int64(20), requests.Load())
+	assert.LessOrEqual(t, maxInFlight.Load(), int64(limit))
+	select {
+	case <-reached:
+	default:
+		t.Fatal("не было limit одновременных запросов")
+	}
+}
+
+func TestStartWorkers_DefaultLimit(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int64
+	jobs := make(chan job, 5)
+	wg := startWorkers(0, jobs, func(job) {
+		if n := inFlight.Add(1); n > maxInFlight.Load() {
+			maxInFlight.Store(n)
+		}
+		time.Sleep(time.Millisecond)
+		inFlight.Add(-1)
+	})
+	enqueue(jobs, testReport(5), 1, nil)
+	close(jobs)
+	wg.Wait()
+	assert.Equal(t, int64(1), maxInFlight.Load())
+}
+
+func TestEnqueue_DoesNotBlock(t *testing.T) {
+	// Отправитель завис: сбор отчетов продолжается, лишние пакеты отбрасываются
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	jobs := make(chan job, 1)
+	wg := startWorkers(1, jobs, func(job) {
+		started <- struct{}{}
+		<-release
+	})
+
+	assert.Zero(t, enqueue(jobs, testReport(1), 0, nil))
+	<-started
+
+	done := make(chan int)
+	go func() { done <- enqueue(jobs, testReport(12), batchSize, nil) }()
+	select {
+	case dropped := <-done:
+		assert.Equal(t, 2, dropped)
+	case <-time.After(time.Second):
+		t.Fatal("enqueue заблокирован медленной отправкой")
+	}
+
+	close(release)
+	close(jobs)
+	wg.Wait()
+}
+
+func TestRestoreCounters(t *testing.T) {
+	b := collector.NewBuffer()
+	b.AddCounter("PollCount", 2)
+	report := buildReports(b.Snapshot())
+
+	// Пакет, не попавший в очередь, возвращает приращения counter в буфер
+	jobs := make(chan job)
+	assert.Equal(t, 1, enqueue(jobs, report, 0, func(batch []metric) { restoreCounters(b, batch) }))
+	b.AddCounter("PollCount", 1)
+	assert.Equal(t, map[string]int64{"PollCount": 3}, b.Snapshot().Counters)
+}
+
+func TestSplitBatches(t *testing.T) {
+	batches := splitBatches(testReport(12), batchSize)
+	assert.Len(t, batches, 3)
+	assert.Len(t, batches[2], 2)
+	assert.Len(t, splitBatches(testReport(12), 0), 1)
+	assert.Empty(t, splitBatches(nil, batchSize))
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	config "github.com/SerjZimmer/devops/internal/config/agent"
+)
+
+// retryPolicy описывает повторы неудачных запросов к серверу с экспоненциальной задержкой.
+type retryPolicy struct {
+	// Attempts - число повторов после первой попытки; 0 отключает повторы.
+	Attempts int
+	// BaseDelay - задержка перед первым повтором, удваиваемая перед каждым следующим.
+	BaseDelay time.Duration
+	// MaxDelay ограничивает задержку, в том числе запрошенную сервером в Retry-After.
+	MaxDelay time.Duration
+	// Jitter - случайное отклонение задержки в долях от неё, от 0 до 1.
+	Jitter float64
+}
+
+// newRetryPolicy возвращает политику повторов из конфигурации агента.
+func newRetryPolicy(c *config.Config) retryPolicy {
+	return retryPolicy{
+		Attempts:  c.RetryAttempts,
+		BaseDelay: c.RetryBaseDelay,
+		MaxDelay:  c.RetryMaxDelay,
+		Jitter:    min(max(c.RetryJitter, 0), 1),
+	}
+}
+
+// retryableError - ошибка, после которой запрос можно повторить: сервер недоступен или временно перегружен.
+type retryableError struct {
+	err error
+	// retryAfter - задержка, запрошенная сервером; 0, если сервер её не указал.
+	retryAfter time.Duration
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.err
+}
+
+// retryable сообщает, что ошибка err временная и данные можно отправить позже.
+func retryable(err error) bool {
+	var re *retryableError
+	return errors.As(err, &re)
+}
+
+// transportError оборачивает ошибку HTTP-клиента err в retryableError, если запрос не дошел до сервера
+// из-за сетевого сбоя: ошибки соединения (net.OpError, в том числе при установке соединения) и истечения времени.
+// Ошибки проверки сертификата TLS, некорректного адреса и т.п. не исправятся повтором и возвращаются как есть.
+func transportError(err error) error {
+	var opErr *net.OpError
+	var netErr net.Error
+	if errors.As(err, &opErr) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &retryableError{err: err}
+	}
+	return err
+}
+
+// do выполняет op и повторяет её, пока op возвращает retryableError и не исчерпано число повторов.
+// Возвращает ошибку последней попытки.
+func (p retryPolicy) do(op func() error) error {
+	err := op()
+	for retry := 0; retry < p.Attempts; retry++ {
+		var re *retryableError
+		if !errors.As(err, &re) {
+			return err
+		}
+		delay := p.delay(retry)
+		if re.retryAfter > 0 {
+			delay = min(re.retryAfter, p.MaxDelay)
+		}
+		fmt.Printf("Повтор запроса через %v: %v\n", delay, err)
+		time.Sleep(delay)
+		err = op()
+	}
+	return err
+}
+
+// delay возвращает задержку перед повтором с номером retry, начиная с 0: BaseDelay*2^retry,
+// но не больше MaxDelay, со случайным отклонением на долю Jitter, чтобы агенты не повторяли запросы одновременно.
+func (p retryPolicy) delay(retry int) time.Duration {
+	delay := p.BaseDelay
+	for i := 0; i < retry && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, p.MaxDelay)
+	if p.Jitter > 0 {
+		delay += time.Duration(float64(delay) * p.Jitter * (2*rand.Float64() - 1))
+	}
+	return delay
+}
+
+// checkResponse возвращает ошибку для неуспешного ответа сервера. Ответы 5xx и 429 допускают повтор
+// с учетом заголовка Retry-After; остальные ошибки, например отклоненные сервером метрики, не повторяются.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	err := fmt.Errorf("код ответа: %d", resp.StatusCode)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError {
+		return &retryableError{err: err, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+	}
+	return err
+}
+
+// parseRetryAfter разбирает заголовок Retry-After в секундах или в виде даты HTTP.
+// Для пустого или некорректного заголовка возвращается 0.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(t.Sub(now), 0)
+	}
+	return 0
+}
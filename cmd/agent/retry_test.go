@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	config "github.com/SerjZimmer/devops/internal/config/agent"
+)
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	p := retryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	assert.Equal(t, 100*time.Millisecond, p.delay(0))
+	assert.Equal(t, 200*time.Millisecond, p.delay(1))
+	assert.Equal(t, 800*time.Millisecond, p.delay(3))
+	assert.Equal(t, time.Second, p.delay(4))
+	assert.Equal(t, time.Second, p.delay(100))
+
+	// Отклонение не выходит за долю Jitter от задержки
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := p.delay(1)
+		assert.GreaterOrEqual(t, d, 100*time.Millisecond)
+		assert.LessOrEqual(t, d, 300*time.Millisecond)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, 3*time.Second, parseRetryAfter("3", now))
+	assert.Equal(t, 30*time.Second, parseRetryAfter("Mon, 01 Jan 2024 12:00:30 GMT", now))
+	assert.Zero(t, parseRetryAfter("Mon, 01 Jan 2024 11:00:00 GMT", now))
+	assert.Zero(t, parseRetryAfter("", now))
+	assert.Zero(t, parseRetryAfter("soon", now))
+	assert.Zero(t, parseRetryAfter("-5", now))
+}
+
+func TestTransportError(t *testing.T) {
+	refused := &url.Error{Op: "Post", URL: "http://localhost:1", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}
+	timeout := &url.Error{Op: "Post", URL: "http://localhost:1", Err: context.DeadlineExceeded}
+	assert.True(t, retryable(transportError(fmt.Errorf("send: %w", refused))))
+	assert.True(t, retryable(transportError(timeout)))
+
+	// Ошибки, которые повтор не исправит, не повторяются
+	badCert := &url.Error{Op: "Post", URL: "https://localhost:1", Err: x509.UnknownAuthorityError{}}
+	badScheme := &url.Error{Op: "Post", URL: "ftp://localhost:1", Err: errors.New("unsupported protocol scheme")}
+	assert.False(t, retryable(transportError(badCert)))
+	assert.False(t, retryable(transportError(badScheme)))
+}
+
+// newRetryServer запускает сервер, отвечающий по очереди кодами codes, а затем 200.
+func newRetryServer(t *testing.T, header http.Header, codes ...int) (*httptest.Server, *atomic.Int64) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(requests.Add(1))
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		if n <= len(codes) {
+			w.WriteHeader(codes[n-1])
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestDoReq_Retry(t *testing.T) {
+	c := &config.Config{RetryAttempts: 3, RetryBaseDelay: time.Millisecond, RetryMaxDelay: 10 * time.Millisecond}
+
+	t.Run("Server Errors", func(t *testing.T) {
+		server, requests := newRetryServer(t, nil, http.StatusBadGateway, http.StatusServiceUnavailable)
+		c := *c
+		c.Address = server.Listener.Addr().String()
+		assert.NoError(t, doReq([]byte("test data"), "application/json", "updates", &c))
+		assert.Equal(t, int64(3), requests.Load())
+	})
+
+	t.Run("Attempts Exhausted", func(t *testing.T) {
+		server, requests := newRetryServer(t, nil, 500, 500, 500, 500, 500)
+		c := *c
+		c.Address = server.Listener.Addr().String()
+		assert.Error(t, doReq([]byte("test data"), "application/json", "updates", &c))
+		assert.Equal(t, int64(4), requests.Load())
+	})
+
+	t.Run("Validation Error", func(t *testing.T) {
+		server, requests := newRetryServer(t, nil, http.StatusBadRequest)
+		c := *c
+		c.Address = server.Listener.Addr().String()
+		assert.Error(t, doReq([]byte("test data"), "application/json", "updates", &c))
+		assert.Equal(t, int64(1), requests.Load())
+	})
+
+	t.Run("Too Many Requests", func(t *testing.T) {
+		server, requests := newRetryServer(t, http.Header{"Retry-After": {"1"}}, http.StatusTooManyRequests)
+		c := *c
+		c.Address = server.Listener.Addr().String()
+		c.RetryMaxDelay = 50 * time.Millisecond
+		start := time.Now()
+		assert.NoError(t, doReq([]byte("test data"), "application/json", "updates", &c))
+		assert.Equal(t, int64(2), requests.Load())
+		// Retry-After ограничивается наибольшей задержкой
+		assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("Connection Refused", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		address := listener.Addr().String()
+		listener.Close()
+
+		// Сервер запускается после первой неудачной попытки
+		c := *c
+		c.Address = address
+		c.RetryBaseDelay = 100 * time.Millisecond
+		c.RetryMaxDelay = time.Second
+		var requests atomic.Int64
+		started := make(chan struct{})
+		go func() {
+			defer close(started)
+			time.Sleep(20 * time.Millisecond)
+			l, err := net.Listen("tcp", address)
+			if err != nil {
+				return
+			}
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+			}))
+			server.Listener = l
+			server.Start()
+			t.Cleanup(server.Close)
+		}()
+		err = doReq([]byte("test data"), "application/json", "updates", &c)
+		<-started
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), requests.Load())
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/SerjZimmer/devops/internal/spool"
+)
+
+// spoolBatch сохраняет в очереди на диске приращения counter и histogram из пакета, который не удалось отправить,
+// чтобы после восстановления связи сумма на сервере осталась верной. Значения gauge не сохраняются:
+// следующий отчет содержит актуальное значение, а отправленное позже старое затерло бы его.
+func spoolBatch(q *spool.Queue, batch []metric) {
+	deltas := make([]metric, 0, len(batch))
+	for _, m := range batch {
+		if m.MType != "gauge" {
+			deltas = append(deltas, m)
+		}
+	}
+	if len(deltas) == 0 {
+		return
+	}
+	data, err := json.Marshal(deltas)
+	if err != nil {
+		fmt.Println("Ошибка при маршалинге JSON:", err)
+		return
+	}
+	dropped, err := q.Push(data)
+	if err != nil {
+		fmt.Println("Ошибка при сохранении пакета в очередь на диске:", err)
+		return
+	}
+	if dropped > 0 {
+		fmt.Println("Очередь на диске заполнена, отброшено старых пакетов:", dropped)
+	}
+}
+
+// drainSpool ставит пакеты из очереди на диске в очередь отправки jobs, начиная с самых старых.
+// Разбор не ждет места в очереди отправки: если она заполнена, разбор продолжится после следующей успешной отправки.
+// Пакет удаляется с диска только после того, как отправитель подтвердит его отправку, поэтому он не теряется
+// при аварийном завершении агента, а неотправленный пакет остается в очереди с исходным временем добавления.
+func drainSpool(q *spool.Queue, jobs chan<- job) {
+	_, err := q.Drain(func(data []byte, done func(bool) error) bool {
+		var batch []metric
+		if err := json.Unmarshal(data, &batch); err != nil {
+			fmt.Println("Пропущен поврежденный пакет из очереди на диске:", err)
+			if err := done(true); err != nil {
+				fmt.Println("Ошибка при удалении пакета из очереди на диске:", err)
+			}
+			return true
+		}
+		select {
+		case jobs <- job{batch: batch, done: done}:
+			return true
+		default:
+			return false
+		}
+	})
+	if err != nil {
+		fmt.Println("Ошибка при чтении очереди на диске:", err)
+	}
+}
+
+// deliverFunc возвращает функцию отправки пакета для пула отправителей. Если задана очередь на диске q,
+// пакет, не дошедший до сервера из-за временной ошибки, сохраняется в ней, а после успешной отправки
+// сохраненные пакеты снова ставятся в очередь jobs. Пакет из очереди на диске удаляется из неё после
+// отправки или отказа сервера его принять и остается в ней после временной ошибки.
+func deliverFunc(send func([]metric) error, q *spool.Queue, jobs chan<- job) func(job) {
+	return func(j job) {
+		err := send(j.batch)
+		if err != nil {
+			fmt.Println(err)
+		}
+		switch {
+		case j.done != nil:
+			if err := j.done(err == nil || !retryable(err)); err != nil {
+				fmt.Println("Ошибка при удалении пакета из очереди на диске:", err)
+			}
+		case err != nil && q != nil && retryable(err):
+			spoolBatch(q, j.batch)
+		}
+		if err == nil && q != nil && q.Size() > 0 {
+			go drainSpool(q, jobs)
+		}
+	}
+}
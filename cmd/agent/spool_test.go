@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/SerjZimmer/devops/internal/spool"
+)
+
+func TestDeliverFunc_Spool(t *testing.T) {
+	q, err := spool.New(t.TempDir(), 1<<20, time.Hour)
+	require.NoError(t, err)
+	jobs := make(chan job, 10)
+
+	var sendErr error
+	var sent [][]metric
+	deliver := deliverFunc(func(batch []metric) error {
+		if sendErr != nil {
+			return sendErr
+		}
+		sent = append(sent, batch)
+		return nil
+	}, q, jobs)
+
+	value, delta := 1.5, int64(2)
+	batch := []metric{
+		{ID: "HeapAlloc", MType: "gauge", Value: &value},
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+	}
+
+	// Сервер недоступен: в очереди на диске сохраняется только приращение counter
+	sendErr = &retryableError{err: errors.New("connection refused")}
+	deliver(job{batch: batch})
+	assert.Equal(t, 1, q.Len())
+
+	// Отклоненный сервером пакет не сохраняется
+	sendErr = errors.New("код ответа: 400")
+	deliver(job{batch: batch})
+	assert.Equal(t, 1, q.Len())
+
+	// После успешной отправки сохраненный пакет снова ставится в очередь отправки,
+	// но остается на диске, пока не будет отправлен
+	sendErr = nil
+	deliver(job{batch: batch})
+	var spooled job
+	select {
+	case spooled = <-jobs:
+		require.Len(t, spooled.batch, 1)
+		assert.Equal(t, "PollCount", spooled.batch[0].ID)
+		assert.Equal(t, int64(2), *spooled.batch[0].Delta)
+	case <-time.After(time.Second):
+		t.Fatal("пакет из очереди на диске не поставлен в очередь отправки")
+	}
+	assert.Equal(t, 1, q.Len())
+
+	// Повторная временная ошибка оставляет пакет на диске, не добавляя копию
+	sendErr = &retryableError{err: errors.New("connection refused")}
+	deliver(spooled)
+	assert.Equal(t, 1, q.Len())
+
+	sendErr = nil
+	drainSpool(q, jobs)
+	deliver(<-jobs)
+	assert.Zero(t, q.Len())
+	assert.Len(t, sent, 2)
+}
+
+func TestDrainSpool_QueueFull(t *testing.T) {
+	q, err := spool.New(t.TempDir(), 1<<20, time.Hour)
+	require.NoError(t, err)
+	delta := int64(1)
+	for _, id := range []string{"first", "second"} {
+		spoolBatch(q, []metric{{ID: id, MType: "counter", Delta: &delta}})
+	}
+
+	// Разбор не ждет места в очереди отправки, непоставленный пакет остается на диске
+	jobs := make(chan job, 1)
+	done := make(chan struct{})
+	go func() {
+		drainSpool(q, jobs)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("разбор очереди на диске заблокирован заполненной очередью отправки")
+	}
+	first := <-jobs
+	assert.Equal(t, "first", first.batch[0].ID)
+	require.NoError(t, first.done(true))
+	assert.Equal(t, 1, q.Len())
+
+	drainSpool(q, jobs)
+	assert.Equal(t, "second", (<-jobs).batch[0].ID)
+}
+
+func TestEnqueue_Overflow(t *testing.T) {
+	q, err := spool.New(t.TempDir(), 1<<20, time.Hour)
+	require.NoError(t, err)
+	jobs := make(chan job, 1)
+
+	delta := int64(1)
+	report := []metric{
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "Other", MType: "counter", Delta: &delta},
+	}
+	// Пакет, не поместившийся в очередь отправки, сохраняется на диске
+	assert.Equal(t, 1, enqueue(jobs, report, 1, func(batch []metric) { spoolBatch(q, batch) }))
+	assert.Equal(t, 1, q.Len())
+
+	<-jobs
+	drainSpool(q, jobs)
+	assert.Equal(t, "Other", (<-jobs).batch[0].ID)
+}
@@ -3,14 +3,21 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
-	"crypto/sha256"
-	"encoding/hex"
+	"crypto/rsa"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"github.com/SerjZimmer/devops/internal/collector"
 	config "github.com/SerjZimmer/devops/internal/config/agent"
-	"github.com/SerjZimmer/devops/internal/storage"
+	"github.com/SerjZimmer/devops/internal/encryption"
+	"github.com/SerjZimmer/devops/internal/hash"
+	"github.com/SerjZimmer/devops/internal/tenant"
+	"github.com/SerjZimmer/devops/internal/tlsconfig"
+	"io"
+	"net"
 	"net/http"
-	"runtime"
+	"os"
+	"time"
 )
 
 var (
@@ -19,116 +26,153 @@ var (
 	buildCommit  string
 )
 
-// poll собирает текущие метрики использования памяти и записывает их в хранилище.
-func poll(s *storage.MetricsStorage) {
-	var m runtime.MemStats
-	runtime.ReadMemStats(&m)
-	s.WriteMetrics(m)
-}
+// publicKey - открытый ключ сервера для шифрования тел запросов; nil отключает шифрование.
+var publicKey *rsa.PublicKey
 
-// send отправляет каждую метрику из хранилища на сервер.
-func send(s *storage.MetricsStorage, c *config.Config) {
-
-	s.Mu.Lock()
-	var m storage.Metrics
-	for metricName, metricValue := range s.MetricsMap {
-		m.ID = metricName
-
-		if m.ID != "PollCount" {
-			m.MType = "gauge"
-			m.Value = &metricValue
-		} else {
-			m.MType = "counter"
-			if m.ID == "PollCount" {
-				v := int64(1)
-				m.Delta = &v
-			} else {
-				delta := int64(metricValue)
-				m.Delta = &delta
-			}
-		}
+// tlsConfig - настройки TLS подключения к серверу; nil означает подключение без TLS.
+var tlsConfig *tls.Config
+
+// realIP - адрес исходящего интерфейса агента, передаваемый серверу в заголовке X-Real-IP.
+// Сервер учитывает его при проверке доверенной подсети, только если запрос пришел через доверенный прокси.
+var realIP string
 
-		sendMetric(m, c)
+// labels - метки, добавляемые ко всем отправляемым метрикам.
+var labels map[string]string
+
+// agentLabels возвращает метки агента: host - имя хоста, agent_id - идентификатор агента из конфигурации
+// или, если он не задан, имя хоста.
+func agentLabels(c *config.Config) (map[string]string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	id := c.AgentID
+	if id == "" {
+		id = host
 	}
-	s.Mu.Unlock()
+	return map[string]string{"host": host, "agent_id": id}, nil
 }
 
-// sendAllInBatches отправляет метрики на сервер пакетами заданного размера.
-func sendAllInBatches(s *storage.MetricsStorage, c *config.Config, batchSize int) {
-	s.Mu.Lock()
-	var metrics []storage.Metrics
+// outboundIP возвращает адрес интерфейса, через который агент подключается к серверу address.
+// UDP-сокет не отправляет пакетов при подключении, но позволяет узнать выбранный системой локальный адрес.
+func outboundIP(address string) (string, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
 
-	for metricName, metricValue := range s.MetricsMap {
-		m := storage.Metrics{
-			ID: metricName,
-		}
+// requestTimeout ограничивает время одного HTTP-запроса к серверу, включая чтение ответа,
+// чтобы зависшее соединение не занимало отправителя и попытка считалась неудачной.
+const requestTimeout = 10 * time.Second
 
-		if m.ID != "PollCount" {
-			m.MType = "gauge"
-			m.Value = &metricValue
-		} else {
-			m.MType = "counter"
-			if m.ID == "PollCount" {
-				v := int64(1)
-				m.Delta = &v
-			} else {
-				delta := int64(metricValue)
-				m.Delta = &delta
-			}
-		}
-		metrics = append(metrics, m)
+// httpClient - HTTP-клиент для отправки метрик; при включенном TLS использует tlsConfig.
+var httpClient = &http.Client{Timeout: requestTimeout}
 
-		if len(metrics) == batchSize {
-
-			sendMetricsBatch(metrics, c)
-			metrics = nil
-		}
+// setupTLS включает TLS для HTTP- и gRPC-подключений агента по настройкам из конфигурации.
+func setupTLS(c *config.Config) error {
+	if !c.TLSEnabled() {
+		return nil
 	}
+	config, err := tlsconfig.Client(c.TLSCA, c.TLSCert, c.TLSKey)
+	if err != nil {
+		return err
+	}
+	tlsConfig = config
+	httpClient = &http.Client{Transport: &http.Transport{TLSClientConfig: config}, Timeout: requestTimeout}
+	return nil
+}
 
-	if len(metrics) > 0 {
-		sendMetricsBatch(metrics, c)
+// buildReports формирует из снимка буфера список метрик для отправки: gauge со значением, counter с приращением.
+// К каждой метрике добавляются метки агента.
+func buildReports(s collector.Snapshot) []metric {
+	metrics := make([]metric, 0, len(s.Gauges)+len(s.Counters))
+	for metricName, metricValue := range s.Gauges {
+		value := metricValue
+		metrics = append(metrics, metric{ID: metricName, MType: "gauge", Value: &value, Labels: labels})
 	}
+	for metricName, metricDelta := range s.Counters {
+		delta := metricDelta
+		metrics = append(metrics, metric{ID: metricName, MType: "counter", Delta: &delta, Labels: labels})
+	}
+	return metrics
+}
 
-	s.Mu.Unlock()
+// restoreCounters возвращает в буфер приращения counter из пакета, который не удалось поставить в очередь:
+// снимок буфера их уже обнулил, и без этого они были бы потеряны. Gauge уйдут со следующим отчетом и так.
+func restoreCounters(b *collector.Buffer, batch []metric) {
+	counters := make(map[string]int64)
+	for _, m := range batch {
+		if m.MType == "counter" && m.Delta != nil {
+			counters[m.ID] += *m.Delta
+		}
+	}
+	b.Restore(counters)
 }
 
 // doReq выполняет HTTP-запрос на сервер с сжатием данных.
-func doReq(data []byte, contentType, path string, c *config.Config) {
+// Если задан открытый ключ сервера, сжатое тело шифруется; если задан ключ подписи,
+// передаваемое тело подписывается HMAC-SHA256 в заголовке HashSHA256. Агент арендатора подписывает
+// тело вместе с методом, путем и временем отправки, как требует сервер (см. tenant.SignedData).
+// Запрос, не дошедший до сервера из-за сетевого сбоя или отклоненный им с кодом 5xx или 429, повторяется
+// по политике из конфигурации.
+func doReq(data []byte, contentType, path string, c *config.Config) error {
 	compressedData, err := compressData(data)
 	if err != nil {
-		fmt.Println("Ошибка при сжатии данных:", err)
-		return
+		return fmt.Errorf("ошибка при сжатии данных: %w", err)
 	}
-
-	serverURL := fmt.Sprintf("http://%v/%v/", c.Address, path)
-
-	req, err := http.NewRequest("POST", serverURL, &compressedData)
-	if err != nil {
-		fmt.Println("Ошибка при создании запроса:", err)
-		return
+	body := compressedData.Bytes()
+	if publicKey != nil {
+		body, err = encryption.Encrypt(publicKey, body)
+		if err != nil {
+			return fmt.Errorf("ошибка при шифровании данных: %w", err)
+		}
 	}
 
-	req.Header.Set("Content-Type", contentType)
-	req.Header.Set("Content-Encoding", "gzip")
-	if c.Key != "" {
-		hasher := sha256.New()
-		hasher.Write([]byte(c.Key))
-		hash := hex.EncodeToString(hasher.Sum(nil))
-		req.Header.Set("HashSHA256", hash)
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
 	}
+	serverURL := fmt.Sprintf("%v://%v/%v/", scheme, c.Address, path)
 
-	client := http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Println("Ошибка при отправке данных на сервер:", err, serverURL)
-		return
-	}
-	defer resp.Body.Close()
+	return newRetryPolicy(c).do(func() error {
+		req, err := http.NewRequest("POST", serverURL, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("ошибка при создании запроса: %w", err)
+		}
 
-	if resp.StatusCode != http.StatusOK {
-		fmt.Println("Ошибка при отправке данных на сервер. Код ответа:", resp.StatusCode)
-		return
-	}
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Content-Encoding", "gzip")
+		if publicKey != nil {
+			req.Header.Set(encryption.Header, encryption.Scheme)
+		}
+		if realIP != "" {
+			req.Header.Set("X-Real-IP", realIP)
+		}
+		switch {
+		case c.Tenant != "":
+			ts := tenant.Timestamp(time.Now())
+			req.Header.Set(tenant.Header, c.Tenant)
+			req.Header.Set(tenant.TimestampHeader, ts)
+			req.Header.Set(hash.Header, hash.Sign(c.Key, tenant.SignedData(req.Method, req.URL.RequestURI(), ts, body)))
+		case c.Key != "":
+			req.Header.Set(hash.Header, hash.Sign(c.Key, body))
+		}
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return transportError(fmt.Errorf("ошибка при отправке данных на сервер %v: %w", serverURL, err))
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+
+		if err := checkResponse(resp); err != nil {
+			return fmt.Errorf("ошибка при отправке данных на сервер: %w", err)
+		}
+		return nil
+	})
 }
 
 // compressData сжимает данные с использованием Gzip.
@@ -153,24 +197,25 @@ func compressData(data []byte) (bytes.Buffer, error) {
 }
 
 // sendMetric отправляет отдельную метрику на сервер.
-func sendMetric(m storage.Metrics, c *config.Config) {
+func sendMetric(m metric, c *config.Config) {
 	jsonData, err := json.Marshal(m)
 	if err != nil {
 		fmt.Println("Ошибка при маршалинге JSON:", err)
 		return
 	}
 
-	doReq(jsonData, "application/json", "update", c)
+	if err := doReq(jsonData, "application/json", "update", c); err != nil {
+		fmt.Println(err)
+	}
 }
 
 // sendMetricsBatch отправляет пакет метрик на сервер.
-func sendMetricsBatch(m []storage.Metrics, c *config.Config) {
+func sendMetricsBatch(m []metric, c *config.Config) error {
 	jsonData, err := json.Marshal(m)
 	if err != nil {
-		fmt.Println("Ошибка при маршалинге JSON:", err)
-
+		return fmt.Errorf("ошибка при маршалинге JSON: %w", err)
 	}
-	doReq(jsonData, "application/json", "updates", c)
+	return doReq(jsonData, "application/json", "updates", c)
 }
 
 func printBuildInfo() {
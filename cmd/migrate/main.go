@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/SerjZimmer/devops/internal/storage"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// main применяет или откатывает миграции схемы базы данных сервера метрик.
+// Без флага -down применяются все новые миграции, с флагом схема откатывается до указанной версии.
+func main() {
+	dsn := flag.String("d", os.Getenv("DATABASE_DSN"), "Database DSN")
+	down := flag.Int("down", -1, "Revert migrations down to the given schema version")
+	flag.Parse()
+
+	if err := run(*dsn, *down); err != nil {
+		fmt.Println("Ошибка миграции:", err)
+		os.Exit(1)
+	}
+}
+
+// run подключается к базе данных и переводит её схему в требуемую версию.
+func run(dsn string, down int) error {
+	if dsn == "" {
+		return fmt.Errorf("database DSN is not set")
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if down >= 0 {
+		err = storage.MigrateDown(ctx, db, down)
+	} else {
+		err = storage.MigrateUp(ctx, db)
+	}
+	if err != nil {
+		return err
+	}
+
+	version, err := storage.SchemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Версия схемы:", version)
+	return nil
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"context"
+	"crypto/rsa"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
@@ -13,9 +16,18 @@ import (
 
 	"github.com/SerjZimmer/devops/internal/api"
 	config "github.com/SerjZimmer/devops/internal/config/server"
+	"github.com/SerjZimmer/devops/internal/encryption"
+	"github.com/SerjZimmer/devops/internal/graphite"
+	"github.com/SerjZimmer/devops/internal/grpcapi"
 	"github.com/SerjZimmer/devops/internal/gzip"
+	"github.com/SerjZimmer/devops/internal/statsd"
 	"github.com/SerjZimmer/devops/internal/storage"
+	"github.com/SerjZimmer/devops/internal/subnet"
+	"github.com/SerjZimmer/devops/internal/tlsconfig"
 	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 // main является функцией точки входа в приложение сервера.
@@ -25,11 +37,57 @@ func main() {
 
 	c := config.New()
 	st := storage.NewMetricsStorage(c.Storage)
-	handler := api.NewHandler(st)
+	handler := api.NewHandler(st, c.Key, c.Tenants)
+
+	var privateKey *rsa.PrivateKey
+	if c.CryptoKey != "" {
+		key, err := encryption.LoadPrivateKey(c.CryptoKey)
+		if err != nil {
+			panic(err)
+		}
+		privateKey = key
+	}
+
+	trusted, err := subnet.Parse(c.TrustedSubnet, c.TrustedProxies)
+	if err != nil {
+		panic(err)
+	}
+
+	var tlsConfig *tls.Config
+	if c.TLSCert != "" {
+		var err error
+		if tlsConfig, err = tlsconfig.Server(c.TLSCert, c.TLSKey, c.TLSClientCA); err != nil {
+			panic(err)
+		}
+	}
+
+	var statsdServer *statsd.Server
+	if c.StatsDAddress != "" {
+		statsdServer = statsd.New(c.StatsDAddress, time.Duration(c.StatsDFlushInterval)*time.Second, st, trusted)
+		if err := statsdServer.Start(); err != nil {
+			panic(err)
+		}
+	}
+
+	var graphiteServer *graphite.Server
+	if c.GraphiteAddress != "" {
+		graphiteServer = graphite.New(c.GraphiteAddress, c.GraphiteTemplates, st, trusted)
+		if err := graphiteServer.Start(); err != nil {
+			panic(err)
+		}
+	}
+
+	var grpcServer *grpc.Server
+	if c.GRPCAddress != "" {
+		var err error
+		if grpcServer, err = runGRPC(c, st, tlsConfig, trusted); err != nil {
+			panic(err)
+		}
+	}
 
 	go func() {
-		mRouter(handler)
-		if err := run(c); err != nil {
+		mRouter(handler, privateKey, trusted, c.TrustedSubnetReads)
+		if err := run(c, tlsConfig); err != nil {
 			panic(err)
 		}
 	}()
@@ -42,25 +100,40 @@ func main() {
 	}()
 
 	<-shutdownChan
-	defer st.DB.Close()
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	if err := server.Shutdown(ctx); err != nil {
 		fmt.Printf("Ошибка при завершении работы сервера: %v\n", err)
 	}
 
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+	}
+	if statsdServer != nil {
+		statsdServer.Shutdown()
+	}
+	if graphiteServer != nil {
+		graphiteServer.Shutdown()
+	}
 	st.Shutdown()
 
 	os.Exit(0)
 }
 
 // run запускает HTTP-сервер и обрабатывает сигналы завершения.
-func run(c *config.Config) error {
+// Если заданы настройки tlsConfig, сервер принимает только HTTPS-соединения.
+func run(c *config.Config, tlsConfig *tls.Config) error {
 	fmt.Printf("Сервер запущен на %v\n", c.Address)
 
-	server = &http.Server{Addr: c.Address}
+	server = &http.Server{Addr: c.Address, TLSConfig: tlsConfig}
 	go func() {
-		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		var err error
+		if tlsConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			panic(err)
 		}
 	}()
@@ -77,22 +150,70 @@ func run(c *config.Config) error {
 	return nil
 }
 
+// runGRPC запускает gRPC-сервер метрик на отдельном адресе; при заданных tlsConfig - поверх TLS.
+// Вызовы проверяются на принадлежность доверенной подсети trusted так же, как маршруты HTTP API.
+// Ошибка возвращается, если адрес не удалось занять; ошибки работы запущенного сервера выводятся в лог.
+func runGRPC(c *config.Config, st *storage.MetricsStorage, tlsConfig *tls.Config, trusted *subnet.Trusted) (*grpc.Server, error) {
+	logger, err := zap.NewProduction()
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", c.GRPCAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	var opts []grpc.ServerOption
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	grpcServer := grpcapi.NewServer(st, logger, c.Key, trusted, c.TrustedSubnetReads, opts...)
+	fmt.Printf("gRPC-сервер запущен на %v\n", c.GRPCAddress)
+	go func() {
+		if err := grpcServer.Serve(listener); err != nil {
+			fmt.Println("Ошибка gRPC-сервера:", err)
+		}
+	}()
+	return grpcServer, nil
+}
+
 // mRouter настраивает маршрутизатор для обработчика API.
-func mRouter(handler *api.Handler) {
+// Закрытый ключ privateKey используется для расшифровки тел запросов; nil отключает расшифровку.
+// Маршруты записи метрик доступны только из доверенной подсети trusted, маршруты чтения - при trustedReads.
+// Подпись обязательна только для записи: на маршрутах чтения подписывается ответ.
+// Подсеть проверяется первой, до чтения тела для проверки подписи и его расшифровки.
+func mRouter(handler *api.Handler, privateKey *rsa.PrivateKey, trusted *subnet.Trusted, trustedReads bool) {
 	r := mux.NewRouter()
 
-	r.Use(handler.LoggingMiddleware, gzip.GzipMiddleware, handler.HashSHA256Middleware)
+	r.Use(handler.LoggingMiddleware, handler.MetricsMiddleware)
+
+	protect := func(checkSubnet, checkSign func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return checkSubnet(checkSign(encryption.DecryptMiddleware(privateKey)(gzip.GzipMiddleware(next))))
+		}
+	}
+	readSubnet := api.TrustedSubnetMiddleware(nil)
+	if trustedReads {
+		readSubnet = api.TrustedSubnetMiddleware(trusted)
+	}
+	writes := protect(api.TrustedSubnetMiddleware(trusted), handler.HashSHA256Middleware)
+	reads := protect(readSubnet, handler.SignResponseMiddleware)
+
+	r.Handle("/update/{metricType}/{metricName}/{metricValue}", writes(http.HandlerFunc(handler.UpdateMetric))).Methods("POST")
+	r.Handle("/value/{metricType}/{metricName}", reads(http.HandlerFunc(handler.GetMetric))).Methods("GET")
+	r.Handle("/", reads(http.HandlerFunc(handler.GetMetricsList))).Methods("GET")
 
-	r.HandleFunc("/update/{metricType}/{metricName}/{metricValue}", handler.UpdateMetric).Methods("POST")
-	r.HandleFunc("/value/{metricType}/{metricName}", handler.GetMetric).Methods("GET")
-	r.HandleFunc("/", handler.GetMetricsList).Methods("GET")
+	r.Handle("/update/", writes(http.HandlerFunc(handler.UpdateMetricJSON))).Methods("POST")
+	r.Handle("/updates/", writes(http.HandlerFunc(handler.UpdateMetricsJSON))).Methods("POST")
+	r.Handle("/value/", reads(http.HandlerFunc(handler.GetMetricJSON))).Methods("POST")
+	r.Handle("/write", writes(http.HandlerFunc(handler.WriteInflux))).Methods("POST")
+	r.Handle("/v1/metrics", writes(http.HandlerFunc(handler.OTLPMetrics))).Methods("POST")
 
-	r.HandleFunc("/update/", handler.UpdateMetricJSON).Methods("POST")
-	r.HandleFunc("/updates/", handler.UpdateMetricsJSON).Methods("POST")
-	r.HandleFunc("/value/", handler.GetMetricJSON).Methods("POST")
+	r.Handle("/api/v1/query_range", reads(http.HandlerFunc(handler.QueryRange))).Methods("GET")
+	r.Handle("/metrics", reads(http.HandlerFunc(handler.PrometheusMetrics))).Methods("GET")
 
-	r.HandleFunc("/ping", handler.PingDB).Methods("GET")
-	r.PathPrefix("/debug/pprof/").Handler(http.DefaultServeMux)
+	r.Handle("/ping", reads(http.HandlerFunc(handler.PingDB))).Methods("GET")
+	r.PathPrefix("/debug/pprof/").Handler(reads(http.DefaultServeMux))
 
 	http.Handle("/", r)
 }
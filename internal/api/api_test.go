@@ -3,14 +3,24 @@ package api
 import (
 	"bytes"
 	"context"
+	"io"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 
+	"github.com/SerjZimmer/devops/internal/hash"
 	"github.com/SerjZimmer/devops/internal/storage"
+	"github.com/SerjZimmer/devops/internal/subnet"
+	"github.com/SerjZimmer/devops/internal/tenant"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -25,6 +35,15 @@ func TestIsValidMetrics(t *testing.T) {
 	}
 	assert.True(t, isValidMetrics(validGauge), "Expected isValidMetrics to return true for valid gauge metric, but got false")
 
+	// Метки с недопустимым именем или пустым значением отклоняются
+	labelled := validGauge
+	labelled.Labels = map[string]string{"host": "web-1"}
+	assert.True(t, isValidMetrics(labelled))
+	labelled.Labels = map[string]string{"host.name": "web-1"}
+	assert.False(t, isValidMetrics(labelled))
+	labelled.Labels = map[string]string{"host": ""}
+	assert.False(t, isValidMetrics(labelled))
+
 	// Тест случая, когда метрика является корректным счетчиком
 	validCounter := storage.Metrics{
 		ID:    "ValidCounter",
@@ -49,6 +68,12 @@ func TestIsValidMetrics(t *testing.T) {
 	}
 	assert.False(t, isValidMetrics(invalidID), "Expected isValidMetrics to return false for metric with empty ID, but got true")
 
+	// Символы записи меток в имени сделали бы ключ ряда неоднозначным
+	for _, id := range []string{`HeapAlloc{host="web-1"}`, "a{", "a}", "a,b"} {
+		invalidID.ID = id
+		assert.False(t, isValidMetrics(invalidID), id)
+	}
+
 	// Тест случая, когда MType не равно "gauge" или "counter"
 	invalidMType := storage.Metrics{
 		ID:    "InvalidMType",
@@ -64,6 +89,18 @@ func TestIsValidMetrics(t *testing.T) {
 		Value: nil,
 	}
 	assert.False(t, isValidMetrics(missingValue), "Expected isValidMetrics to return false for gauge metric with missing Value, but got true")
+
+	// Гистограмма должна быть указана и иметь по корзине на каждую границу и корзину +Inf
+	histogram := storage.Metrics{
+		ID:        "Latency",
+		MType:     "histogram",
+		Histogram: &storage.Histogram{Bounds: []float64{0.1, 1}, Counts: []uint64{1, 2, 3}, Sum: 4},
+	}
+	assert.True(t, isValidMetrics(histogram))
+	histogram.Histogram = &storage.Histogram{Bounds: []float64{0.1, 1}, Counts: []uint64{1, 2}}
+	assert.False(t, isValidMetrics(histogram))
+	histogram.Histogram = nil
+	assert.False(t, isValidMetrics(histogram))
 }
 
 func int64Ptr(value int) *int64 {
@@ -101,52 +138,83 @@ func Test_parseNumeric(t *testing.T) {
 	}
 }
 
-// t
-func Test_calculateHash(t *testing.T) {
-	testCases := []struct {
-		input    string
-		expected string
+func TestHashSHA256Middleware(t *testing.T) {
+	const key = "secret"
+	body := []byte(`{"id":"PollCount","type":"counter","delta":1}`)
+
+	tests := []struct {
+		name       string
+		key        string
+		sign       string
+		wantStatus int
+		wantCalled bool
+		wantSigned bool
 	}{
-		{"hello", "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
-		{"world", "486ea46224d1bb4fb680f34f7c9ad96a8f24ec88be73ea8e5a6c65260e9cb8a7"},
+		{name: "valid signature", key: key, sign: hash.Sign(key, body), wantStatus: http.StatusOK, wantCalled: true, wantSigned: true},
+		{name: "wrong key", key: key, sign: hash.Sign("other", body), wantStatus: http.StatusBadRequest},
+		{name: "not hex", key: key, sign: "fake_key", wantStatus: http.StatusBadRequest},
+		{name: "unsigned request", key: key, wantStatus: http.StatusBadRequest},
+		{name: "no key on server", sign: "fake_key", wantStatus: http.StatusOK, wantCalled: true},
 	}
-
-	for _, tc := range testCases {
-		t.Run(tc.input, func(t *testing.T) {
-			result := calculateHash(tc.input)
-			require.Equal(t, tc.expected, result, "Expected value %f for input %s", tc.expected, result)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			mockHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				got, err := io.ReadAll(r.Body)
+				require.NoError(t, err)
+				assert.Equal(t, body, got)
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte("response"))
+			})
+			handler := &Handler{key: tt.key}
+
+			req := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader(body))
+			if tt.sign != "" {
+				req.Header.Set(hash.Header, tt.sign)
+			}
+			w := httptest.NewRecorder()
+			handler.HashSHA256Middleware(mockHandler).ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, tt.wantCalled, called)
+			if tt.wantSigned {
+				assert.Equal(t, hash.Sign(key, w.Body.Bytes()), w.Header().Get(hash.Header))
+				assert.Equal(t, "response", w.Body.String())
+			} else if tt.wantCalled {
+				assert.Empty(t, w.Header().Get(hash.Header))
+			}
 		})
 	}
 }
 
-func TestHashSHA256Middleware(t *testing.T) {
-	// Создаем хендлер, который будет вызван middleware.
+func TestSignResponseMiddleware(t *testing.T) {
+	const key = "secret"
 	mockHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("response"))
 	})
 
-	// Создаем middleware с моком хендлера.
-	handler := &Handler{}
-	middleware := handler.HashSHA256Middleware(mockHandler)
-
-	// Создаем фейковый запрос.
-	req, err := http.NewRequest("GET", "/example", nil)
-	assert.NoError(t, err)
-
-	// Фейковый ключ для хеша SHA256.
-	fakeKey := "fake_key"
-
-	// Добавляем фейковый ключ в заголовок.
-	req.Header.Set("HashSHA256", fakeKey)
-
-	// Создаем фейковый ответ.
+	// Неподписанный запрос на чтение принимается, а ответ подписывается ключом сервера
+	handler := &Handler{key: key}
 	w := httptest.NewRecorder()
-
-	// Вызываем middleware.
-	middleware.ServeHTTP(w, req)
-
-	// Проверяем, что хеш SHA256 был установлен в заголовке ответа.
-	assert.Equal(t, w.Header().Get("HashSHA256"), calculateHash("/example"))
+	handler.SignResponseMiddleware(mockHandler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "response", w.Body.String())
+	assert.Equal(t, hash.Sign(key, w.Body.Bytes()), w.Header().Get(hash.Header))
+
+	// Без ключа на сервере ответ не подписывается
+	w = httptest.NewRecorder()
+	(&Handler{}).SignResponseMiddleware(mockHandler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get(hash.Header))
+
+	// Запрос арендатора по-прежнему должен быть подписан
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set(tenant.Header, "team-a")
+	w = httptest.NewRecorder()
+	handler.SignResponseMiddleware(mockHandler).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
 }
 
 type LoggerKey int
@@ -274,3 +342,391 @@ func TestGetMetricJSON(t *testing.T) {
 		// Добавьте дополнительные проверки для ошибки "Неверный тип метрики"
 	})
 }
+
+// Любой бэкенд хранилища должен удовлетворять интерфейсу metricsStorage.
+var (
+	_ metricsStorage = (*storage.MetricsStorage)(nil)
+	_ metricsStorage = (*storage.MemoryStorage)(nil)
+	_ metricsStorage = (*storage.FileStorage)(nil)
+	_ metricsStorage = (*storage.PostgresStorage)(nil)
+)
+
+func Test_writePrometheus(t *testing.T) {
+	metrics := []storage.Metrics{
+		{ID: "same", MType: "counter", Delta: int64Ptr(7)},
+		{ID: "same", MType: "gauge", Value: float64Ptr(0.5)},
+		{ID: "CPUutilization1", MType: "gauge", Value: float64Ptr(12)},
+		{ID: "1st.metric-name", MType: "gauge", Value: float64Ptr(math.Inf(1))},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, writePrometheus(&buf, metrics, ""))
+	assert.Equal(t, "# TYPE same_total counter\nsame_total 7\n"+
+		"# TYPE same gauge\nsame 0.5\n"+
+		"# TYPE CPUutilization1 gauge\nCPUutilization1 12\n"+
+		"# TYPE _1st_metric_name gauge\n_1st_metric_name +Inf\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, writePrometheus(&buf, metrics[:1], "devops_server_"))
+	assert.Equal(t, "# TYPE devops_server_same counter\ndevops_server_same 7\n", buf.String())
+
+	// Ряды с метками выводятся одним семейством
+	buf.Reset()
+	require.NoError(t, writePrometheus(&buf, []storage.Metrics{
+		{ID: "cpu.load", MType: "gauge", Value: float64Ptr(1)},
+		{ID: "cpu.load", MType: "gauge", Value: float64Ptr(2), Labels: map[string]string{"host": "web-1", "agent_id": `a"b`}},
+		{ID: "cpu_load", MType: "gauge", Value: float64Ptr(3)},
+	}, ""))
+	assert.Equal(t, "# TYPE cpu_load gauge\ncpu_load 1\ncpu_load{agent_id=\"a\\\"b\",host=\"web-1\"} 2\n", buf.String())
+
+	// Гистограмма выводится накопленными корзинами, суммой и числом значений
+	buf.Reset()
+	require.NoError(t, writePrometheus(&buf, []storage.Metrics{
+		{ID: "latency", MType: "histogram", Labels: map[string]string{"host": "web-1"},
+			Histogram: &storage.Histogram{Bounds: []float64{0.1, 1}, Counts: []uint64{2, 1, 1}, Sum: 3.5}},
+	}, ""))
+	assert.Equal(t, "# TYPE latency histogram\n"+
+		"latency_bucket{host=\"web-1\",le=\"0.1\"} 2\n"+
+		"latency_bucket{host=\"web-1\",le=\"1\"} 3\n"+
+		"latency_bucket{host=\"web-1\",le=\"+Inf\"} 4\n"+
+		"latency_sum{host=\"web-1\"} 3.5\n"+
+		"latency_count{host=\"web-1\"} 4\n", buf.String())
+
+	// Сводка выводится квантилями, суммой и числом значений
+	buf.Reset()
+	require.NoError(t, writePrometheus(&buf, []storage.Metrics{
+		{ID: "rpc.duration", MType: "summary", Labels: map[string]string{"host": "web-1"}, Summary: &storage.Summary{
+			Quantiles: []storage.Quantile{{Quantile: 0.5, Value: 0.2}, {Quantile: 0.99, Value: 1.5}}, Count: 10, Sum: 4}},
+	}, ""))
+	assert.Equal(t, "# TYPE rpc_duration summary\n"+
+		"rpc_duration{host=\"web-1\",quantile=\"0.5\"} 0.2\n"+
+		"rpc_duration{host=\"web-1\",quantile=\"0.99\"} 1.5\n"+
+		"rpc_duration_sum{host=\"web-1\"} 4\n"+
+		"rpc_duration_count{host=\"web-1\"} 10\n", buf.String())
+}
+
+func Test_parseInfluxLine(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	p, err := parseInfluxLine(`cpu\ load,host=server\,01,region=eu usage=0.5,cores=8i,up=true,free=3u 1700000000000`, time.Millisecond, now)
+	require.NoError(t, err)
+	assert.Equal(t, "cpu load", p.Measurement)
+	assert.Equal(t, map[string]string{"host": "server,01", "region": "eu"}, p.Tags)
+	assert.Equal(t, []influxField{
+		{Key: "usage", Value: 0.5},
+		{Key: "cores", Value: 8, Integer: true},
+		{Key: "up", Value: 1},
+		{Key: "free", Value: 3, Integer: true},
+	}, p.Fields)
+	assert.Equal(t, time.Unix(1700000000, 0), p.Timestamp)
+
+	// Без метки времени используется текущее время
+	p, err = parseInfluxLine(`mem value=1`, time.Nanosecond, now)
+	require.NoError(t, err)
+	assert.Equal(t, now, p.Timestamp)
+
+	for _, line := range []string{
+		`cpu`,
+		`cpu usage=`,
+		`cpu,host usage=1`,
+		`cpu usage=abc`,
+		`cpu msg="hello world"`,
+		`cpu usage=1 notatime`,
+		`cpu usage=1i2`,
+		`cpu usage=1 9223372036854775807`,
+	} {
+		_, err := parseInfluxLine(line, time.Second, now)
+		assert.Error(t, err, line)
+	}
+}
+
+func Test_influxMetrics(t *testing.T) {
+	metrics, err := influxMetrics(influxPoint{
+		Measurement: "mem",
+		Tags:        map[string]string{},
+		Fields:      []influxField{{Key: "value", Value: 1.5}, {Key: "free", Value: 2}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []storage.Metrics{
+		{ID: "mem", MType: "gauge", Value: float64Ptr(1.5)},
+		{ID: "mem_free", MType: "gauge", Value: float64Ptr(2)},
+	}, metrics)
+
+	metrics, err = influxMetrics(influxPoint{
+		Measurement: "requests",
+		Tags:        map[string]string{"metric_type": "counter"},
+		Fields:      []influxField{{Key: "value", Value: 3, Integer: true}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []storage.Metrics{{ID: "requests", MType: "counter", Delta: int64Ptr(3)}}, metrics)
+
+	// Теги, кроме metric_type, становятся метками ряда
+	metrics, err = influxMetrics(influxPoint{
+		Measurement: "cpu",
+		Tags:        map[string]string{"metric_type": "gauge", "host.name": "web-1"},
+		Fields:      []influxField{{Key: "value", Value: 0.5}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []storage.Metrics{
+		{ID: "cpu", MType: "gauge", Value: float64Ptr(0.5), Labels: map[string]string{"host_name": "web-1"}},
+	}, metrics)
+
+	_, err = influxMetrics(influxPoint{
+		Measurement: "requests",
+		Tags:        map[string]string{"metric_type": "counter"},
+		Fields:      []influxField{{Key: "value", Value: 0.5}},
+	})
+	assert.Error(t, err)
+
+	_, err = influxMetrics(influxPoint{Measurement: "requests", Tags: map[string]string{"metric_type": "histogram"}})
+	assert.Error(t, err)
+
+	// Имя с синтаксисом меток ряда отклоняется
+	_, err = influxMetrics(influxPoint{
+		Measurement: `HeapAlloc{host="web-1"}`,
+		Tags:        map[string]string{},
+		Fields:      []influxField{{Key: "value", Value: 1}},
+	})
+	assert.Error(t, err)
+}
+
+func Test_otlpConverter(t *testing.T) {
+	c := newOTLPConverter()
+	start := c.started + 1
+	resource := &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+		{Key: "host", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "web01"}}},
+	}}
+	request := func(total int64, temperature float64) []*metricspb.ResourceMetrics {
+		return []*metricspb.ResourceMetrics{{
+			Resource: resource,
+			ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{
+				{Name: "requests", Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+					IsMonotonic:            true,
+					AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+					DataPoints: []*metricspb.NumberDataPoint{{
+						StartTimeUnixNano: start,
+						Value:             &metricspb.NumberDataPoint_AsInt{AsInt: total},
+					}},
+				}}},
+				{Name: "temperature", Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+					DataPoints: []*metricspb.NumberDataPoint{{Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: temperature}}},
+				}}},
+				{Name: "latency", Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+					AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+					DataPoints: []*metricspb.HistogramDataPoint{
+						{Count: 4, Sum: float64Ptr(2.5), ExplicitBounds: []float64{0.5, 1}, BucketCounts: []uint64{1, 2, 1}},
+						{Count: 2, Sum: float64Ptr(1)},
+						{Count: 1, ExplicitBounds: []float64{1, 0.5}, BucketCounts: []uint64{0, 1, 0}},
+					},
+				}}},
+				{Name: "sizes", Data: &metricspb.Metric_Summary{Summary: &metricspb.Summary{
+					DataPoints: []*metricspb.SummaryDataPoint{
+						{Count: 3, Sum: 30, QuantileValues: []*metricspb.SummaryDataPoint_ValueAtQuantile{
+							{Quantile: 0.9, Value: 18}, {Quantile: 0.5, Value: 10},
+						}},
+						{Count: 1, QuantileValues: []*metricspb.SummaryDataPoint_ValueAtQuantile{{Quantile: 1.5, Value: 1}}},
+					},
+				}}},
+			}}},
+		}}
+	}
+
+	host := map[string]string{"host": "web01"}
+	metrics, rejected, reason, pending := c.convert("", request(10, 21.5))
+	c.commit(pending)
+	assert.Equal(t, []storage.Metrics{
+		{ID: "requests", MType: "counter", Delta: int64Ptr(10), Labels: host},
+		{ID: "temperature", MType: "gauge", Value: float64Ptr(21.5), Labels: host},
+		{ID: "latency", MType: "histogram", Labels: host,
+			Histogram: &storage.Histogram{Bounds: []float64{0.5, 1}, Counts: []uint64{1, 2, 1}, Sum: 2.5}},
+		{ID: "latency", MType: "histogram", Labels: host,
+			Histogram: &storage.Histogram{Counts: []uint64{2}, Sum: 1}},
+		{ID: "sizes", MType: "summary", Labels: host, Summary: &storage.Summary{
+			Quantiles: []storage.Quantile{{Quantile: 0.5, Value: 10}, {Quantile: 0.9, Value: 18}}, Count: 3, Sum: 30}},
+	}, metrics)
+	assert.Equal(t, int64(2), rejected)
+	assert.Contains(t, reason, "latency")
+
+	// Накопительная сумма превращается в приращение с прошлого экспорта
+	metrics, _, _, pending = c.convert("", request(15, 22))
+	assert.Equal(t, storage.Metrics{ID: "requests", MType: "counter", Delta: int64Ptr(5), Labels: host}, metrics[0])
+
+	// Пока метрики не записаны, накопленное значение не запоминается: повторный экспорт дает то же приращение
+	metrics, _, _, pending = c.convert("", request(15, 22))
+	assert.Equal(t, storage.Metrics{ID: "requests", MType: "counter", Delta: int64Ptr(5), Labels: host}, metrics[0])
+	c.commit(pending)
+	metrics, _, _, _ = c.convert("", request(15, 22))
+	assert.Equal(t, storage.Metrics{ID: "requests", MType: "counter", Delta: int64Ptr(0), Labels: host}, metrics[0])
+
+	// Тот же ряд другого арендатора учитывается отдельно
+	metrics, _, _, _ = c.convert("team-a", request(15, 22))
+	assert.Equal(t, storage.Metrics{ID: "requests", MType: "counter", Delta: int64Ptr(15), Labels: host}, metrics[0])
+
+	// Ряд, начавшийся до запуска сервера, при первом появлении только запоминается
+	cumulative := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	pending = make(otlpPending)
+	delta := func(key string, start uint64, value float64, temporality metricspb.AggregationTemporality) float64 {
+		d, err := c.delta(pending, key, start, value, temporality)
+		require.NoError(t, err)
+		return d
+	}
+	assert.Equal(t, float64(0), delta("old", c.started-1, 100, cumulative))
+	assert.Equal(t, float64(20), delta("old", c.started-1, 120, cumulative))
+	// При сбросе ряда учитывается всё новое значение
+	assert.Equal(t, float64(3), delta("old", c.started-1, 3, cumulative))
+	// Накопительное значение округляется, а дробное значение дельта-агрегации отклоняется
+	assert.Equal(t, float64(2), delta("old", c.started-1, 4.6, cumulative))
+	assert.Equal(t, float64(7), delta("delta", start, 7, metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA))
+	_, err := c.delta(pending, "delta", start, 0.5, metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA)
+	assert.Error(t, err)
+
+	// Накопительная гистограмма превращается в приращения корзин и суммы
+	histogram := func(sum float64, counts ...uint64) *storage.Histogram {
+		return &storage.Histogram{Bounds: []float64{1}, Counts: counts, Sum: sum}
+	}
+	histogramDelta := func(key string, start uint64, h *storage.Histogram) *storage.Histogram {
+		d, err := c.histogramDelta(pending, key, start, h, cumulative)
+		require.NoError(t, err)
+		return d
+	}
+	assert.Equal(t, histogram(3, 1, 2), histogramDelta("h", start, histogram(3, 1, 2)))
+	assert.Equal(t, histogram(2, 2, 0), histogramDelta("h", start, histogram(5, 3, 2)))
+	assert.Equal(t, histogram(1, 1, 0), histogramDelta("h", start, histogram(1, 1, 0)))
+	assert.Nil(t, histogramDelta("old-h", c.started-1, histogram(1, 1, 0)))
+}
+
+func Test_otlpConverter_InvalidName(t *testing.T) {
+	c := newOTLPConverter()
+	point := &metricspb.NumberDataPoint{Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 1}}
+
+	// Метрика с синтаксисом меток ряда в имени отклоняется вместе со всеми точками
+	metrics, rejected, reason, _ := c.convert("", []*metricspb.ResourceMetrics{{
+		ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{
+			{Name: `HeapAlloc{host="web-1"}`, Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+				DataPoints: []*metricspb.NumberDataPoint{point, point},
+			}}},
+			{Name: "", Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+				DataPoints: []*metricspb.NumberDataPoint{point},
+			}}},
+		}}},
+	}})
+	assert.Empty(t, metrics)
+	assert.Equal(t, int64(3), rejected)
+	assert.Contains(t, reason, "invalid metric name")
+}
+
+func Test_otlpConverter_Limits(t *testing.T) {
+	c := newOTLPConverter()
+	c.limit = 2
+	cumulative := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+
+	pending := make(otlpPending)
+	for _, key := range []string{"a", "b"} {
+		_, err := c.delta(pending, key, c.started+1, 1, cumulative)
+		require.NoError(t, err)
+	}
+	c.commit(pending)
+
+	// Новые ряды сверх лимита отклоняются, известные продолжают учитываться
+	pending = make(otlpPending)
+	_, err := c.delta(pending, "c", c.started+1, 1, cumulative)
+	assert.Error(t, err)
+	d, err := c.delta(pending, "a", c.started+1, 3, cumulative)
+	require.NoError(t, err)
+	assert.Equal(t, float64(2), d)
+	c.commit(pending)
+
+	// Ряды, не обновлявшиеся дольше ttl, забываются и освобождают место
+	c.ttl = time.Minute
+	b := c.cumulative["b"]
+	b.updated = time.Now().Add(-2 * time.Minute)
+	c.cumulative["b"] = b
+	c.expired = b.updated
+	c.commit(nil)
+	assert.NotContains(t, c.cumulative, "b")
+	assert.Contains(t, c.cumulative, "a")
+	_, err = c.delta(make(otlpPending), "c", c.started+1, 1, cumulative)
+	assert.NoError(t, err)
+}
+
+func Test_parseRangeQuery(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	t.Run("Defaults", func(t *testing.T) {
+		q, err := parseRangeQuery(url.Values{"name": {"HeapAlloc"}}, now)
+		require.NoError(t, err)
+		assert.Equal(t, storage.RangeQuery{ID: "HeapAlloc", From: now.Add(-time.Hour), To: now}, q)
+	})
+
+	t.Run("Explicit Range", func(t *testing.T) {
+		q, err := parseRangeQuery(url.Values{
+			"name":  {"PollCount"},
+			"type":  {"counter"},
+			"from":  {"1699999000.5"},
+			"to":    {"2023-11-14T22:13:20Z"},
+			"step":  {"30s"},
+			"match": {"host=web-1"},
+		}, now)
+		require.NoError(t, err)
+		assert.Equal(t, "counter", q.MType)
+		assert.Equal(t, map[string]string{"host": "web-1"}, q.Labels)
+		assert.True(t, q.From.Equal(time.Unix(1699999000, 5e8)))
+		assert.True(t, q.To.Equal(now))
+		assert.Equal(t, 30*time.Second, q.Step)
+	})
+
+	errorCases := map[string]url.Values{
+		"Missing Name":   {},
+		"Invalid Type":   {"name": {"m"}, "type": {"timer"}},
+		"Invalid Agg":    {"name": {"m"}, "agg": {"median"}},
+		"Invalid From":   {"name": {"m"}, "from": {"yesterday"}},
+		"From After To":  {"name": {"m"}, "from": {"1700000001"}},
+		"Negative Step":  {"name": {"m"}, "step": {"-1"}},
+		"Too Many Point": {"name": {"m"}, "step": {"0.1"}},
+		"Invalid Match":  {"name": {"m"}, "match": {"host"}},
+		"Negated Match":  {"name": {"m"}, "match": {"host!=web-1"}},
+	}
+	for name, values := range errorCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseRangeQuery(values, now)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestTrustedSubnetMiddleware(t *testing.T) {
+	trusted, err := subnet.Parse("192.168.1.0/24", []string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		trusted    *subnet.Trusted
+		realIP     string
+		remoteAddr string
+		wantStatus int
+	}{
+		{name: "header from proxy in subnet", trusted: trusted, realIP: "192.168.1.10", remoteAddr: "10.0.0.1:1234", wantStatus: http.StatusOK},
+		{name: "header from proxy outside subnet", trusted: trusted, realIP: "172.16.0.1", remoteAddr: "10.0.0.1:1234", wantStatus: http.StatusForbidden},
+		{name: "header from client ignored", trusted: trusted, realIP: "192.168.1.10", remoteAddr: "127.0.0.1:1234", wantStatus: http.StatusForbidden},
+		{name: "invalid header from proxy", trusted: trusted, realIP: "not-an-ip", remoteAddr: "10.0.0.1:1234", wantStatus: http.StatusForbidden},
+		{name: "remote address in subnet", trusted: trusted, remoteAddr: "192.168.1.20:1234", wantStatus: http.StatusOK},
+		{name: "remote address outside subnet", trusted: trusted, remoteAddr: "127.0.0.1:1234", wantStatus: http.StatusForbidden},
+		{name: "no subnet", realIP: "10.0.0.1", remoteAddr: "10.0.0.1:1234", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			req := httptest.NewRequest(http.MethodPost, "/updates/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			w := httptest.NewRecorder()
+			TrustedSubnetMiddleware(tt.trusted)(next).ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
@@ -1,22 +1,36 @@
 package api
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"mime"
 	"net/http"
 	"net/http/httputil"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/SerjZimmer/devops/internal/hash"
+	"github.com/SerjZimmer/devops/internal/selfmetrics"
 	"github.com/SerjZimmer/devops/internal/storage"
+	"github.com/SerjZimmer/devops/internal/tenant"
 	_ "github.com/jackc/pgx/v4"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
 	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 )
 
 // metricsStorage представляет интерфейс для взаимодействия с хранилищем метрик.
 type metricsStorage interface {
+	GetMetric(m storage.Metrics) (storage.Metrics, error)
 	GetMetricByName(m storage.Metrics) (float64, error)
+	ListMetrics() ([]storage.Metrics, error)
 	UpdateMetricValue(m storage.Metrics) error
 	UpdateMetricsValue(m []storage.Metrics) error
 	SortMetricByName() []string
@@ -24,17 +38,93 @@ type metricsStorage interface {
 	PingDB() error
 }
 
-// HashSHA256Middleware представляет middleware для проверки хеша SHA256.
+// tenantStorage представляет хранилище, разделенное по арендаторам.
+type tenantStorage interface {
+	Tenant(name string) *storage.MetricsStorage
+}
+
+// timedUpdater представляет хранилище, записывающее в историю значения метрик с их собственными метками времени.
+type timedUpdater interface {
+	UpdateMetricsValueAt(m []storage.Metrics, times []time.Time) error
+}
+
+// rangeQuerier представляет хранилище, поддерживающее запросы истории значений метрик.
+type rangeQuerier interface {
+	QueryRange(q storage.RangeQuery) (storage.Series, error)
+}
+
+// HashSHA256Middleware представляет middleware для аутентификации арендатора и проверки подписи HMAC-SHA256.
+//
+// Запрос с заголовком X-Tenant должен быть подписан одним из ключей этого арендатора вместе с методом, путем
+// и временем подписи (tenant.SignedData), иначе возвращается 401; подпись с истекшим временем тоже отклоняется с 401.
+// Запрос без заголовка относится к арендатору по умолчанию: если на сервере задан ключ, запрос должен быть
+// подписан им, иначе возвращается 400. Подпись сверяется с телом запроса в том виде, в каком оно пришло
+// (до распаковки), и при несовпадении также возвращается 400. Тело ответа подписывается тем же ключом.
 func (s *Handler) HashSHA256Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		key := r.Header.Get("HashSHA256")
-		if key != "" {
-			data := r.URL.Path
-			responseHash := calculateHash(data)
-			w.Header().Set("HashSHA256", responseHash)
+		name := r.Header.Get(tenant.Header)
+		sign := r.Header.Get(hash.Header)
+		if name == "" && s.key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if name != "" {
+			if _, known := s.tenants[name]; !known || sign == "" {
+				http.Error(w, "Неизвестный арендатор или запрос без подписи", http.StatusUnauthorized)
+				return
+			}
+			if err := tenant.CheckTimestamp(r.Header.Get(tenant.TimestampHeader), time.Now()); err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+		} else if sign == "" {
+			http.Error(w, "Запрос без подписи", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			http.Error(w, "Ошибка чтения тела запроса", http.StatusBadRequest)
+			return
+		}
+		key := s.key
+		var ok bool
+		if name != "" {
+			data := tenant.SignedData(r.Method, r.URL.RequestURI(), r.Header.Get(tenant.TimestampHeader), body)
+			key, ok = s.tenants.Match(name, data, sign)
+		} else {
+			ok = hash.Verify(s.key, body, sign)
 		}
+		if !ok {
+			http.Error(w, "Неверная подпись запроса", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		sw := &signingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(tenant.NewContext(r.Context(), name)))
+		sw.flush(key)
+	})
+}
 
-		next.ServeHTTP(w, r)
+// SignResponseMiddleware представляет middleware для маршрутов чтения. Запрос арендатора проверяется так же,
+// как в HashSHA256Middleware, а запрос арендатора по умолчанию принимается и без подписи, чтобы метрики могли
+// читать браузеры и сборщики Prometheus; если на сервере задан ключ, тело ответа подписывается им.
+func (s *Handler) SignResponseMiddleware(next http.Handler) http.Handler {
+	verify := s.HashSHA256Middleware(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(tenant.Header) != "" {
+			verify.ServeHTTP(w, r)
+			return
+		}
+		if s.key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sw := &signingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		sw.flush(s.key)
 	})
 }
 
@@ -61,19 +151,61 @@ func (s *Handler) LoggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// MetricsMiddleware представляет middleware для учета обработанных запросов в собственных метриках сервера.
+func (s *Handler) MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startTime := time.Now()
+		rw := &responseWriterWithStatus{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rw, r)
+
+		s.self.Add("http_requests_total", 1)
+		s.self.Add("http_request_duration_microseconds_total", time.Since(startTime).Microseconds())
+		switch {
+		case rw.status >= 500:
+			s.self.Add("http_responses_5xx_total", 1)
+		case rw.status >= 400:
+			s.self.Add("http_responses_4xx_total", 1)
+		}
+	})
+}
+
+// countUpdates учитывает в собственных метриках сервера принятые и отклоненные хранилищем обновления метрик.
+func (s *Handler) countUpdates(n int, err error) {
+	if err != nil {
+		s.self.Add("metric_update_errors_total", int64(n))
+		return
+	}
+	s.self.Add("metric_updates_total", int64(n))
+}
+
 // PingDB обрабатывает HTTP GET-запрос для проверки доступности базы данных.
 func (s *Handler) PingDB(w http.ResponseWriter, r *http.Request) {
-	if err := s.stor.PingDB(); err != nil {
+	if err := s.storage(r).PingDB(); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
 // GetMetricsList обрабатывает HTTP GET-запрос для получения списка всех метрик в виде HTML.
+// Параметры match ограничивают список рядами с подходящими метками.
 func (s *Handler) GetMetricsList(w http.ResponseWriter, r *http.Request) {
+	matchers, err := parseMatchers(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
-	metricsString := s.stor.GetAllMetrics()
+	metricsString := s.storage(r).GetAllMetrics()
+	if len(matchers) > 0 {
+		stored, err := s.storage(r).ListMetrics()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		metricsString = formatMetrics(filterMetrics(stored, matchers))
+	}
 
 	metrics := strings.Split(metricsString, "\n")
 
@@ -91,6 +223,9 @@ func (s *Handler) GetMetricsList(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetMetric обрабатывает HTTP GET-запрос для получения значения конкретной метрики в формате JSON.
+// Ряд метрики с метками выбирается параметрами match, см. findMetric.
+// Для histogram возвращается гистограмма целиком, а с параметром quantile - оценка квантиля.
+// Для summary возвращается сводка целиком, а с параметром quantile - значение одного из присланных квантилей.
 func (s *Handler) GetMetric(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if r.Method != http.MethodGet {
@@ -106,22 +241,66 @@ func (s *Handler) GetMetric(w http.ResponseWriter, r *http.Request) {
 	metricType := parts[2]
 	metricName := parts[3]
 
-	if metricType != "gauge" && metricType != "counter" {
+	if !validType(metricType) {
 		http.Error(w, "Неверный тип метрики", http.StatusNotFound)
 		return
 	}
 
+	matchers, err := parseMatchers(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	var m storage.Metrics
 	m.ID = metricName
+	m.MType = metricType
 
-	value, err := s.stor.GetMetricByName(m)
+	stored, err := findMetric(s.storage(r), m, matchers)
+	if errors.Is(err, errAmbiguousMetric) {
+		http.Error(w, "Условиям соответствует несколько рядов метрики", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Неверное имя метрики", http.StatusNotFound)
 		return
 	}
 
+	if (stored.MType == "histogram" || stored.MType == "summary") && r.URL.Query().Has("quantile") {
+		q, err := strconv.ParseFloat(r.URL.Query().Get("quantile"), 64)
+		if err != nil || q < 0 || q > 1 {
+			http.Error(w, "Квантиль должен быть числом от 0 до 1", http.StatusBadRequest)
+			return
+		}
+		var value float64
+		if stored.MType == "summary" {
+			var ok bool
+			if value, ok = stored.Summary.Value(q); !ok {
+				http.Error(w, "Сводка не содержит такого квантиля", http.StatusNotFound)
+				return
+			}
+		} else if value = stored.Histogram.Quantile(q); math.IsNaN(value) {
+			http.Error(w, "Гистограмма пуста", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(value); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
-	err = json.NewEncoder(w).Encode(value)
+	switch stored.MType {
+	case "counter":
+		err = json.NewEncoder(w).Encode(*stored.Delta)
+	case "histogram":
+		err = json.NewEncoder(w).Encode(stored.Histogram)
+	case "summary":
+		err = json.NewEncoder(w).Encode(stored.Summary)
+	default:
+		err = json.NewEncoder(w).Encode(*stored.Value)
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -129,8 +308,15 @@ func (s *Handler) GetMetric(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetMetricJSON обрабатывает HTTP POST-запрос для получения значения метрики из тела запроса в формате JSON.
+// Ряд метрики выбирается по меткам из тела и параметрам match, см. findMetric.
 func (s *Handler) GetMetricJSON(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
+	matchers, err := parseMatchers(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	var m storage.Metrics
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&m); err != nil {
@@ -138,33 +324,23 @@ func (s *Handler) GetMetricJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if m.MType != "gauge" && m.MType != "counter" {
+	if !validType(m.MType) {
 		http.Error(w, "Неверный тип метрики", http.StatusBadRequest)
 		return
 	}
 
-	value, err := s.stor.GetMetricByName(m)
-	if err != nil {
-		http.Error(w, "Неверное  имя метрики", http.StatusNotFound)
+	stored, err := findMetric(s.storage(r), m, matchers)
+	if errors.Is(err, errAmbiguousMetric) {
+		http.Error(w, "Условиям соответствует несколько рядов метрики", http.StatusBadRequest)
 		return
 	}
-
-	if m.MType == "counter" {
-		iv := int64(value)
-		m.Delta = &iv
-		w.WriteHeader(http.StatusOK)
-		err = json.NewEncoder(w).Encode(m)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
+	if err != nil {
+		http.Error(w, "Неверное  имя метрики", http.StatusNotFound)
 		return
 	}
-	m.Value = &value
 
 	w.WriteHeader(http.StatusOK)
-	err = json.NewEncoder(w).Encode(m)
+	err = json.NewEncoder(w).Encode(stored)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -190,6 +366,10 @@ func (s *Handler) UpdateMetric(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Неверный тип метрики", http.StatusBadRequest)
 		return
 	}
+	if !storage.ValidID(metricName) {
+		http.Error(w, "Некорректное имя метрики", http.StatusBadRequest)
+		return
+	}
 
 	value, err := parseNumeric(metricValue)
 	if err != nil {
@@ -204,7 +384,8 @@ func (s *Handler) UpdateMetric(w http.ResponseWriter, r *http.Request) {
 	m.Delta = &iv
 	m.Value = &value
 
-	err = s.stor.UpdateMetricValue(m)
+	err = s.storage(r).UpdateMetricValue(m)
+	s.countUpdates(1, err)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -239,7 +420,8 @@ func (s *Handler) UpdateMetricJSON(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Некорректные данные в JSON", http.StatusBadRequest)
 		return
 	}
-	err = s.stor.UpdateMetricValue(m)
+	err = s.storage(r).UpdateMetricValue(m)
+	s.countUpdates(1, err)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -265,8 +447,27 @@ func (s *Handler) UpdateMetricsJSON(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Ошибка при разборе JSON", http.StatusBadRequest)
 		return
 	}
+	for _, metric := range m {
+		if !storage.ValidID(metric.ID) {
+			http.Error(w, "Некорректное имя метрики в JSON", http.StatusBadRequest)
+			return
+		}
+		if !storage.ValidLabels(metric.Labels) {
+			http.Error(w, "Некорректные метки в JSON", http.StatusBadRequest)
+			return
+		}
+		if metric.MType == "histogram" && !isValidMetrics(metric) {
+			http.Error(w, "Некорректная гистограмма в JSON", http.StatusBadRequest)
+			return
+		}
+		if metric.MType == "summary" && !isValidMetrics(metric) {
+			http.Error(w, "Некорректная сводка в JSON", http.StatusBadRequest)
+			return
+		}
+	}
 
-	err := s.stor.UpdateMetricsValue(m)
+	err := s.storage(r).UpdateMetricsValue(m)
+	s.countUpdates(len(m), err)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -282,4 +483,203 @@ func (s *Handler) UpdateMetricsJSON(w http.ResponseWriter, r *http.Request) {
 
 }
 
-//
+// WriteInflux обрабатывает HTTP POST-запрос записи метрик в формате строкового протокола InfluxDB.
+// Параметр precision (ns, us, ms, s) задает единицы меток времени. Корректные строки записываются
+// одним пакетом, даже если в других строках есть ошибки; ошибки возвращаются в ответе с номерами строк.
+// В историю значения попадают с метками времени своих строк.
+func (s *Handler) WriteInflux(w http.ResponseWriter, r *http.Request) {
+	precision, ok := influxPrecisions[r.URL.Query().Get("precision")]
+	if !ok {
+		http.Error(w, "Неверное значение precision", http.StatusBadRequest)
+		return
+	}
+
+	var (
+		metrics []storage.Metrics
+		times   []time.Time
+		result  influxWriteResult
+		lineNo  int
+	)
+	now := time.Now()
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		point, err := parseInfluxLine(line, precision, now)
+		if err != nil {
+			result.Errors = append(result.Errors, influxLineError{Line: lineNo, Error: err.Error()})
+			continue
+		}
+		lineMetrics, err := influxMetrics(point)
+		if err != nil {
+			result.Errors = append(result.Errors, influxLineError{Line: lineNo, Error: err.Error()})
+			continue
+		}
+		metrics = append(metrics, lineMetrics...)
+		for range lineMetrics {
+			times = append(times, point.Timestamp)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		http.Error(w, "Ошибка при чтении тела запроса", http.StatusBadRequest)
+		return
+	}
+
+	if len(metrics) > 0 {
+		var err error
+		if u, ok := s.storage(r).(timedUpdater); ok {
+			err = u.UpdateMetricsValueAt(metrics, times)
+		} else {
+			err = s.storage(r).UpdateMetricsValue(metrics)
+		}
+		s.countUpdates(len(metrics), err)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if len(result.Errors) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	result.Accepted = len(metrics)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// OTLPMetrics обрабатывает HTTP POST-запрос экспорта метрик OpenTelemetry (OTLP/HTTP) в формате protobuf или JSON.
+// Поддерживаемые точки записываются одним пакетом, число отклоненных точек возвращается в partial_success.
+func (s *Handler) OTLPMetrics(w http.ResponseWriter, r *http.Request) {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != otlpProtobufContentType && contentType != otlpJSONContentType {
+		http.Error(w, "Неподдерживаемый тип содержимого", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Ошибка при разборе r.Body", http.StatusBadRequest)
+		return
+	}
+
+	var req colmetricspb.ExportMetricsServiceRequest
+	if contentType == otlpJSONContentType {
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(body, &req)
+	} else {
+		err = proto.Unmarshal(body, &req)
+	}
+	if err != nil {
+		http.Error(w, "Ошибка при разборе запроса OTLP", http.StatusBadRequest)
+		return
+	}
+
+	metrics, rejected, reason, pending := s.otlp.convert(tenant.FromContext(r.Context()), req.GetResourceMetrics())
+	if len(metrics) > 0 {
+		err := s.storage(r).UpdateMetricsValue(metrics)
+		s.countUpdates(len(metrics), err)
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+	}
+	s.otlp.commit(pending)
+
+	var resp colmetricspb.ExportMetricsServiceResponse
+	if rejected > 0 {
+		resp.PartialSuccess = &colmetricspb.ExportMetricsPartialSuccess{
+			RejectedDataPoints: rejected,
+			ErrorMessage:       reason,
+		}
+	}
+	var data []byte
+	if contentType == otlpJSONContentType {
+		data, err = protojson.Marshal(&resp)
+	} else {
+		data, err = proto.Marshal(&resp)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// PrometheusMetrics обрабатывает HTTP GET-запрос всех метрик в текстовом формате экспорта Prometheus.
+// Метрики хранилища выводятся под своими именами, собственные метрики сервера - с префиксом selfmetrics.Namespace;
+// арендаторам собственные метрики сервера не показываются. Параметры match ограничивают вывод
+// рядами с подходящими метками, и тогда собственные метрики сервера не выводятся.
+func (s *Handler) PrometheusMetrics(w http.ResponseWriter, r *http.Request) {
+	matchers, err := parseMatchers(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	metrics, err := s.storage(r).ListMetrics()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := writePrometheus(&buf, filterMetrics(metrics, matchers), ""); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if tenant.FromContext(r.Context()) == "" && len(matchers) == 0 {
+		if err := writePrometheus(&buf, s.self.Metrics(), selfmetrics.Namespace); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", prometheusContentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// QueryRange обрабатывает HTTP GET-запрос истории значений метрики за интервал времени в формате JSON.
+// Параметры запроса: name, type, from, to (unix-время или RFC 3339), step (длительность или секунды),
+// agg - поле агрегатов для уплотнённой истории (min, max, avg, last, sum, rate) и match - метки ряда.
+func (s *Handler) QueryRange(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	querier, ok := s.storage(r).(rangeQuerier)
+	if !ok {
+		http.Error(w, "История метрик не поддерживается", http.StatusNotImplemented)
+		return
+	}
+
+	q, err := parseRangeQuery(r.URL.Query(), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	series, err := querier.QueryRange(q)
+	if errors.Is(err, storage.ErrHistoryDisabled) {
+		http.Error(w, "История метрик отключена", http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Неверное имя метрики", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(series)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
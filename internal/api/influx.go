@@ -0,0 +1,224 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SerjZimmer/devops/internal/storage"
+)
+
+// influxTypeTag - тег строки протокола InfluxDB, задающий тип метрики: gauge (по умолчанию) или counter.
+const influxTypeTag = "metric_type"
+
+// influxPoint представляет собой точку, разобранную из строки протокола InfluxDB.
+type influxPoint struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      []influxField
+	Timestamp   time.Time
+}
+
+// influxField представляет собой числовое поле точки InfluxDB.
+type influxField struct {
+	Key     string
+	Value   float64
+	Integer bool
+}
+
+// influxLineError описывает ошибку разбора отдельной строки тела запроса.
+type influxLineError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// influxWriteResult представляет собой ответ на запрос записи с ошибками: число принятых метрик и ошибки по строкам.
+type influxWriteResult struct {
+	Accepted int               `json:"accepted"`
+	Errors   []influxLineError `json:"errors"`
+}
+
+// influxPrecisions сопоставляет значения параметра precision единицам времени меток.
+var influxPrecisions = map[string]time.Duration{
+	"":   time.Nanosecond,
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+}
+
+// parseInfluxLine разбирает строку протокола InfluxDB: measurement[,tag=value...] field=value[,...] [timestamp].
+// Метка времени без указания интерпретируется в единицах precision; при её отсутствии используется now.
+func parseInfluxLine(line string, precision time.Duration, now time.Time) (influxPoint, error) {
+	p := influxPoint{Tags: make(map[string]string), Timestamp: now}
+
+	sections := splitInflux(line, ' ', true)
+	if len(sections) < 2 || len(sections) > 3 {
+		return p, fmt.Errorf("ожидается measurement, поля и необязательная метка времени")
+	}
+
+	series := splitInflux(sections[0], ',', false)
+	p.Measurement = unescapeInflux(series[0])
+	if p.Measurement == "" {
+		return p, fmt.Errorf("пустое имя measurement")
+	}
+	for _, tag := range series[1:] {
+		key, value, found := cutInflux(tag, '=')
+		if !found || key == "" || value == "" {
+			return p, fmt.Errorf("неверный тег: %q", tag)
+		}
+		p.Tags[unescapeInflux(key)] = unescapeInflux(value)
+	}
+
+	for _, field := range splitInflux(sections[1], ',', true) {
+		key, value, found := cutInflux(field, '=')
+		if !found || key == "" || value == "" {
+			return p, fmt.Errorf("неверное поле: %q", field)
+		}
+		f, err := parseInfluxFieldValue(value)
+		if err != nil {
+			return p, fmt.Errorf("поле %s: %w", unescapeInflux(key), err)
+		}
+		f.Key = unescapeInflux(key)
+		p.Fields = append(p.Fields, f)
+	}
+
+	if len(sections) == 3 {
+		ts, err := strconv.ParseInt(sections[2], 10, 64)
+		if err != nil {
+			return p, fmt.Errorf("неверная метка времени: %q", sections[2])
+		}
+		if ts > math.MaxInt64/int64(precision) || ts < math.MinInt64/int64(precision) {
+			return p, fmt.Errorf("метка времени вне допустимого диапазона: %q", sections[2])
+		}
+		p.Timestamp = time.Unix(0, ts*int64(precision))
+	}
+	return p, nil
+}
+
+// parseInfluxFieldValue разбирает значение поля: число с плавающей точкой, целое (суффиксы i и u) или логическое.
+// Строковые поля не поддерживаются, так как не отображаются в числовые метрики.
+func parseInfluxFieldValue(v string) (influxField, error) {
+	switch v {
+	case "t", "T", "true", "True", "TRUE":
+		return influxField{Value: 1}, nil
+	case "f", "F", "false", "False", "FALSE":
+		return influxField{Value: 0}, nil
+	}
+	if strings.HasPrefix(v, `"`) {
+		return influxField{}, fmt.Errorf("строковые поля не поддерживаются")
+	}
+	if n, ok := strings.CutSuffix(v, "i"); ok {
+		i, err := strconv.ParseInt(n, 10, 64)
+		if err != nil {
+			return influxField{}, fmt.Errorf("неверное целое значение: %q", v)
+		}
+		return influxField{Value: float64(i), Integer: true}, nil
+	}
+	if n, ok := strings.CutSuffix(v, "u"); ok {
+		u, err := strconv.ParseUint(n, 10, 64)
+		if err != nil {
+			return influxField{}, fmt.Errorf("неверное беззнаковое значение: %q", v)
+		}
+		return influxField{Value: float64(u), Integer: true}, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return influxField{}, fmt.Errorf("неверное числовое значение: %q", v)
+	}
+	return influxField{Value: f}, nil
+}
+
+// influxMetrics отображает поля точки в метрики хранилища.
+// Метрика называется measurement_field, а поле value - просто measurement. Поля точки
+// с тегом metric_type=counter прибавляются к счетчикам и должны быть целыми, остальные записываются в gauge.
+// Прочие теги становятся метками ряда.
+func influxMetrics(p influxPoint) ([]storage.Metrics, error) {
+	mType := p.Tags[influxTypeTag]
+	if mType == "" {
+		mType = "gauge"
+	}
+	if mType != "gauge" && mType != "counter" {
+		return nil, fmt.Errorf("неверный тип метрики: %v", mType)
+	}
+
+	tags := make(map[string]string, len(p.Tags))
+	for k, v := range p.Tags {
+		if k != influxTypeTag {
+			tags[k] = v
+		}
+	}
+	labels := storage.SanitizeLabels(tags)
+
+	metrics := make([]storage.Metrics, 0, len(p.Fields))
+	for _, f := range p.Fields {
+		m := storage.Metrics{ID: p.Measurement, MType: mType, Labels: labels}
+		if f.Key != "value" {
+			m.ID += "_" + f.Key
+		}
+		if !storage.ValidID(m.ID) {
+			return nil, fmt.Errorf("неверное имя метрики: %q", m.ID)
+		}
+		if mType == "counter" {
+			if f.Value != math.Trunc(f.Value) {
+				return nil, fmt.Errorf("поле %s: значение счетчика должно быть целым", f.Key)
+			}
+			delta := int64(f.Value)
+			m.Delta = &delta
+		} else {
+			value := f.Value
+			m.Value = &value
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, nil
+}
+
+// cutInflux делит строку по первому неэкранированному символу sep.
+func cutInflux(s string, sep byte) (string, string, bool) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			return s[:i], s[i+1:], true
+		}
+	}
+	return s, "", false
+}
+
+// splitInflux делит строку по неэкранированным символам sep; при quoted символы внутри двойных кавычек не учитываются.
+func splitInflux(s string, sep byte, quoted bool) []string {
+	var parts []string
+	inQuotes := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case c == '"' && quoted:
+			inQuotes = !inQuotes
+		case c == sep && !inQuotes:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unescapeInflux снимает экранирование обратной косой чертой с запятых, пробелов, знаков равенства и кавычек.
+func unescapeInflux(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`, ="\`, s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,382 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SerjZimmer/devops/internal/storage"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+)
+
+// Типы содержимого запросов OTLP/HTTP.
+const (
+	otlpProtobufContentType = "application/x-protobuf"
+	otlpJSONContentType     = "application/json"
+)
+
+// Ограничения памяти для накопленных значений рядов OTLP.
+const (
+	otlpMaxCumulative = 100000    // наибольшее число запоминаемых накопительных рядов
+	otlpCumulativeTTL = time.Hour // ряд, не обновлявшийся дольше, забывается
+)
+
+// otlpCumulative представляет собой последнее накопленное значение монотонного ряда или гистограммы OTLP.
+type otlpCumulative struct {
+	start     uint64
+	value     float64
+	histogram *storage.Histogram
+	updated   time.Time
+}
+
+// otlpPending - новые накопленные значения рядов одного запроса. Они запоминаются через commit
+// только после успешной записи метрик, иначе приращения повторного экспорта были бы потеряны.
+type otlpPending map[string]otlpCumulative
+
+// otlpConverter преобразует метрики OTLP в метрики хранилища.
+//
+// Монотонные Sum становятся counter, немонотонные Sum и Gauge - gauge, Histogram - histogram с теми же
+// границами корзин, Summary - summary с теми же квантилями. Счетчики и гистограммы хранилища принимают приращения, поэтому для рядов с накопительной
+// временной агрегацией запоминается последнее значение и записывается разница.
+// Атрибуты ресурса и точки образуют метки ряда; недопустимые символы в их именах заменяются на '_'.
+// Накопительные ряды разных арендаторов учитываются раздельно. Запоминается не больше limit рядов,
+// а ряды, не обновлявшиеся дольше ttl, забываются и при следующем появлении учитываются как новые.
+type otlpConverter struct {
+	mu         sync.Mutex
+	cumulative map[string]otlpCumulative
+	started    uint64
+	limit      int
+	ttl        time.Duration
+	expired    time.Time
+}
+
+// newOTLPConverter создает новый экземпляр otlpConverter.
+func newOTLPConverter() *otlpConverter {
+	now := time.Now()
+	return &otlpConverter{
+		cumulative: make(map[string]otlpCumulative),
+		started:    uint64(now.UnixNano()),
+		limit:      otlpMaxCumulative,
+		ttl:        otlpCumulativeTTL,
+		expired:    now,
+	}
+}
+
+// convert преобразует ресурсы OTLP арендатора tenant в метрики хранилища.
+// Возвращает число отклоненных точек, описание первой причины отказа и новые накопленные значения рядов,
+// которые нужно передать в commit после успешной записи метрик.
+func (c *otlpConverter) convert(tenant string, resources []*metricspb.ResourceMetrics) ([]storage.Metrics, int64, string, otlpPending) {
+	var (
+		metrics  []storage.Metrics
+		rejected int64
+		reason   string
+		pending  = make(otlpPending)
+	)
+	reject := func(n int, err error) {
+		rejected += int64(n)
+		if reason == "" {
+			reason = err.Error()
+		}
+	}
+	addPoint := func(m storage.Metrics, ok bool, err error) {
+		if err != nil {
+			reject(1, err)
+		} else if ok {
+			metrics = append(metrics, m)
+		}
+	}
+
+	for _, rm := range resources {
+		resourceLabels := otlpLabels(nil, rm.GetResource().GetAttributes())
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, m := range sm.GetMetrics() {
+				if !storage.ValidID(m.GetName()) {
+					reject(otlpDataPoints(m), fmt.Errorf("invalid metric name %q", m.GetName()))
+					continue
+				}
+				switch data := m.GetData().(type) {
+				case *metricspb.Metric_Gauge:
+					for _, dp := range data.Gauge.GetDataPoints() {
+						addPoint(c.number(pending, tenant, m.GetName(), false, 0, resourceLabels, dp))
+					}
+				case *metricspb.Metric_Sum:
+					temporality := data.Sum.GetAggregationTemporality()
+					for _, dp := range data.Sum.GetDataPoints() {
+						addPoint(c.number(pending, tenant, m.GetName(), data.Sum.GetIsMonotonic(), temporality, resourceLabels, dp))
+					}
+				case *metricspb.Metric_Histogram:
+					temporality := data.Histogram.GetAggregationTemporality()
+					for _, dp := range data.Histogram.GetDataPoints() {
+						addPoint(c.histogram(pending, tenant, m.GetName(), temporality, resourceLabels, dp))
+					}
+				case *metricspb.Metric_Summary:
+					for _, dp := range data.Summary.GetDataPoints() {
+						addPoint(otlpSummary(m.GetName(), resourceLabels, dp))
+					}
+				default:
+					reject(otlpDataPoints(m), fmt.Errorf("unsupported metric type for %s", m.GetName()))
+				}
+			}
+		}
+	}
+	return metrics, rejected, reason, pending
+}
+
+// commit запоминает накопленные значения рядов запроса, метрики которого записаны в хранилище,
+// и не чаще раза в ttl забывает ряды, не обновлявшиеся дольше ttl.
+func (c *otlpConverter) commit(pending otlpPending) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for key, state := range pending {
+		state.updated = now
+		c.cumulative[key] = state
+	}
+	if now.Sub(c.expired) < c.ttl {
+		return
+	}
+	for key, state := range c.cumulative {
+		if now.Sub(state.updated) > c.ttl {
+			delete(c.cumulative, key)
+		}
+	}
+	c.expired = now
+}
+
+// previous возвращает последнее накопленное значение ряда key: из текущего запроса или запомненное ранее.
+// Новый ряд сверх лимита запоминаемых рядов отклоняется.
+func (c *otlpConverter) previous(pending otlpPending, key string) (otlpCumulative, bool, error) {
+	if prev, seen := pending[key]; seen {
+		return prev, true, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if prev, seen := c.cumulative[key]; seen {
+		return prev, true, nil
+	}
+	if len(c.cumulative)+len(pending) >= c.limit {
+		return otlpCumulative{}, false, fmt.Errorf("too many cumulative series")
+	}
+	return otlpCumulative{}, false, nil
+}
+
+// number преобразует числовую точку в метрику: counter для монотонной суммы, иначе gauge.
+// Точки без записанного значения пропускаются.
+func (c *otlpConverter) number(pending otlpPending, tenant, name string, monotonic bool, temporality metricspb.AggregationTemporality,
+	resourceLabels map[string]string, dp *metricspb.NumberDataPoint) (storage.Metrics, bool, error) {
+	if otlpNoValue(dp.GetFlags()) {
+		return storage.Metrics{}, false, nil
+	}
+
+	var value float64
+	switch v := dp.GetValue().(type) {
+	case *metricspb.NumberDataPoint_AsDouble:
+		value = v.AsDouble
+	case *metricspb.NumberDataPoint_AsInt:
+		value = float64(v.AsInt)
+	default:
+		return storage.Metrics{}, false, fmt.Errorf("data point without value for %s", name)
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return storage.Metrics{}, false, fmt.Errorf("invalid value for %s", name)
+	}
+
+	labels := otlpLabels(resourceLabels, dp.GetAttributes())
+	m := storage.GaugeMetric(name, value)
+	if monotonic {
+		delta, err := c.delta(pending, otlpSeriesKey(tenant, name, labels), dp.GetStartTimeUnixNano(), value, temporality)
+		if err != nil {
+			return storage.Metrics{}, false, fmt.Errorf("invalid sum %s: %w", name, err)
+		}
+		m = storage.CounterMetric(name, int64(delta))
+	}
+	m.Labels = storage.SanitizeLabels(labels)
+	return m, true, nil
+}
+
+// histogram преобразует точку гистограммы в метрику histogram. Точка без корзин становится гистограммой
+// из одной корзины со всеми значениями. Точки без записанного значения пропускаются.
+func (c *otlpConverter) histogram(pending otlpPending, tenant, name string, temporality metricspb.AggregationTemporality,
+	resourceLabels map[string]string, dp *metricspb.HistogramDataPoint) (storage.Metrics, bool, error) {
+	if otlpNoValue(dp.GetFlags()) {
+		return storage.Metrics{}, false, nil
+	}
+
+	h := &storage.Histogram{Bounds: dp.GetExplicitBounds(), Counts: dp.GetBucketCounts(), Sum: dp.GetSum()}
+	if len(h.Counts) == 0 {
+		h.Bounds, h.Counts = nil, []uint64{dp.GetCount()}
+	}
+	h = h.Clone()
+	if err := h.Validate(); err != nil {
+		return storage.Metrics{}, false, fmt.Errorf("invalid histogram %s: %w", name, err)
+	}
+
+	labels := otlpLabels(resourceLabels, dp.GetAttributes())
+	h, err := c.histogramDelta(pending, otlpSeriesKey(tenant, name, labels), dp.GetStartTimeUnixNano(), h, temporality)
+	if err != nil {
+		return storage.Metrics{}, false, fmt.Errorf("invalid histogram %s: %w", name, err)
+	}
+	if h == nil {
+		return storage.Metrics{}, false, nil
+	}
+	return storage.Metrics{ID: name, MType: "histogram", Histogram: h, Labels: storage.SanitizeLabels(labels)}, true, nil
+}
+
+// otlpSummary преобразует точку сводки в метрику summary. Сводка хранится последним присланным значением,
+// поэтому накопленные Count и Sum записываются как есть. Точки без записанного значения пропускаются.
+func otlpSummary(name string, resourceLabels map[string]string, dp *metricspb.SummaryDataPoint) (storage.Metrics, bool, error) {
+	if otlpNoValue(dp.GetFlags()) {
+		return storage.Metrics{}, false, nil
+	}
+
+	s := &storage.Summary{Count: dp.GetCount(), Sum: dp.GetSum()}
+	for _, q := range dp.GetQuantileValues() {
+		s.Quantiles = append(s.Quantiles, storage.Quantile{Quantile: q.GetQuantile(), Value: q.GetValue()})
+	}
+	sort.Slice(s.Quantiles, func(i, j int) bool { return s.Quantiles[i].Quantile < s.Quantiles[j].Quantile })
+	if err := s.Validate(); err != nil {
+		return storage.Metrics{}, false, fmt.Errorf("invalid summary %s: %w", name, err)
+	}
+
+	labels := otlpLabels(resourceLabels, dp.GetAttributes())
+	return storage.Metrics{ID: name, MType: "summary", Summary: s, Labels: storage.SanitizeLabels(labels)}, true, nil
+}
+
+// histogramDelta возвращает приращение гистограммы по тем же правилам, что и delta: при сбросе ряда
+// (новое время начала, другие границы или уменьшение какой-либо корзины) приращением считается вся гистограмма.
+// Для ряда, начавшегося до запуска сервера и впервые увиденного им, возвращает nil.
+// Новое накопленное значение сохраняется в pending.
+func (c *otlpConverter) histogramDelta(pending otlpPending, key string, start uint64, h *storage.Histogram,
+	temporality metricspb.AggregationTemporality) (*storage.Histogram, error) {
+	if temporality != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+		return h, nil
+	}
+
+	prev, seen, err := c.previous(pending, key)
+	if err != nil {
+		return nil, err
+	}
+	pending[key] = otlpCumulative{start: start, histogram: h.Clone()}
+	switch {
+	case !seen && start < c.started:
+		return nil, nil
+	case !seen, start != prev.start, prev.histogram == nil, !slices.Equal(h.Bounds, prev.histogram.Bounds):
+		return h, nil
+	}
+	for i, count := range prev.histogram.Counts {
+		if h.Counts[i] < count {
+			return pending[key].histogram.Clone(), nil
+		}
+		h.Counts[i] -= count
+	}
+	h.Sum -= prev.histogram.Sum
+	return h, nil
+}
+
+// delta возвращает целое приращение монотонного ряда.
+// Для дельта-агрегации это само значение, и дробное значение отклоняется. Для накопительной - разница
+// с предыдущим значением ряда, округленным до целого, поэтому дробные части не теряются между экспортами;
+// при сбросе ряда (новое время начала или уменьшение значения) приращением считается всё значение.
+// Ряд, впервые увиденный сервером и начавшийся до его запуска, только запоминается, чтобы после
+// перезапуска сервера накопленное значение не учитывалось повторно. Новое накопленное значение сохраняется в pending.
+func (c *otlpConverter) delta(pending otlpPending, key string, start uint64, value float64,
+	temporality metricspb.AggregationTemporality) (float64, error) {
+	if temporality != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+		if value != math.Trunc(value) {
+			return 0, fmt.Errorf("non-integral delta %v", value)
+		}
+		return value, nil
+	}
+
+	prev, seen, err := c.previous(pending, key)
+	if err != nil {
+		return 0, err
+	}
+	value = math.Round(value)
+	pending[key] = otlpCumulative{start: start, value: value}
+	switch {
+	case !seen && start < c.started:
+		return 0, nil
+	case !seen, start != prev.start, value < prev.value:
+		return value, nil
+	default:
+		return value - prev.value, nil
+	}
+}
+
+// otlpLabels объединяет метки base с атрибутами attrs; атрибуты имеют приоритет.
+func otlpLabels(base map[string]string, attrs []*commonpb.KeyValue) map[string]string {
+	labels := make(map[string]string, len(base)+len(attrs))
+	for k, v := range base {
+		labels[k] = v
+	}
+	for _, kv := range attrs {
+		if value, ok := otlpAttributeValue(kv.GetValue()); ok {
+			labels[kv.GetKey()] = value
+		}
+	}
+	return labels
+}
+
+// otlpAttributeValue возвращает строковое представление скалярного значения атрибута.
+// Массивы, вложенные списки и байты не поддерживаются.
+func otlpAttributeValue(v *commonpb.AnyValue) (string, bool) {
+	switch value := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return value.StringValue, true
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(value.BoolValue), true
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(value.IntValue, 10), true
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(value.DoubleValue, 'g', -1, 64), true
+	}
+	return "", false
+}
+
+// otlpSeriesKey возвращает ключ ряда из имени арендатора, имени метрики и отсортированных меток.
+func otlpSeriesKey(tenant, name string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(tenant + "\x00" + name)
+	for _, k := range keys {
+		b.WriteString("\x00" + k + "=" + labels[k])
+	}
+	return b.String()
+}
+
+// otlpNoValue проверяет флаг точки, означающий отсутствие записанного значения.
+func otlpNoValue(flags uint32) bool {
+	return flags&uint32(metricspb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK) != 0
+}
+
+// otlpDataPoints возвращает число точек отклоняемой метрики; метрика без точек считается одной точкой.
+func otlpDataPoints(m *metricspb.Metric) int {
+	n := 0
+	switch data := m.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		n = len(data.Gauge.GetDataPoints())
+	case *metricspb.Metric_Sum:
+		n = len(data.Sum.GetDataPoints())
+	case *metricspb.Metric_Histogram:
+		n = len(data.Histogram.GetDataPoints())
+	case *metricspb.Metric_Summary:
+		n = len(data.Summary.GetDataPoints())
+	case *metricspb.Metric_ExponentialHistogram:
+		n = len(data.ExponentialHistogram.GetDataPoints())
+	}
+	return max(n, 1)
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/SerjZimmer/devops/internal/storage"
+)
+
+// prometheusContentType - тип содержимого текстового формата экспорта Prometheus.
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// writePrometheus выводит метрики в текстовом формате экспорта Prometheus, добавляя к именам prefix.
+// Недопустимые в именах Prometheus символы заменяются на '_'. Если counter и gauge
+// получают одинаковое имя, к имени counter добавляется суффикс _total. Ряды с разными метками выводятся
+// одним семейством; если имя семейства уже занято другой метрикой, её ряды пропускаются.
+// Гистограмма выводится рядами _bucket с накопленными числами значений, _sum и _count,
+// сводка - рядами квантилей с меткой quantile, _sum и _count.
+// Метрики должны быть упорядочены по имени и типу, как их возвращает ListMetrics.
+func writePrometheus(w io.Writer, metrics []storage.Metrics, prefix string) error {
+	gauges := make(map[string]bool)
+	for _, m := range metrics {
+		if m.MType == "gauge" {
+			gauges[prometheusName(prefix+m.ID)] = true
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	families := make(map[string]string, len(metrics))
+	written := make(map[string]bool, len(metrics))
+	for _, m := range metrics {
+		name := prometheusName(prefix + m.ID)
+		var value string
+		switch m.MType {
+		case "histogram":
+			if m.Histogram == nil {
+				continue
+			}
+		case "summary":
+			if m.Summary == nil {
+				continue
+			}
+		case "counter":
+			if m.Delta == nil {
+				continue
+			}
+			if gauges[name] {
+				name += "_total"
+			}
+			value = strconv.FormatInt(*m.Delta, 10)
+		case "gauge":
+			if m.Value == nil {
+				continue
+			}
+			value = prometheusFloat(*m.Value)
+		default:
+			continue
+		}
+
+		owner := m.MType + "\x00" + m.ID
+		if family, exists := families[name]; !exists {
+			families[name] = owner
+			bw.WriteString("# TYPE " + name + " " + m.MType + "\n")
+		} else if family != owner {
+			continue
+		}
+		series := name + prometheusLabels(m.Labels)
+		if written[series] {
+			continue
+		}
+		written[series] = true
+		switch m.MType {
+		case "histogram":
+			writePrometheusHistogram(bw, name, m.Labels, m.Histogram)
+			continue
+		case "summary":
+			writePrometheusSummary(bw, name, m.Labels, m.Summary)
+			continue
+		}
+		bw.WriteString(series + " " + value + "\n")
+	}
+	return bw.Flush()
+}
+
+// writePrometheusHistogram выводит ряды гистограммы h с метками labels: корзины с накопленными
+// числами значений и меткой le, затем сумму и общее число значений.
+func writePrometheusHistogram(bw *bufio.Writer, name string, labels map[string]string, h *storage.Histogram) {
+	bucketLabels := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		bucketLabels[k] = v
+	}
+	var cumulative uint64
+	for i, c := range h.Counts {
+		cumulative += c
+		le := "+Inf"
+		if i < len(h.Bounds) {
+			le = prometheusFloat(h.Bounds[i])
+		}
+		bucketLabels["le"] = le
+		bw.WriteString(name + "_bucket" + prometheusLabels(bucketLabels) + " " + strconv.FormatUint(cumulative, 10) + "\n")
+	}
+	bw.WriteString(name + "_sum" + prometheusLabels(labels) + " " + prometheusFloat(h.Sum) + "\n")
+	bw.WriteString(name + "_count" + prometheusLabels(labels) + " " + strconv.FormatUint(cumulative, 10) + "\n")
+}
+
+// writePrometheusSummary выводит ряды сводки s с метками labels: значения квантилей с меткой quantile,
+// затем сумму и общее число значений.
+func writePrometheusSummary(bw *bufio.Writer, name string, labels map[string]string, s *storage.Summary) {
+	quantileLabels := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		quantileLabels[k] = v
+	}
+	for _, q := range s.Quantiles {
+		quantileLabels["quantile"] = prometheusFloat(q.Quantile)
+		bw.WriteString(name + prometheusLabels(quantileLabels) + " " + prometheusFloat(q.Value) + "\n")
+	}
+	bw.WriteString(name + "_sum" + prometheusLabels(labels) + " " + prometheusFloat(s.Sum) + "\n")
+	bw.WriteString(name + "_count" + prometheusLabels(labels) + " " + strconv.FormatUint(s.Count, 10) + "\n")
+}
+
+// prometheusLabels выводит набор меток {a="1",b="2"}, экранируя в значениях обратную косую черту,
+// кавычки и переводы строк. Пустой набор выводится пустой строкой.
+func prometheusLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name + `="` + prometheusEscaper.Replace(labels[name]) + `"`)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// prometheusEscaper экранирует значения меток по правилам текстового формата Prometheus.
+var prometheusEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// prometheusName приводит имя метрики к виду [a-zA-Z_:][a-zA-Z0-9_:]*.
+func prometheusName(name string) string {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	b := []byte(name)
+	for i, c := range b {
+		valid := c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+		if !valid {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// prometheusFloat форматирует значение gauge, включая специальные значения NaN и ±Inf.
+func prometheusFloat(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
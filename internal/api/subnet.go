@@ -0,0 +1,29 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/SerjZimmer/devops/internal/subnet"
+)
+
+// realIPHeader - заголовок, в котором доверенный прокси передает адрес клиента.
+const realIPHeader = "X-Real-IP"
+
+// TrustedSubnetMiddleware возвращает middleware, пропускающее только запросы из доверенной подсети trusted.
+// Адрес клиента - адрес соединения; заголовок X-Real-IP учитывается только для соединений от доверенных прокси.
+// Запросы с адресом вне подсети или некорректным адресом отклоняются с кодом 403.
+// Если подсеть не задана, запросы передаются дальше без проверки.
+func TrustedSubnetMiddleware(trusted *subnet.Trusted) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if trusted == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !trusted.Allows(r.RemoteAddr, r.Header.Get(realIPHeader)) {
+				http.Error(w, "Адрес не входит в доверенную подсеть", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -1,14 +1,20 @@
 package api
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"bytes"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/SerjZimmer/devops/internal/hash"
+	"github.com/SerjZimmer/devops/internal/selfmetrics"
 	"github.com/SerjZimmer/devops/internal/storage"
+	"github.com/SerjZimmer/devops/internal/tenant"
 	"go.uber.org/zap"
 )
 
@@ -31,13 +37,22 @@ const metricsListTemplate = `
 
 var tmpl = template.Must(template.New("metricsList").Parse(metricsListTemplate))
 
+// validType проверяет, что mType - один из поддерживаемых типов метрик.
+func validType(mType string) bool {
+	switch mType {
+	case "gauge", "counter", "histogram", "summary":
+		return true
+	}
+	return false
+}
+
 // isValidMetrics проверяет корректность переданных данных метрик.
 func isValidMetrics(m storage.Metrics) bool {
-	if m.ID == "" {
+	if !storage.ValidID(m.ID) {
 		return false
 	}
 
-	if m.MType != "gauge" && m.MType != "counter" {
+	if !validType(m.MType) {
 		return false
 	}
 
@@ -45,10 +60,107 @@ func isValidMetrics(m storage.Metrics) bool {
 		return false
 	}
 
+	if m.MType == "histogram" && (m.Histogram == nil || m.Histogram.Validate() != nil) {
+		return false
+	}
+
+	if m.MType == "summary" && (m.Summary == nil || m.Summary.Validate() != nil) {
+		return false
+	}
+
 	if m.MType == "counter" && m.Delta == nil && m.ID != "PollCount" {
 		return false
 	}
 
+	return storage.ValidLabels(m.Labels)
+}
+
+// parseMatchers разбирает условия на метки из параметров запроса match вида name=value или name!=value.
+func parseMatchers(values url.Values) ([]storage.Matcher, error) {
+	var matchers []storage.Matcher
+	for _, v := range values["match"] {
+		m, err := storage.ParseMatcher(v)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+// errAmbiguousMetric возвращается, если условиям запроса соответствует несколько рядов метрики.
+var errAmbiguousMetric = errors.New("ambiguous metric")
+
+// findMetric ищет ряд метрики m. Сначала ищется ряд с точно такими метками, как у m; если его нет,
+// среди рядов с тем же именем и типом выбирается единственный, содержащий метки m и удовлетворяющий
+// условиям matchers. Поэтому запрос без меток находит метрику, которую присылает единственный агент с метками,
+// а ряд без меток, если он есть, находится им всегда.
+func findMetric(stor metricsStorage, m storage.Metrics, matchers []storage.Matcher) (storage.Metrics, error) {
+	if storage.MatchLabels(m.Labels, matchers) {
+		if stored, err := stor.GetMetric(m); err == nil {
+			return stored, nil
+		}
+	}
+
+	metrics, err := stor.ListMetrics()
+	if err != nil {
+		return storage.Metrics{}, err
+	}
+	var found []storage.Metrics
+	for _, stored := range metrics {
+		if stored.ID == m.ID && (m.MType == "" || stored.MType == m.MType) &&
+			containsLabels(stored.Labels, m.Labels) && storage.MatchLabels(stored.Labels, matchers) {
+			found = append(found, stored)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return storage.Metrics{}, fmt.Errorf("undefind metricName: %v", m.ID)
+	case 1:
+		return found[0], nil
+	default:
+		return storage.Metrics{}, errAmbiguousMetric
+	}
+}
+
+// filterMetrics возвращает метрики, метки которых удовлетворяют условиям matchers.
+func filterMetrics(metrics []storage.Metrics, matchers []storage.Matcher) []storage.Metrics {
+	if len(matchers) == 0 {
+		return metrics
+	}
+	filtered := make([]storage.Metrics, 0, len(metrics))
+	for _, m := range metrics {
+		if storage.MatchLabels(m.Labels, matchers) {
+			filtered = append(filtered, m)
+		}
+	}
+	return filtered
+}
+
+// formatMetrics выводит метрики в виде строк имя_ряда/значение, как GetAllMetrics хранилища.
+func formatMetrics(metrics []storage.Metrics) string {
+	var b strings.Builder
+	for _, m := range metrics {
+		if m.MType == "counter" && m.Delta != nil {
+			fmt.Fprintf(&b, "%v/%v\n", storage.SeriesName(m.ID, m.Labels), *m.Delta)
+		} else if m.MType == "histogram" && m.Histogram != nil {
+			fmt.Fprintf(&b, "%v/%v\n", storage.SeriesName(m.ID, m.Labels), m.Histogram)
+		} else if m.MType == "summary" && m.Summary != nil {
+			fmt.Fprintf(&b, "%v/%v\n", storage.SeriesName(m.ID, m.Labels), m.Summary)
+		} else if m.Value != nil {
+			fmt.Fprintf(&b, "%v/%v\n", storage.SeriesName(m.ID, m.Labels), *m.Value)
+		}
+	}
+	return b.String()
+}
+
+// containsLabels проверяет, что набор меток labels содержит все метки subset.
+func containsLabels(labels, subset map[string]string) bool {
+	for name, value := range subset {
+		if labels[name] != value {
+			return false
+		}
+	}
 	return true
 }
 
@@ -61,18 +173,101 @@ func parseNumeric(mValue string) (float64, error) {
 	return floatVal, nil
 }
 
-// calculateHash вычисляет хеш SHA256 для переданных данных.
-func calculateHash(data string) string {
-	hasher := sha256.New()
-	hasher.Write([]byte(data))
-	hash := hex.EncodeToString(hasher.Sum(nil))
-	return hash
+// maxRangePoints ограничивает число точек в ответе на запрос истории метрики.
+const maxRangePoints = 11000
+
+// parseRangeQuery разбирает параметры запроса истории метрики.
+// Метки ряда задаются параметрами match вида name=value.
+// По умолчанию возвращается последний час до момента now без выравнивания по шагу.
+func parseRangeQuery(values url.Values, now time.Time) (storage.RangeQuery, error) {
+	q := storage.RangeQuery{
+		ID:          values.Get("name"),
+		MType:       values.Get("type"),
+		To:          now,
+		Aggregation: values.Get("agg"),
+	}
+	if q.ID == "" {
+		return q, fmt.Errorf("не указано имя метрики")
+	}
+	if q.MType != "" && !validType(q.MType) {
+		return q, fmt.Errorf("неверный тип метрики")
+	}
+	switch q.Aggregation {
+	case "", "min", "max", "avg", "last", "sum", "rate":
+	default:
+		return q, fmt.Errorf("неверная агрегация: %v", q.Aggregation)
+	}
+
+	matchers, err := parseMatchers(values)
+	if err != nil {
+		return q, err
+	}
+	for _, m := range matchers {
+		if m.Negate {
+			return q, fmt.Errorf("метки ряда задаются только условиями равенства")
+		}
+		if m.Value == "" {
+			continue
+		}
+		if q.Labels == nil {
+			q.Labels = make(map[string]string)
+		}
+		q.Labels[m.Name] = m.Value
+	}
+
+	if v := values.Get("to"); v != "" {
+		if q.To, err = parseTimeParam(v); err != nil {
+			return q, fmt.Errorf("неверное значение to: %w", err)
+		}
+	}
+	q.From = q.To.Add(-time.Hour)
+	if v := values.Get("from"); v != "" {
+		if q.From, err = parseTimeParam(v); err != nil {
+			return q, fmt.Errorf("неверное значение from: %w", err)
+		}
+	}
+	if q.From.After(q.To) {
+		return q, fmt.Errorf("from должен быть не позже to")
+	}
+
+	if v := values.Get("step"); v != "" {
+		if q.Step, err = parseDurationParam(v); err != nil {
+			return q, fmt.Errorf("неверное значение step: %w", err)
+		}
+		if q.Step <= 0 {
+			return q, fmt.Errorf("step должен быть положительным")
+		}
+		if q.To.Sub(q.From)/q.Step >= maxRangePoints {
+			return q, fmt.Errorf("слишком много точек, увеличьте step")
+		}
+	}
+	return q, nil
+}
+
+// parseTimeParam разбирает момент времени в виде unix-времени в секундах или строки RFC 3339.
+func parseTimeParam(v string) (time.Time, error) {
+	if seconds, err := strconv.ParseFloat(v, 64); err == nil {
+		return time.Unix(0, int64(seconds*float64(time.Second))), nil
+	}
+	return time.Parse(time.RFC3339Nano, v)
+}
+
+// parseDurationParam разбирает длительность в формате Go (например, 15s) или число секунд.
+func parseDurationParam(v string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(v, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	return time.ParseDuration(v)
 }
 
 // Handler представляет обработчик HTTP-запросов для взаимодействия с метриками.
 type Handler struct {
-	stor   metricsStorage
-	logger *zap.Logger
+	stor    metricsStorage
+	logger  *zap.Logger
+	self    *selfmetrics.Registry
+	otlp    *otlpConverter
+	key     string
+	tenants tenant.Keys
 }
 
 // responseWriterWithStatus представляет ResponseWriter с поддержкой хранения HTTP-статуса.
@@ -81,8 +276,46 @@ type responseWriterWithStatus struct {
 	status int
 }
 
+// WriteHeader запоминает HTTP-статус ответа и передает его дальше.
+func (rw *responseWriterWithStatus) WriteHeader(status int) {
+	rw.status = status
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+// signingResponseWriter представляет ResponseWriter, который накапливает ответ,
+// чтобы выставить заголовок с его подписью до отправки тела.
+type signingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// WriteHeader запоминает HTTP-статус ответа до отправки подписи.
+func (sw *signingResponseWriter) WriteHeader(status int) {
+	sw.status = status
+}
+
+// Write накапливает тело ответа.
+func (sw *signingResponseWriter) Write(p []byte) (int, error) {
+	return sw.body.Write(p)
+}
+
+// flush подписывает накопленное тело ключом key и отправляет ответ.
+func (sw *signingResponseWriter) flush(key string) {
+	sw.ResponseWriter.Header().Set(hash.Header, hash.Sign(key, sw.body.Bytes()))
+	sw.ResponseWriter.WriteHeader(sw.status)
+	sw.ResponseWriter.Write(sw.body.Bytes())
+}
+
 // NewHandler создает новый экземпляр обработчика HTTP-запросов.
-func NewHandler(stor metricsStorage) *Handler {
+// Ключ key используется для проверки подписи запросов и подписи ответов арендатора по умолчанию;
+// пустой ключ отключает подпись. Ключи арендаторов tenants задают, кто может обращаться к своим разделам хранилища;
+// если арендаторы заданы, а хранилище не разделяется по арендаторам, функция паникует.
+func NewHandler(stor metricsStorage, key string, tenants tenant.Keys) *Handler {
+	if _, ok := stor.(tenantStorage); len(tenants) > 0 && !ok {
+		panic("storage does not support tenants")
+	}
+
 	config := zap.NewProductionConfig()
 	config.Level = zap.NewAtomicLevelAt(zap.InfoLevel)
 
@@ -91,7 +324,20 @@ func NewHandler(stor metricsStorage) *Handler {
 		panic(fmt.Sprintf("failed to create logger: %v", err))
 	}
 	return &Handler{
-		stor:   stor,
-		logger: logger,
+		stor:    stor,
+		logger:  logger,
+		self:    selfmetrics.Default,
+		otlp:    newOTLPConverter(),
+		key:     key,
+		tenants: tenants,
+	}
+}
+
+// storage возвращает хранилище арендатора, аутентифицированного для запроса r.
+func (s *Handler) storage(r *http.Request) metricsStorage {
+	name := tenant.FromContext(r.Context())
+	if name == "" {
+		return s.stor
 	}
+	return s.stor.(tenantStorage).Tenant(name)
 }
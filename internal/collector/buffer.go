@@ -0,0 +1,85 @@
+// Package collector собирает метрики на стороне агента и накапливает их до отправки на сервер.
+//
+// Метрики собираются коллекторами (см. Collector), каждый из которых опрашивается со своим интервалом
+// и включается в конфигурации агента по имени из реестра (см. Registry). Пакет не зависит
+// от хранилища сервера: собранные значения хранятся только в памяти агента.
+package collector
+
+import "sync"
+
+// Buffer накапливает собранные агентом метрики между отправками: для gauge хранится последнее значение,
+// для counter - сумма приращений с момента последнего снимка. Методы Buffer безопасны для одновременного вызова.
+type Buffer struct {
+	mu       sync.Mutex
+	gauges   map[string]float64
+	counters map[string]int64
+}
+
+// Snapshot - снимок буфера для отправки на сервер.
+type Snapshot struct {
+	Gauges   map[string]float64
+	Counters map[string]int64
+}
+
+// NewBuffer создает пустой буфер метрик.
+func NewBuffer() *Buffer {
+	return &Buffer{
+		gauges:   make(map[string]float64),
+		counters: make(map[string]int64),
+	}
+}
+
+// SetGauge записывает значение gauge.
+func (b *Buffer) SetGauge(name string, value float64) {
+	b.mu.Lock()
+	b.gauges[name] = value
+	b.mu.Unlock()
+}
+
+// AddCounter прибавляет delta к приращению counter.
+func (b *Buffer) AddCounter(name string, delta int64) {
+	b.mu.Lock()
+	b.counters[name] += delta
+	b.mu.Unlock()
+}
+
+// Record записывает в буфер метрики, собранные коллектором. Метрики неизвестных типов пропускаются.
+func (b *Buffer) Record(metrics []Metric) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, m := range metrics {
+		switch m.Type {
+		case "gauge":
+			b.gauges[m.Name] = m.Value
+		case "counter":
+			b.counters[m.Name] += m.Delta
+		}
+	}
+}
+
+// Restore возвращает в буфер приращения counter из снимка, которые не удалось отправить,
+// чтобы они ушли со следующим снимком вместе с новыми.
+func (b *Buffer) Restore(counters map[string]int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for name, delta := range counters {
+		b.counters[name] += delta
+	}
+}
+
+// Snapshot возвращает копию накопленных метрик и обнуляет приращения counter,
+// чтобы каждое приращение было отправлено на сервер один раз. Значения gauge сохраняются.
+func (b *Buffer) Snapshot() Snapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	snap := Snapshot{
+		Gauges:   make(map[string]float64, len(b.gauges)),
+		Counters: b.counters,
+	}
+	for name, value := range b.gauges {
+		snap.Gauges[name] = value
+	}
+	b.counters = make(map[string]int64, len(snap.Counters))
+	return snap
+}
@@ -0,0 +1,90 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"time"
+
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/mem"
+)
+
+// Runtime возвращает коллектор статистики памяти среды выполнения Go. Кроме неё коллектор
+// отдает случайное значение RandomValue и приращение счетчика опросов PollCount.
+func Runtime(interval time.Duration) Collector {
+	return New("runtime", interval, func(ctx context.Context) ([]Metric, error) {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		return memStatsMetrics(m), nil
+	})
+}
+
+// memStatsMetrics преобразует статистику памяти среды выполнения в метрики.
+func memStatsMetrics(m runtime.MemStats) []Metric {
+	return []Metric{
+		Gauge("Alloc", float64(m.Alloc)),
+		Gauge("BuckHashSys", float64(m.BuckHashSys)),
+		Gauge("Frees", float64(m.Frees)),
+		Gauge("GCCPUFraction", m.GCCPUFraction),
+		Gauge("GCSys", float64(m.GCSys)),
+		Gauge("HeapAlloc", float64(m.HeapAlloc)),
+		Gauge("HeapIdle", float64(m.HeapIdle)),
+		Gauge("HeapInuse", float64(m.HeapInuse)),
+		Gauge("HeapObjects", float64(m.HeapObjects)),
+		Gauge("HeapReleased", float64(m.HeapReleased)),
+		Gauge("HeapSys", float64(m.HeapSys)),
+		Gauge("LastGC", float64(m.LastGC)),
+		Gauge("Lookups", float64(m.Lookups)),
+		Gauge("MCacheInuse", float64(m.MCacheInuse)),
+		Gauge("MCacheSys", float64(m.MCacheSys)),
+		Gauge("MSpanInuse", float64(m.MSpanInuse)),
+		Gauge("MSpanSys", float64(m.MSpanSys)),
+		Gauge("Mallocs", float64(m.Mallocs)),
+		Gauge("NextGC", float64(m.NextGC)),
+		Gauge("NumForcedGC", float64(m.NumForcedGC)),
+		Gauge("NumGC", float64(m.NumGC)),
+		Gauge("OtherSys", float64(m.OtherSys)),
+		Gauge("PauseTotalNs", float64(m.PauseTotalNs)),
+		Gauge("StackInuse", float64(m.StackInuse)),
+		Gauge("StackSys", float64(m.StackSys)),
+		Gauge("Sys", float64(m.Sys)),
+		Gauge("TotalAlloc", float64(m.TotalAlloc)),
+		Gauge("RandomValue", rand.Float64()),
+		Counter("PollCount", 1),
+	}
+}
+
+// Memory возвращает коллектор объема и свободной памяти системы.
+func Memory(interval time.Duration) Collector {
+	return New("memory", interval, func(ctx context.Context) ([]Metric, error) {
+		memInfo, err := mem.VirtualMemoryWithContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return []Metric{
+			Gauge("TotalMemory", float64(memInfo.Total)),
+			Gauge("FreeMemory", float64(memInfo.Free)),
+		}, nil
+	})
+}
+
+// CPU возвращает коллектор показателей процессоров системы CPUUtilization<номер>.
+func CPU(interval time.Duration) Collector {
+	return New("cpu", interval, func(ctx context.Context) ([]Metric, error) {
+		cpuInfo, err := cpu.InfoWithContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(cpuInfo) == 0 {
+			return nil, errors.New("нет данных о процессорах")
+		}
+		metrics := make([]Metric, 0, len(cpuInfo))
+		for i, cpuStat := range cpuInfo {
+			metrics = append(metrics, Gauge(fmt.Sprintf("CPUUtilization%d", i), float64(cpuStat.CPU)))
+		}
+		return metrics, nil
+	})
+}
@@ -0,0 +1,188 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metric - метрика, собранная коллектором: gauge со значением Value или counter с приращением Delta.
+type Metric struct {
+	Name  string
+	Type  string
+	Value float64
+	Delta int64
+}
+
+// Gauge возвращает метрику gauge.
+func Gauge(name string, value float64) Metric {
+	return Metric{Name: name, Type: "gauge", Value: value}
+}
+
+// Counter возвращает приращение counter.
+func Counter(name string, delta int64) Metric {
+	return Metric{Name: name, Type: "counter", Delta: delta}
+}
+
+// Collector - источник метрик агента, опрашиваемый с собственным интервалом.
+type Collector interface {
+	// Name возвращает имя коллектора, под которым он включается в конфигурации.
+	Name() string
+	// Interval возвращает интервал между опросами коллектора.
+	Interval() time.Duration
+	// Collect собирает метрики. При ошибке возвращаются метрики, которые удалось собрать.
+	Collect(ctx context.Context) ([]Metric, error)
+}
+
+// funcCollector - коллектор, собирающий метрики функцией.
+type funcCollector struct {
+	name     string
+	interval time.Duration
+	collect  func(ctx context.Context) ([]Metric, error)
+}
+
+// New возвращает коллектор name, собирающий метрики функцией collect с интервалом interval.
+func New(name string, interval time.Duration, collect func(ctx context.Context) ([]Metric, error)) Collector {
+	return &funcCollector{name: name, interval: interval, collect: collect}
+}
+
+func (c *funcCollector) Name() string                                  { return c.name }
+func (c *funcCollector) Interval() time.Duration                       { return c.interval }
+func (c *funcCollector) Collect(ctx context.Context) ([]Metric, error) { return c.collect(ctx) }
+
+// Factory создает коллектор с интервалом опроса interval.
+type Factory func(interval time.Duration) Collector
+
+// Registry сопоставляет именам коллекторов функции их создания.
+type Registry struct {
+	mu        sync.RWMutex
+	factories map[string]Factory
+}
+
+// NewRegistry создает пустой реестр коллекторов.
+func NewRegistry() *Registry {
+	return &Registry{factories: make(map[string]Factory)}
+}
+
+// DefaultRegistry создает реестр со встроенными коллекторами runtime, memory и cpu.
+func DefaultRegistry() *Registry {
+	r := NewRegistry()
+	r.Register("runtime", Runtime)
+	r.Register("memory", Memory)
+	r.Register("cpu", CPU)
+	return r
+}
+
+// Register добавляет в реестр коллектор name, заменяя зарегистрированный ранее под тем же именем.
+func (r *Registry) Register(name string, f Factory) {
+	r.mu.Lock()
+	r.factories[name] = f
+	r.mu.Unlock()
+}
+
+// Names возвращает имена зарегистрированных коллекторов в алфавитном порядке.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.factories))
+	for name := range r.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Parse создает коллекторы по списку вида "runtime,memory:5s,cpu:10s": имена включаемых коллекторов
+// через запятую, за каждым может следовать интервал опроса. Без интервала используется defaultInterval.
+func (r *Registry) Parse(spec string, defaultInterval time.Duration) ([]Collector, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var collectors []Collector
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, intervalStr, hasInterval := strings.Cut(item, ":")
+		f, ok := r.factories[name]
+		if !ok {
+			return nil, fmt.Errorf("неизвестный коллектор: %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("коллектор %q указан несколько раз", name)
+		}
+		seen[name] = true
+
+		interval := defaultInterval
+		if hasInterval {
+			var err error
+			if interval, err = time.ParseDuration(intervalStr); err != nil {
+				return nil, fmt.Errorf("неверный интервал коллектора %q: %w", name, err)
+			}
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("интервал коллектора %q должен быть положительным", name)
+		}
+		collectors = append(collectors, f(interval))
+	}
+	return collectors, nil
+}
+
+// Start запускает опрос каждого коллектора в отдельной горутине: сразу и затем с его интервалом.
+// Собранные метрики записываются в буфер b вместе с длительностью опроса collector_<имя>_duration_seconds
+// и числом ошибок collector_<имя>_errors_total. Паника коллектора считается ошибкой опроса.
+// Число ошибок - counter буфера: каждый отчет агента содержит ошибки, случившиеся после предыдущего
+// отчета, а общее число накапливает сервер. Опрос прекращается при отмене ctx;
+// возвращаемая группа позволяет дождаться завершения горутин.
+func Start(ctx context.Context, b *Buffer, collectors []Collector) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	wg.Add(len(collectors))
+	for _, c := range collectors {
+		go func(c Collector) {
+			defer wg.Done()
+			ticker := time.NewTicker(c.Interval())
+			defer ticker.Stop()
+			for {
+				collect(ctx, b, c)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(c)
+	}
+	return &wg
+}
+
+// collect выполняет один опрос коллектора c. Опрос ограничен интервалом коллектора,
+// чтобы зависший источник не задерживал следующие опросы.
+func collect(ctx context.Context, b *Buffer, c Collector) {
+	ctx, cancel := context.WithTimeout(ctx, c.Interval())
+	defer cancel()
+
+	start := time.Now()
+	metrics, err := safeCollect(ctx, c)
+	b.Record(metrics)
+	b.SetGauge("collector_"+c.Name()+"_duration_seconds", time.Since(start).Seconds())
+	if err != nil {
+		b.AddCounter("collector_"+c.Name()+"_errors_total", 1)
+		fmt.Printf("Ошибка коллектора %v: %v\n", c.Name(), err)
+	}
+}
+
+// safeCollect вызывает c.Collect и возвращает панику коллектора как ошибку,
+// чтобы сбой одного источника не завершал агент.
+func safeCollect(ctx context.Context, c Collector) (metrics []Metric, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			metrics, err = nil, fmt.Errorf("паника при опросе: %v", r)
+		}
+	}()
+	return c.Collect(ctx)
+}
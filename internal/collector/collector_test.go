@@ -0,0 +1,154 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuffer_Snapshot(t *testing.T) {
+	b := NewBuffer()
+	b.SetGauge("HeapAlloc", 1)
+	b.AddCounter("PollCount", 2)
+	b.AddCounter("PollCount", 3)
+
+	snap := b.Snapshot()
+	assert.Equal(t, map[string]float64{"HeapAlloc": 1}, snap.Gauges)
+	assert.Equal(t, map[string]int64{"PollCount": 5}, snap.Counters)
+
+	// Приращения counter отдаются один раз, gauge сохраняют последнее значение
+	b.SetGauge("HeapAlloc", 2)
+	snap2 := b.Snapshot()
+	assert.Equal(t, map[string]float64{"HeapAlloc": 2}, snap2.Gauges)
+	assert.Empty(t, snap2.Counters)
+
+	// Снимок не меняется при дальнейшей записи в буфер
+	b.AddCounter("PollCount", 1)
+	assert.Equal(t, float64(1), snap.Gauges["HeapAlloc"])
+	assert.Equal(t, int64(5), snap.Counters["PollCount"])
+}
+
+func TestBuffer_Restore(t *testing.T) {
+	b := NewBuffer()
+	b.AddCounter("PollCount", 2)
+	snap := b.Snapshot()
+
+	// Неотправленные приращения уходят со следующим снимком вместе с новыми
+	b.AddCounter("PollCount", 3)
+	b.Restore(snap.Counters)
+	assert.Equal(t, map[string]int64{"PollCount": 5}, b.Snapshot().Counters)
+}
+
+func TestBuffer_Record(t *testing.T) {
+	b := NewBuffer()
+	b.Record([]Metric{Gauge("HeapAlloc", 1), Counter("PollCount", 1), Counter("PollCount", 2), {Name: "x", Type: "summary"}})
+
+	snap := b.Snapshot()
+	assert.Equal(t, map[string]float64{"HeapAlloc": 1}, snap.Gauges)
+	assert.Equal(t, map[string]int64{"PollCount": 3}, snap.Counters)
+}
+
+func TestRuntime(t *testing.T) {
+	metrics := memStatsMetrics(runtime.MemStats{Alloc: 100, BuckHashSys: 200})
+	b := NewBuffer()
+	b.Record(metrics)
+	b.Record(metrics)
+
+	snap := b.Snapshot()
+	assert.Equal(t, float64(100), snap.Gauges["Alloc"])
+	assert.Equal(t, float64(200), snap.Gauges["BuckHashSys"])
+	assert.Contains(t, snap.Gauges, "RandomValue")
+	// PollCount считает опросы с момента последнего снимка
+	assert.Equal(t, int64(2), snap.Counters["PollCount"])
+
+	c := Runtime(time.Second)
+	assert.Equal(t, "runtime", c.Name())
+	assert.Equal(t, time.Second, c.Interval())
+	metrics, err := c.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Contains(t, metrics, Counter("PollCount", 1))
+}
+
+func TestSystemCollectors(t *testing.T) {
+	metrics, err := Memory(time.Second).Collect(context.Background())
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	assert.Equal(t, "TotalMemory", metrics[0].Name)
+	assert.Greater(t, metrics[0].Value, float64(0))
+
+	metrics, err = CPU(time.Second).Collect(context.Background())
+	require.NoError(t, err)
+	require.NotEmpty(t, metrics)
+	assert.Equal(t, "CPUUtilization0", metrics[0].Name)
+}
+
+func TestRegistry_Parse(t *testing.T) {
+	r := DefaultRegistry()
+	assert.Equal(t, []string{"cpu", "memory", "runtime"}, r.Names())
+
+	collectors, err := r.Parse("runtime, cpu:10s", 2*time.Second)
+	require.NoError(t, err)
+	require.Len(t, collectors, 2)
+	assert.Equal(t, "runtime", collectors[0].Name())
+	assert.Equal(t, 2*time.Second, collectors[0].Interval())
+	assert.Equal(t, "cpu", collectors[1].Name())
+	assert.Equal(t, 10*time.Second, collectors[1].Interval())
+
+	// Новые коллекторы подключаются регистрацией в реестре
+	r.Register("custom", func(interval time.Duration) Collector {
+		return New("custom", interval, func(context.Context) ([]Metric, error) { return nil, nil })
+	})
+	collectors, err = r.Parse("custom", time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "custom", collectors[0].Name())
+
+	collectors, err = r.Parse("", time.Second)
+	require.NoError(t, err)
+	assert.Empty(t, collectors)
+
+	for _, spec := range []string{"disk", "cpu,cpu", "cpu:soon", "cpu:0s", "cpu:-1s"} {
+		_, err := r.Parse(spec, time.Second)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestStart(t *testing.T) {
+	b := NewBuffer()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var calls atomic.Int64
+	failing := New("failing", 5*time.Millisecond, func(context.Context) ([]Metric, error) {
+		calls.Add(1)
+		return []Metric{Gauge("Partial", 1)}, errors.New("source is unavailable")
+	})
+	slow := New("slow", time.Hour, func(ctx context.Context) ([]Metric, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	panicking := New("panicking", time.Hour, func(context.Context) ([]Metric, error) {
+		panic("broken source")
+	})
+	wg := Start(ctx, b, []Collector{failing, slow, panicking})
+
+	// Каждый коллектор опрашивается со своим интервалом, независимо от зависшего
+	assert.Eventually(t, func() bool { return calls.Load() >= 3 }, time.Second, time.Millisecond)
+	cancel()
+	wg.Wait()
+
+	snap := b.Snapshot()
+	assert.Equal(t, float64(1), snap.Gauges["Partial"])
+	assert.Contains(t, snap.Gauges, "collector_failing_duration_seconds")
+	assert.Equal(t, calls.Load(), snap.Counters["collector_failing_errors_total"])
+	assert.Equal(t, int64(1), snap.Counters["collector_slow_errors_total"])
+	// Паника коллектора не завершает агент и считается ошибкой
+	assert.Equal(t, int64(1), snap.Counters["collector_panicking_errors_total"])
+
+	// Ошибки передаются приращениями: следующий снимок содержит только новые
+	assert.Zero(t, b.Snapshot().Counters["collector_slow_errors_total"])
+}
@@ -4,8 +4,7 @@ import (
 	"flag"
 	"os"
 	"strconv"
-
-	"github.com/SerjZimmer/devops/internal/storage"
+	"time"
 )
 
 // Config представляет структуру конфигурации для приложения.
@@ -13,22 +12,48 @@ type Config struct {
 	Address        string
 	PollInterval   int
 	ReportInterval int
-	Storage        *storage.Config
 	Key            string
 	RateLimit      int
+	GRPCAddress    string
+	CryptoKey      string
+	TLSCA          string
+	TLSCert        string
+	TLSKey         string
+	Tenant         string
+	AgentID        string
+	RetryAttempts  int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+	RetryJitter    float64
+	SpoolDir       string
+	SpoolMaxSize   int
+	SpoolMaxAge    time.Duration
+	Collectors     string
 }
 
 // New создает новый экземпляр конфигурации с значениями по умолчанию или из переменных окружения и флагов командной строки.
 func New() *Config {
-	StorageConfig := storage.NewConfig(false)
-
 	config := &Config{
-		Storage:        StorageConfig,
 		Address:        getEnv("ADDRESS", "localhost:8080"),
 		PollInterval:   getEnvAsInt("POLL_INTERVAL", 2),
 		ReportInterval: getEnvAsInt("REPORT_INTERVAL", 10),
 		Key:            getEnv("KEY", ""),
 		RateLimit:      getEnvAsInt("RATE_LIMIT", 1),
+		GRPCAddress:    getEnv("GRPC_ADDRESS", ""),
+		CryptoKey:      getEnv("CRYPTO_KEY", ""),
+		TLSCA:          getEnv("TLS_CA", ""),
+		TLSCert:        getEnv("TLS_CERT", ""),
+		TLSKey:         getEnv("TLS_KEY", ""),
+		Tenant:         getEnv("TENANT", ""),
+		AgentID:        getEnv("AGENT_ID", ""),
+		RetryAttempts:  getEnvAsInt("RETRY_ATTEMPTS", 3),
+		RetryBaseDelay: getEnvAsDuration("RETRY_BASE_DELAY", time.Second),
+		RetryMaxDelay:  getEnvAsDuration("RETRY_MAX_DELAY", 10*time.Second),
+		RetryJitter:    getEnvAsFloat("RETRY_JITTER", 0.2),
+		SpoolDir:       getEnv("SPOOL_DIR", ""),
+		SpoolMaxSize:   getEnvAsInt("SPOOL_MAX_SIZE", 10<<20),
+		SpoolMaxAge:    getEnvAsDuration("SPOOL_MAX_AGE", time.Hour),
+		Collectors:     getEnv("COLLECTORS", "runtime,memory,cpu"),
 	}
 
 	flag.StringVar(&config.Address, "a", getEnv("ADDRESS", "localhost:8080"), "Address of the HTTP server endpoint")
@@ -36,11 +61,32 @@ func New() *Config {
 	flag.IntVar(&config.PollInterval, "p", getEnvAsInt("POLL_INTERVAL", 2), "Frequency of polling metrics from the runtime package")
 	flag.StringVar(&config.Key, "k", getEnv("KEY", ""), "API Key for authentication")
 	flag.IntVar(&config.RateLimit, "l", getEnvAsInt("RATE_LIMIT", 1), "Rate limit value")
+	flag.StringVar(&config.GRPCAddress, "grpc-address", getEnv("GRPC_ADDRESS", ""), "Address of the gRPC server endpoint, used instead of HTTP when set")
+	flag.StringVar(&config.CryptoKey, "crypto-key", getEnv("CRYPTO_KEY", ""), "Path to the server's RSA public key in PEM format used to encrypt request bodies")
+	flag.StringVar(&config.TLSCA, "tls-ca", getEnv("TLS_CA", ""), "Path to the CA bundle in PEM format used to verify the server certificate; enables TLS")
+	flag.StringVar(&config.TLSCert, "tls-cert", getEnv("TLS_CERT", ""), "Path to the agent client certificate in PEM format; enables TLS")
+	flag.StringVar(&config.TLSKey, "tls-key", getEnv("TLS_KEY", ""), "Path to the agent client certificate private key in PEM format")
+	flag.StringVar(&config.Tenant, "tenant", getEnv("TENANT", ""), "Tenant name sent in the X-Tenant header; requests are signed with the tenant key -k")
+	flag.StringVar(&config.AgentID, "agent-id", getEnv("AGENT_ID", ""), "Agent identifier sent in the agent_id label of every metric; defaults to the host name")
+
+	flag.IntVar(&config.RetryAttempts, "retry-attempts", getEnvAsInt("RETRY_ATTEMPTS", 3), "Number of retries of a failed request to the server; 0 disables retries")
+	flag.DurationVar(&config.RetryBaseDelay, "retry-base-delay", getEnvAsDuration("RETRY_BASE_DELAY", time.Second), "Delay before the first retry, doubled on every next retry")
+	flag.DurationVar(&config.RetryMaxDelay, "retry-max-delay", getEnvAsDuration("RETRY_MAX_DELAY", 10*time.Second), "Maximum delay between retries, also caps the server's Retry-After")
+	flag.Float64Var(&config.RetryJitter, "retry-jitter", getEnvAsFloat("RETRY_JITTER", 0.2), "Random deviation of the retry delay as a fraction of it, from 0 to 1")
+	flag.StringVar(&config.SpoolDir, "spool-dir", getEnv("SPOOL_DIR", ""), "Directory of the on-disk queue of batches the server did not accept; empty disables the queue")
+	flag.IntVar(&config.SpoolMaxSize, "spool-max-size", getEnvAsInt("SPOOL_MAX_SIZE", 10<<20), "Maximum size of the on-disk queue in bytes; the oldest batches are dropped when it is full")
+	flag.DurationVar(&config.SpoolMaxAge, "spool-max-age", getEnvAsDuration("SPOOL_MAX_AGE", time.Hour), "Maximum age of a batch in the on-disk queue")
+	flag.StringVar(&config.Collectors, "collectors", getEnv("COLLECTORS", "runtime,memory,cpu"), "Comma-separated collectors to enable, each optionally with its own poll interval, e.g. runtime,cpu:10s; the default interval is -p")
 
 	flag.Parse()
 	return config
 }
 
+// TLSEnabled сообщает, должен ли агент подключаться к серверу по TLS.
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCA != "" || c.TLSCert != ""
+}
+
 // getEnv возвращает значение переменной окружения или значение по умолчанию, если переменная не установлена.
 func getEnv(key, defaultValue string) string {
 	value, exists := os.LookupEnv(key)
@@ -61,3 +107,29 @@ func getEnvAsInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+// getEnvAsDuration возвращает значение переменной окружения в виде длительности (например, 500ms)
+// или значение по умолчанию, если переменная не установлена или некорректна.
+func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
+	valueStr := getEnv(key, "")
+	if valueStr != "" {
+		value, err := time.ParseDuration(valueStr)
+		if err == nil {
+			return value
+		}
+	}
+	return defaultValue
+}
+
+// getEnvAsFloat возвращает значение переменной окружения в виде числа с плавающей точкой
+// или значение по умолчанию, если переменная не установлена или некорректна.
+func getEnvAsFloat(key string, defaultValue float64) float64 {
+	valueStr := getEnv(key, "")
+	if valueStr != "" {
+		value, err := strconv.ParseFloat(valueStr, 64)
+		if err == nil {
+			return value
+		}
+	}
+	return defaultValue
+}
@@ -2,17 +2,34 @@ package server
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/SerjZimmer/devops/internal/storage"
+	"github.com/SerjZimmer/devops/internal/tenant"
 )
 
 // Config представляет структуру конфигурации для приложения.
 type Config struct {
-	Address  string
-	LogLevel string
-	Storage  *storage.Config
-	Key      string
+	Address             string
+	LogLevel            string
+	Storage             *storage.Config
+	Key                 string
+	StatsDAddress       string
+	StatsDFlushInterval int
+	GraphiteAddress     string
+	GraphiteTemplates   []string
+	GRPCAddress         string
+	CryptoKey           string
+	TLSCert             string
+	TLSKey              string
+	TLSClientCA         string
+	TrustedSubnet       string
+	TrustedSubnetReads  bool
+	TrustedProxies      []string
+	Tenants             tenant.Keys
 }
 
 // New создает новый экземпляр конфигурации с значениями по умолчанию или из переменных окружения и флагов командной строки.
@@ -23,12 +40,40 @@ func New() *Config {
 		LogLevel: getEnv("LOG_LEVEL", "info"),
 		Storage:  StorageConfig,
 		Key:      getEnv("KEY", ""),
+
+		StatsDAddress:       getEnv("STATSD_ADDRESS", ""),
+		StatsDFlushInterval: getEnvAsInt("STATSD_FLUSH_INTERVAL", 10),
+		GraphiteAddress:     getEnv("GRAPHITE_ADDRESS", ""),
+		GRPCAddress:         getEnv("GRPC_ADDRESS", ""),
+		CryptoKey:           getEnv("CRYPTO_KEY", ""),
+		TLSCert:             getEnv("TLS_CERT", ""),
+		TLSKey:              getEnv("TLS_KEY", ""),
+		TLSClientCA:         getEnv("TLS_CLIENT_CA", ""),
+		TrustedSubnet:       getEnv("TRUSTED_SUBNET", ""),
+		TrustedSubnetReads:  getEnvAsBool("TRUSTED_SUBNET_READS", false),
 	}
 
 	flag.StringVar(&config.Address, "a", getEnv("ADDRESS", "localhost:8080"), "Address of the HTTP server endpoint")
 	flag.StringVar(&config.LogLevel, "l", getEnv("LOG_LEVEL", "info"), "Logging level (e.g., 'info', 'debug')")
 	flag.StringVar(&config.Key, "k", getEnv("KEY", ""), "API Key for authentication")
+	flag.StringVar(&config.StatsDAddress, "statsd-address", getEnv("STATSD_ADDRESS", ""), "Address of the StatsD UDP/TCP listener, empty disables it")
+	flag.IntVar(&config.StatsDFlushInterval, "statsd-flush-interval", getEnvAsInt("STATSD_FLUSH_INTERVAL", 10), "Interval in seconds for flushing aggregated StatsD metrics to storage")
+	flag.StringVar(&config.GraphiteAddress, "graphite-address", getEnv("GRAPHITE_ADDRESS", ""), "Address of the Graphite plaintext TCP listener, empty disables it")
+	flag.StringVar(&config.GRPCAddress, "grpc-address", getEnv("GRPC_ADDRESS", ""), "Address of the gRPC server endpoint, empty disables it")
+	flag.StringVar(&config.CryptoKey, "crypto-key", getEnv("CRYPTO_KEY", ""), "Path to the RSA private key in PEM format used to decrypt agent request bodies")
+	flag.StringVar(&config.TLSCert, "tls-cert", getEnv("TLS_CERT", ""), "Path to the server certificate in PEM format; enables HTTPS and gRPC over TLS")
+	flag.StringVar(&config.TLSKey, "tls-key", getEnv("TLS_KEY", ""), "Path to the server certificate private key in PEM format")
+	flag.StringVar(&config.TLSClientCA, "tls-client-ca", getEnv("TLS_CLIENT_CA", ""), "Path to the CA bundle in PEM format used to require and verify client certificates")
+	flag.StringVar(&config.TrustedSubnet, "t", getEnv("TRUSTED_SUBNET", ""), "CIDR of agent subnets allowed to send updates, empty allows any address")
+	flag.BoolVar(&config.TrustedSubnetReads, "trusted-subnet-reads", getEnvAsBool("TRUSTED_SUBNET_READS", false), "Whether the trusted subnet also restricts read routes")
+	trustedProxies := flag.String("trusted-proxies", getEnv("TRUSTED_PROXIES", ""), "Comma-separated CIDRs of proxies allowed to pass the client address in X-Real-IP for the trusted subnet check")
+	tenants := flag.String("tenants", getEnv("TENANTS", ""), "Comma-separated tenant keys \"name:key\"; repeat a name to give a tenant several keys")
+	graphiteTemplates := flag.String("graphite-templates", getEnv("GRAPHITE_TEMPLATES", ""), "Comma-separated Graphite templates \"[filter] template\" extracting metric names and tags from paths")
 	flag.Parse()
+
+	config.GraphiteTemplates = splitList(*graphiteTemplates)
+	config.TrustedProxies = splitList(*trustedProxies)
+	config.Tenants = parseTenants(splitList(*tenants))
 	return config
 }
 
@@ -40,3 +85,55 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// splitList разбивает список значений, разделенных запятыми, пропуская пустые элементы.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// parseTenants разбирает ключи арендаторов вида "name:key". Имя может повторяться, чтобы задать
+// арендатору несколько ключей, например на время их смены. При некорректной записи функция паникует.
+func parseTenants(entries []string) tenant.Keys {
+	if len(entries) == 0 {
+		return nil
+	}
+	keys := make(tenant.Keys)
+	for _, entry := range entries {
+		name, key, found := strings.Cut(entry, ":")
+		if !found || !tenant.ValidName(name) || key == "" {
+			panic(fmt.Sprintf("invalid tenant entry %q, expected name:key", entry))
+		}
+		keys[name] = append(keys[name], key)
+	}
+	return keys
+}
+
+// getEnvAsInt возвращает значение переменной окружения в виде целого числа или значение по умолчанию, если переменная не установлена или не является числом.
+func getEnvAsInt(key string, defaultValue int) int {
+	valueStr := getEnv(key, "")
+	if valueStr != "" {
+		value, err := strconv.Atoi(valueStr)
+		if err == nil {
+			return value
+		}
+	}
+	return defaultValue
+}
+
+// getEnvAsBool возвращает значение переменной окружения в виде булевого значения или значение по умолчанию, если переменная не установлена или не является булевым значением.
+func getEnvAsBool(key string, defaultValue bool) bool {
+	valueStr := getEnv(key, "")
+	if valueStr != "" {
+		value, err := strconv.ParseBool(valueStr)
+		if err == nil {
+			return value
+		}
+	}
+	return defaultValue
+}
@@ -0,0 +1,157 @@
+// Package encryption реализует гибридное шифрование тел запросов агента:
+// данные шифруются AES-256-GCM на случайном ключе, а сам ключ - открытым ключом RSA сервера (RSA-OAEP, SHA-256).
+// Это снимает ограничение RSA на размер сообщения, поэтому шифруются пакеты любого размера.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Header - HTTP-заголовок, которым агент помечает зашифрованное тело запроса.
+const Header = "X-Encryption"
+
+// Scheme - значение заголовка Header для используемой схемы шифрования.
+const Scheme = "rsa-oaep-aes-256-gcm"
+
+// sessionKeySize - размер случайного ключа AES-256.
+const sessionKeySize = 32
+
+// errMalformed возвращается при расшифровке данных, не соответствующих формату Encrypt.
+var errMalformed = errors.New("malformed encrypted message")
+
+// Encrypt шифрует данные открытым ключом pub.
+// Результат имеет вид: длина зашифрованного ключа (2 байта, big-endian), зашифрованный ключ AES,
+// nonce GCM и шифртекст с тегом аутентификации.
+func Encrypt(pub *rsa.PublicKey, data []byte) ([]byte, error) {
+	sessionKey := make([]byte, sessionKeySize)
+	if _, err := rand.Read(sessionKey); err != nil {
+		return nil, err
+	}
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, sessionKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 2, 2+len(encryptedKey)+len(nonce)+len(data)+gcm.Overhead())
+	binary.BigEndian.PutUint16(out, uint16(len(encryptedKey)))
+	out = append(out, encryptedKey...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, data, nil), nil
+}
+
+// Decrypt расшифровывает данные, зашифрованные Encrypt, закрытым ключом priv.
+func Decrypt(priv *rsa.PrivateKey, data []byte) ([]byte, error) {
+	if len(data) < 2 {
+		return nil, errMalformed
+	}
+	keySize := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	if len(data) < keySize {
+		return nil, errMalformed
+	}
+	sessionKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, data[:keySize], nil)
+	if err != nil {
+		return nil, err
+	}
+	data = data[keySize:]
+
+	gcm, err := newGCM(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errMalformed
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
+
+// newGCM создает шифр AES-GCM на ключе key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// LoadPublicKey читает открытый ключ RSA из PEM-файла в формате PKIX ("PUBLIC KEY")
+// или PKCS #1 ("RSA PUBLIC KEY"). Сертификат ("CERTIFICATE") также принимается.
+func LoadPublicKey(path string) (*rsa.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var key any
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key = cert.PublicKey
+	default:
+		if key, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return nil, err
+		}
+	}
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an RSA public key", path)
+	}
+	return pub, nil
+}
+
+// LoadPrivateKey читает закрытый ключ RSA из PEM-файла в формате PKCS #1 ("RSA PRIVATE KEY")
+// или PKCS #8 ("PRIVATE KEY").
+func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an RSA private key", path)
+	}
+	return priv, nil
+}
+
+// readPEM читает первый PEM-блок файла.
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+	return block, nil
+}
@@ -0,0 +1,143 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testKey генерирует ключ RSA для тестов.
+func testKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return key
+}
+
+// writePEM записывает PEM-блок во временный файл и возвращает путь к нему.
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600))
+	return path
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key := testKey(t)
+	large := bytes.Repeat([]byte(`{"id":"HeapAlloc","type":"gauge","value":1.5}`), 20000)
+
+	for name, data := range map[string][]byte{"empty": {}, "small": []byte("metrics"), "large batch": large} {
+		t.Run(name, func(t *testing.T) {
+			encrypted, err := Encrypt(&key.PublicKey, data)
+			require.NoError(t, err)
+			assert.False(t, len(data) > 0 && bytes.Contains(encrypted, data))
+
+			decrypted, err := Decrypt(key, encrypted)
+			require.NoError(t, err)
+			assert.Equal(t, data, append([]byte{}, decrypted...))
+		})
+	}
+}
+
+func TestDecrypt_Errors(t *testing.T) {
+	key := testKey(t)
+	encrypted, err := Encrypt(&key.PublicKey, []byte("metrics"))
+	require.NoError(t, err)
+
+	tampered := append([]byte{}, encrypted...)
+	tampered[len(tampered)-1] ^= 1
+	_, err = Decrypt(key, tampered)
+	assert.Error(t, err)
+
+	_, err = Decrypt(testKey(t), encrypted)
+	assert.Error(t, err)
+
+	for _, data := range [][]byte{nil, {0}, {1, 0, 1}, encrypted[:2+256+4]} {
+		_, err = Decrypt(key, data)
+		assert.Error(t, err)
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	key := testKey(t)
+	pkix, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	for _, path := range []string{
+		writePEM(t, "PUBLIC KEY", pkix),
+		writePEM(t, "RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&key.PublicKey)),
+	} {
+		pub, err := LoadPublicKey(path)
+		require.NoError(t, err)
+		assert.True(t, key.PublicKey.Equal(pub))
+	}
+	for _, path := range []string{
+		writePEM(t, "PRIVATE KEY", pkcs8),
+		writePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)),
+	} {
+		priv, err := LoadPrivateKey(path)
+		require.NoError(t, err)
+		assert.True(t, key.Equal(priv))
+	}
+
+	_, err = LoadPublicKey(filepath.Join(t.TempDir(), "missing.pem"))
+	assert.Error(t, err)
+	notPEM := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(notPEM, []byte("not a key"), 0600))
+	_, err = LoadPrivateKey(notPEM)
+	assert.Error(t, err)
+}
+
+func TestDecryptMiddleware(t *testing.T) {
+	key := testKey(t)
+	encrypted, err := Encrypt(&key.PublicKey, []byte("metrics"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		priv       *rsa.PrivateKey
+		body       []byte
+		scheme     string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "encrypted", priv: key, body: encrypted, scheme: Scheme, wantStatus: http.StatusOK, wantBody: "metrics"},
+		{name: "plain", priv: key, body: []byte("plain"), wantStatus: http.StatusOK, wantBody: "plain"},
+		{name: "no key", body: []byte("plain"), scheme: Scheme, wantStatus: http.StatusOK, wantBody: "plain"},
+		{name: "unknown scheme", priv: key, body: encrypted, scheme: "rot13", wantStatus: http.StatusBadRequest},
+		{name: "wrong key", priv: testKey(t), body: encrypted, scheme: Scheme, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := io.ReadAll(r.Body)
+				require.NoError(t, err)
+				w.Write(body)
+			})
+			req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(tt.body))
+			if tt.scheme != "" {
+				req.Header.Set(Header, tt.scheme)
+			}
+			w := httptest.NewRecorder()
+			DecryptMiddleware(tt.priv)(next).ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus == http.StatusOK {
+				assert.Equal(t, tt.wantBody, w.Body.String())
+			}
+		})
+	}
+}
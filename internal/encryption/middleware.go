@@ -0,0 +1,43 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"io"
+	"net/http"
+)
+
+// DecryptMiddleware возвращает middleware, расшифровывающее тела запросов, помеченных заголовком Header,
+// закрытым ключом priv. Middleware ставится перед распаковкой gzip, так как агент сначала сжимает данные,
+// а затем шифрует. Если ключ не задан, запросы передаются дальше без изменений.
+func DecryptMiddleware(priv *rsa.PrivateKey) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scheme := r.Header.Get(Header)
+			if priv == nil || scheme == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if scheme != Scheme {
+				http.Error(w, "Неподдерживаемая схема шифрования", http.StatusBadRequest)
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				http.Error(w, "Ошибка чтения тела запроса", http.StatusBadRequest)
+				return
+			}
+			plain, err := Decrypt(priv, body)
+			if err != nil {
+				http.Error(w, "Ошибка расшифровки тела запроса", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(plain))
+			r.ContentLength = int64(len(plain))
+			r.Header.Del(Header)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,207 @@
+// Package graphite реализует прием метрик по текстовому протоколу Graphite через TCP.
+// Каждая строка имеет вид "path value [timestamp]"; значения записываются в хранилище как gauge.
+// Если задана доверенная подсеть, соединения с адресов вне нее закрываются сразу после приема.
+package graphite
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SerjZimmer/devops/internal/selfmetrics"
+	"github.com/SerjZimmer/devops/internal/storage"
+	"github.com/SerjZimmer/devops/internal/subnet"
+)
+
+// maxBatchSize ограничивает число метрик, записываемых в хранилище одним пакетом.
+const maxBatchSize = 1000
+
+// metricsStorage представляет хранилище, в которое записываются принятые метрики.
+type metricsStorage interface {
+	UpdateMetricsValue(m []storage.Metrics) error
+}
+
+// point представляет собой строку протокола Graphite, разобранную в метрику.
+type point struct {
+	Name      string
+	Tags      map[string]string
+	Value     float64
+	Timestamp time.Time
+}
+
+// Server принимает метрики Graphite и записывает их в хранилище.
+// Строки, пришедшие в соединение одним блоком, записываются одним пакетом.
+type Server struct {
+	addr      string
+	templates []template
+	stor      metricsStorage
+	trusted   *subnet.Trusted
+	self      *selfmetrics.Registry
+
+	listener net.Listener
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// New создает новый экземпляр Server, слушающий addr. Пути метрик разбираются по правилам templates.
+// Соединения принимаются только с адресов из доверенной подсети trusted; nil разрешает любые адреса.
+// При некорректном правиле функция паникует.
+func New(addr string, templates []string, stor metricsStorage, trusted *subnet.Trusted) *Server {
+	s := &Server{
+		addr:    addr,
+		stor:    stor,
+		trusted: trusted,
+		self:    selfmetrics.Default,
+		done:    make(chan struct{}),
+	}
+	for _, t := range templates {
+		parsed, err := parseTemplate(t)
+		if err != nil {
+			panic(err)
+		}
+		s.templates = append(s.templates, parsed)
+	}
+	return s
+}
+
+// Start начинает прием соединений на адресе сервера.
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	fmt.Printf("Graphite запущен на %v\n", s.addr)
+
+	s.wg.Add(1)
+	go s.serve()
+	return nil
+}
+
+// Shutdown закрывает соединения и дожидается записи уже принятых метрик.
+func (s *Server) Shutdown() {
+	close(s.done)
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.wg.Wait()
+}
+
+// serve принимает соединения до остановки сервера.
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			fmt.Println("Ошибка подключения Graphite:", err)
+			continue
+		}
+		if !s.trusted.AllowsAddr(conn.RemoteAddr()) {
+			s.self.Add("graphite_rejected_connections_total", 1)
+			conn.Close()
+			continue
+		}
+		s.wg.Add(1)
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn читает строки из соединения и записывает метрики, когда очередной блок данных прочитан полностью.
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer conn.Close()
+
+	closed := make(chan struct{})
+	defer close(closed)
+	go func() {
+		select {
+		case <-s.done:
+			conn.Close()
+		case <-closed:
+		}
+	}()
+
+	reader := bufio.NewReader(conn)
+	var batch []storage.Metrics
+	for {
+		line, err := reader.ReadString('\n')
+		if m, ok := s.handleLine(line); ok {
+			batch = append(batch, m)
+		}
+		if err != nil || reader.Buffered() == 0 || len(batch) >= maxBatchSize {
+			s.write(batch)
+			batch = nil
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// handleLine разбирает строку Graphite и учитывает ошибки разбора в собственных метриках сервера.
+func (s *Server) handleLine(line string) (storage.Metrics, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return storage.Metrics{}, false
+	}
+	s.self.Add("graphite_lines_total", 1)
+
+	p, err := parseLine(line, s.templates, time.Now())
+	if err != nil {
+		s.self.Add("graphite_parse_errors_total", 1)
+		return storage.Metrics{}, false
+	}
+	value := p.Value
+	return storage.Metrics{ID: p.Name, MType: "gauge", Value: &value, Labels: storage.SanitizeLabels(p.Tags)}, true
+}
+
+// write записывает пакет метрик в хранилище.
+func (s *Server) write(batch []storage.Metrics) {
+	if len(batch) == 0 {
+		return
+	}
+	if err := s.stor.UpdateMetricsValue(batch); err != nil {
+		s.self.Add("graphite_write_errors_total", 1)
+		fmt.Println("Ошибка записи метрик Graphite:", err)
+		return
+	}
+	s.self.Add("graphite_metrics_total", int64(len(batch)))
+}
+
+// parseLine разбирает строку "path value [timestamp]". Метка времени задается в секундах unix-времени;
+// значение -1 или ее отсутствие означают текущее время now. Теги, извлеченные правилами, становятся метками ряда.
+func parseLine(line string, templates []template, now time.Time) (point, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return point{}, fmt.Errorf("expected \"path value [timestamp]\", got %q", line)
+	}
+
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return point{}, fmt.Errorf("invalid value %q", fields[1])
+	}
+
+	p := point{Value: value, Timestamp: now}
+	p.Name, p.Tags = parsePath(fields[0], templates)
+	if !storage.ValidID(p.Name) {
+		return point{}, fmt.Errorf("invalid metric name %q", p.Name)
+	}
+
+	if len(fields) == 3 && fields[2] != "-1" {
+		seconds, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return point{}, fmt.Errorf("invalid timestamp %q", fields[2])
+		}
+		p.Timestamp = time.Unix(0, int64(seconds*float64(time.Second)))
+	}
+	return p, nil
+}
@@ -0,0 +1,127 @@
+package graphite
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SerjZimmer/devops/internal/selfmetrics"
+	"github.com/SerjZimmer/devops/internal/storage"
+	"github.com/SerjZimmer/devops/internal/subnet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTemplate(t *testing.T) {
+	for _, s := range []string{"measurement", "servers.* .host.measurement*", "measurement.field"} {
+		_, err := parseTemplate(s)
+		assert.NoError(t, err, s)
+	}
+	for _, s := range []string{"host.region", "measurement*.host", "a b c"} {
+		_, err := parseTemplate(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	var templates []template
+	for _, s := range []string{
+		"servers.* .host.measurement*",
+		"apps.*.requests .app.measurement.status",
+	} {
+		parsed, err := parseTemplate(s)
+		require.NoError(t, err)
+		templates = append(templates, parsed)
+	}
+
+	name, tags := parsePath("servers.web01.cpu.load", templates)
+	assert.Equal(t, "cpu.load", name)
+	assert.Equal(t, map[string]string{"host": "web01"}, tags)
+
+	name, tags = parsePath("apps.billing.requests.500", templates)
+	assert.Equal(t, "requests", name)
+	assert.Equal(t, map[string]string{"app": "billing", "status": "500"}, tags)
+
+	// Путь без подходящего правила используется как имя целиком
+	name, tags = parsePath("cron.backup.duration", templates)
+	assert.Equal(t, "cron.backup.duration", name)
+	assert.Empty(t, tags)
+}
+
+func TestParseLine(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	p, err := parseLine("cron.backup.duration 12.5 1600000000", nil, now)
+	require.NoError(t, err)
+	assert.Equal(t, "cron.backup.duration", p.Name)
+	assert.Equal(t, 12.5, p.Value)
+	assert.Equal(t, time.Unix(1600000000, 0), p.Timestamp)
+
+	p, err = parseLine("cron.backup.duration 1 -1", nil, now)
+	require.NoError(t, err)
+	assert.Equal(t, now, p.Timestamp)
+
+	for _, line := range []string{"cron.backup", "cron.backup abc 1", "cron.backup 1 abc", "cron.backup 1 2 3", "cron.backup NaN",
+		`HeapAlloc{host="web-1"} 5`, "cron,backup 1"} {
+		_, err := parseLine(line, nil, now)
+		assert.Error(t, err, line)
+	}
+}
+
+func TestServer(t *testing.T) {
+	stor := storage.NewMemoryStorage()
+	s := New("127.0.0.1:0", []string{"servers.* .host.measurement*"}, stor, nil)
+	s.self = selfmetrics.New()
+	require.NoError(t, s.Start())
+
+	conn, err := net.Dial("tcp", s.listener.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("servers.web01.cpu.load 0.75 1700000000\nbroken line here now\ncron.backup.duration 12\n"))
+	require.NoError(t, err)
+	conn.Close()
+
+	assert.Eventually(t, func() bool {
+		value, err := stor.GetMetricByName(storage.Metrics{ID: "cron.backup.duration", MType: "gauge"})
+		return err == nil && value == 12
+	}, time.Second, 10*time.Millisecond)
+	s.Shutdown()
+
+	// Теги, извлеченные правилом, становятся метками ряда
+	value, err := stor.GetMetricByName(storage.Metrics{ID: "cpu.load", MType: "gauge", Labels: map[string]string{"host": "web01"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 0.75, value)
+
+	errors := map[string]float64{}
+	for _, m := range s.self.Metrics() {
+		errors[m.ID] = m.Float64()
+	}
+	assert.Equal(t, float64(1), errors["graphite_parse_errors_total"])
+	assert.Equal(t, float64(3), errors["graphite_lines_total"])
+}
+
+func TestServer_TrustedSubnet(t *testing.T) {
+	trusted, err := subnet.Parse("192.168.1.0/24", nil)
+	require.NoError(t, err)
+	stor := storage.NewMemoryStorage()
+	s := New("127.0.0.1:0", nil, stor, trusted)
+	s.self = selfmetrics.New()
+	require.NoError(t, s.Start())
+
+	// Соединение с адреса вне подсети закрывается без чтения
+	conn, err := net.Dial("tcp", s.listener.Addr().String())
+	require.NoError(t, err)
+	_, _ = conn.Write([]byte("cron.backup.duration 12\n"))
+	conn.Close()
+
+	assert.Eventually(t, func() bool {
+		for _, m := range s.self.Metrics() {
+			if m.ID == "graphite_rejected_connections_total" {
+				return m.Float64() == 1
+			}
+		}
+		return false
+	}, time.Second, 10*time.Millisecond)
+	s.Shutdown()
+	_, err = stor.GetMetricByName(storage.Metrics{ID: "cron.backup.duration", MType: "gauge"})
+	assert.Error(t, err)
+}
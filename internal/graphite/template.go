@@ -0,0 +1,100 @@
+package graphite
+
+import (
+	"fmt"
+	"strings"
+)
+
+// template описывает правило разбора пути Graphite в имя метрики и теги.
+//
+// Правило записывается как "[фильтр] шаблон". Фильтр - путь с точками, в котором * совпадает с любым
+// сегментом; правило применяется к путям, начинающимся с сегментов фильтра. Сегменты шаблона:
+// measurement - сегмент входит в имя метрики, measurement* - в имя входят этот и все оставшиеся сегменты,
+// пустой сегмент - сегмент пропускается, любое другое слово - сегмент становится значением тега с этим именем.
+// Например, правило "servers.* .host.measurement*" превращает путь servers.web01.cpu.load
+// в метрику cpu.load с тегом host=web01.
+type template struct {
+	filter []string
+	parts  []string
+}
+
+// parseTemplate разбирает правило "[фильтр] шаблон".
+func parseTemplate(s string) (template, error) {
+	fields := strings.Fields(s)
+	var t template
+	switch len(fields) {
+	case 1:
+		t.parts = strings.Split(fields[0], ".")
+	case 2:
+		t.filter = strings.Split(fields[0], ".")
+		t.parts = strings.Split(fields[1], ".")
+	default:
+		return t, fmt.Errorf("invalid graphite template %q", s)
+	}
+
+	hasMeasurement := false
+	for i, part := range t.parts {
+		switch part {
+		case "measurement":
+			hasMeasurement = true
+		case "measurement*":
+			if i != len(t.parts)-1 {
+				return t, fmt.Errorf("measurement* must be the last part of graphite template %q", s)
+			}
+			hasMeasurement = true
+		}
+	}
+	if !hasMeasurement {
+		return t, fmt.Errorf("graphite template %q has no measurement part", s)
+	}
+	return t, nil
+}
+
+// matches проверяет, подходит ли путь под фильтр правила. Правило без фильтра подходит для любого пути.
+func (t template) matches(segments []string) bool {
+	if len(segments) < len(t.filter) {
+		return false
+	}
+	for i, f := range t.filter {
+		if f != "*" && f != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// apply превращает сегменты пути в имя метрики и теги.
+// Сегменты за пределами шаблона без measurement* отбрасываются.
+func (t template) apply(segments []string) (string, map[string]string) {
+	var name []string
+	tags := make(map[string]string)
+	for i, part := range t.parts {
+		if i >= len(segments) {
+			break
+		}
+		switch part {
+		case "":
+		case "measurement":
+			name = append(name, segments[i])
+		case "measurement*":
+			name = append(name, segments[i:]...)
+		default:
+			tags[part] = segments[i]
+		}
+	}
+	return strings.Join(name, "."), tags
+}
+
+// parsePath превращает путь Graphite в имя метрики и теги по первому подходящему правилу.
+// Если ни одно правило не подходит, имя метрики совпадает с путем.
+func parsePath(path string, templates []template) (string, map[string]string) {
+	segments := strings.Split(path, ".")
+	for _, t := range templates {
+		if t.matches(segments) {
+			if name, tags := t.apply(segments); name != "" {
+				return name, tags
+			}
+		}
+	}
+	return path, map[string]string{}
+}
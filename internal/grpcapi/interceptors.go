@@ -0,0 +1,155 @@
+package grpcapi
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/SerjZimmer/devops/internal/hash"
+	pb "github.com/SerjZimmer/devops/internal/proto"
+	"github.com/SerjZimmer/devops/internal/selfmetrics"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// hashMetadataKey - ключ метаданных с подписью HMAC-SHA256, аналог HTTP-заголовка HashSHA256.
+const hashMetadataKey = "hashsha256"
+
+// LoggingUnaryInterceptor логирует унарные вызовы так же, как LoggingMiddleware логирует HTTP-запросы,
+// и учитывает их в собственных метриках сервера. Содержимое сообщений не логируется, только их размер.
+func LoggingUnaryInterceptor(logger *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		startTime := time.Now()
+
+		resp, err := handler(ctx, req)
+
+		logCall(logger, info.FullMethod, startTime, messageSize(req), err)
+		return resp, err
+	}
+}
+
+// LoggingStreamInterceptor логирует потоковые вызовы и учитывает их в собственных метриках сервера.
+// Размером вызова считается суммарный размер принятых сообщений.
+func LoggingStreamInterceptor(logger *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		startTime := time.Now()
+		stream := &sizedStream{ServerStream: ss}
+
+		err := handler(srv, stream)
+
+		logCall(logger, info.FullMethod, startTime, stream.size, err)
+		return err
+	}
+}
+
+// sizedStream подсчитывает суммарный размер сообщений, принятых в потоке.
+type sizedStream struct {
+	grpc.ServerStream
+	size int
+}
+
+// RecvMsg принимает сообщение потока и добавляет его размер к счетчику.
+func (s *sizedStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.size += messageSize(m)
+	}
+	return err
+}
+
+// messageSize возвращает размер сериализованного сообщения в байтах.
+func messageSize(msg any) int {
+	if m, ok := msg.(proto.Message); ok {
+		return proto.Size(m)
+	}
+	return 0
+}
+
+// logCall логирует завершение вызова с кодом статуса, длительностью и размером запроса.
+func logCall(logger *zap.Logger, method string, startTime time.Time, size int, err error) {
+	code := status.Code(err)
+	selfmetrics.Default.Add("grpc_requests_total", 1)
+	if err != nil {
+		selfmetrics.Default.Add("grpc_errors_total", 1)
+	}
+	logger.Info("Request processed",
+		zap.String("Method", method),
+		zap.String("Code", code.String()),
+		zap.Duration("Duration", time.Since(startTime)),
+		zap.Int("Size", size),
+	)
+}
+
+// HashUnaryInterceptor повторяет HashSHA256Middleware для унарных вызовов. Если задан ключ key,
+// подпись из метаданных hashsha256 обязательна и сверяется с сериализованным сообщением запроса;
+// без подписи или при несовпадении возвращается InvalidArgument. Сообщение ответа подписывается тем же ключом
+// в заголовке ответа.
+func HashUnaryInterceptor(key string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if key == "" {
+			return handler(ctx, req)
+		}
+		sign := incomingHash(ctx)
+		if sign == "" {
+			return nil, status.Error(codes.InvalidArgument, "запрос без подписи")
+		}
+		if !verifyMessage(key, req, sign) {
+			return nil, status.Error(codes.InvalidArgument, "неверная подпись запроса")
+		}
+
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		data, err := marshalSigned(resp)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if err := grpc.SetHeader(ctx, metadata.Pairs(hashMetadataKey, hash.Sign(key, data))); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+}
+
+// HashStreamInterceptor повторяет HashSHA256Middleware для потоковых вызовов.
+// Метаданные передаются один раз на весь поток и не могут подписать каждое сообщение, поэтому сообщения
+// потока проверить нельзя: при заданном ключе все потоковые вызовы отклоняются с кодом Unauthenticated,
+// и вместо них следует использовать UpdateMetrics.
+func HashStreamInterceptor(key string) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if key != "" {
+			return status.Error(codes.Unauthenticated, "потоковые вызовы нельзя подписать, используйте UpdateMetrics")
+		}
+		return handler(srv, ss)
+	}
+}
+
+// incomingHash возвращает подпись из метаданных запроса или пустую строку.
+func incomingHash(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(hashMetadataKey); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// verifyMessage проверяет подпись sign сериализованного сообщения msg.
+func verifyMessage(key string, msg any, sign string) bool {
+	data, err := marshalSigned(msg)
+	return err == nil && hash.Verify(key, data, sign)
+}
+
+// marshalSigned сериализует сообщение для подписи. Детерминированная сериализация дает
+// одинаковые байты на клиенте и сервере при одинаковом содержимом сообщения.
+func marshalSigned(msg any) ([]byte, error) {
+	m, ok := msg.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message type %T", msg)
+	}
+	return pb.MarshalSigned(m)
+}
@@ -0,0 +1,181 @@
+// Package grpcapi реализует gRPC-сервис метрик, работающий с тем же хранилищем, что и HTTP API.
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	pb "github.com/SerjZimmer/devops/internal/proto"
+	"github.com/SerjZimmer/devops/internal/selfmetrics"
+	"github.com/SerjZimmer/devops/internal/storage"
+	"github.com/SerjZimmer/devops/internal/subnet"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// metricsStorage представляет хранилище, с которым работает сервис.
+type metricsStorage interface {
+	GetMetric(m storage.Metrics) (storage.Metrics, error)
+	ListMetrics() ([]storage.Metrics, error)
+	UpdateMetricsValue(m []storage.Metrics) error
+}
+
+// MetricsServer реализует gRPC-сервис Metrics.
+type MetricsServer struct {
+	pb.UnimplementedMetricsServer
+	stor metricsStorage
+	self *selfmetrics.Registry
+}
+
+// NewMetricsServer создает новый экземпляр MetricsServer.
+func NewMetricsServer(stor metricsStorage) *MetricsServer {
+	return &MetricsServer{stor: stor, self: selfmetrics.Default}
+}
+
+// NewServer создает gRPC-сервер с зарегистрированным сервисом Metrics и перехватчиками логирования,
+// проверки доверенной подсети и проверки подписи, повторяющими middleware HTTP API. Как и в HTTP API,
+// подсеть trusted проверяется до подписи; вызовы чтения проверяются только при reads. Пустой ключ key
+// отключает подпись, nil вместо trusted - проверку подсети.
+// Дополнительные параметры opts (например, учетные данные TLS) передаются в grpc.NewServer.
+func NewServer(stor metricsStorage, logger *zap.Logger, key string, trusted *subnet.Trusted, reads bool, opts ...grpc.ServerOption) *grpc.Server {
+	server := grpc.NewServer(append([]grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(LoggingUnaryInterceptor(logger),
+			TrustedSubnetUnaryInterceptor(trusted, reads), HashUnaryInterceptor(key)),
+		grpc.ChainStreamInterceptor(LoggingStreamInterceptor(logger),
+			TrustedSubnetStreamInterceptor(trusted, reads), HashStreamInterceptor(key)),
+	}, opts...)...)
+	pb.RegisterMetricsServer(server, NewMetricsServer(stor))
+	return server
+}
+
+// UpdateMetrics записывает пакет метрик в хранилище.
+func (s *MetricsServer) UpdateMetrics(ctx context.Context, req *pb.UpdateMetricsRequest) (*pb.UpdateMetricsResponse, error) {
+	accepted, err := s.update(req.GetMetrics())
+	if err != nil {
+		return nil, err
+	}
+	return &pb.UpdateMetricsResponse{Accepted: accepted}, nil
+}
+
+// PushMetrics записывает пакеты метрик из потока по мере поступления и возвращает их общее число.
+func (s *MetricsServer) PushMetrics(stream pb.Metrics_PushMetricsServer) error {
+	var accepted int64
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&pb.UpdateMetricsResponse{Accepted: accepted})
+		}
+		if err != nil {
+			return err
+		}
+		n, err := s.update(req.GetMetrics())
+		if err != nil {
+			return err
+		}
+		accepted += n
+	}
+}
+
+// GetMetric возвращает метрику по имени, типу и точному набору меток.
+func (s *MetricsServer) GetMetric(ctx context.Context, req *pb.GetMetricRequest) (*pb.GetMetricResponse, error) {
+	switch req.GetType() {
+	case "gauge", "counter", "histogram", "summary":
+	default:
+		return nil, status.Error(codes.InvalidArgument, "неверный тип метрики")
+	}
+	stored, err := s.stor.GetMetric(storage.Metrics{ID: req.GetId(), MType: req.GetType(), Labels: req.GetLabels()})
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "неверное имя метрики")
+	}
+	return &pb.GetMetricResponse{Metric: ToProto(stored)}, nil
+}
+
+// ListMetrics возвращает все метрики хранилища.
+func (s *MetricsServer) ListMetrics(ctx context.Context, req *pb.ListMetricsRequest) (*pb.ListMetricsResponse, error) {
+	metrics, err := s.stor.ListMetrics()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &pb.ListMetricsResponse{Metrics: make([]*pb.Metric, 0, len(metrics))}
+	for _, m := range metrics {
+		resp.Metrics = append(resp.Metrics, ToProto(m))
+	}
+	return resp, nil
+}
+
+// update проверяет и записывает пакет метрик в хранилище.
+func (s *MetricsServer) update(in []*pb.Metric) (int64, error) {
+	metrics := make([]storage.Metrics, 0, len(in))
+	for _, m := range in {
+		metric := FromProto(m)
+		if !isValidMetric(metric) {
+			return 0, status.Errorf(codes.InvalidArgument, "некорректная метрика %q", m.GetId())
+		}
+		metrics = append(metrics, metric)
+	}
+	if len(metrics) == 0 {
+		return 0, nil
+	}
+
+	if err := s.stor.UpdateMetricsValue(metrics); err != nil {
+		s.self.Add("metric_update_errors_total", int64(len(metrics)))
+		return 0, status.Error(codes.Internal, err.Error())
+	}
+	s.self.Add("metric_updates_total", int64(len(metrics)))
+	return int64(len(metrics)), nil
+}
+
+// isValidMetric проверяет метрику по тем же правилам, что и JSON API.
+func isValidMetric(m storage.Metrics) bool {
+	switch {
+	case !storage.ValidID(m.ID), !storage.ValidLabels(m.Labels):
+		return false
+	case m.MType == "gauge":
+		return m.Value != nil
+	case m.MType == "counter":
+		return m.Delta != nil || m.ID == "PollCount"
+	case m.MType == "histogram":
+		return m.Histogram != nil && m.Histogram.Validate() == nil
+	case m.MType == "summary":
+		return m.Summary != nil && m.Summary.Validate() == nil
+	default:
+		return false
+	}
+}
+
+// ToProto преобразует метрику хранилища в сообщение gRPC.
+func ToProto(m storage.Metrics) *pb.Metric {
+	pm := &pb.Metric{Id: m.ID, Type: m.MType, Delta: m.Delta, Value: m.Value, Labels: m.Labels}
+	if m.Histogram != nil {
+		pm.Histogram = &pb.Histogram{Bounds: m.Histogram.Bounds, Counts: m.Histogram.Counts, Sum: m.Histogram.Sum}
+	}
+	if m.Summary != nil {
+		pm.Summary = &pb.Summary{Count: m.Summary.Count, Sum: m.Summary.Sum}
+		for _, q := range m.Summary.Quantiles {
+			pm.Summary.Quantiles = append(pm.Summary.Quantiles, &pb.Summary_Quantile{Quantile: q.Quantile, Value: q.Value})
+		}
+	}
+	return pm
+}
+
+// FromProto преобразует сообщение gRPC в метрику хранилища.
+func FromProto(m *pb.Metric) storage.Metrics {
+	var labels map[string]string
+	if len(m.GetLabels()) > 0 {
+		labels = m.GetLabels()
+	}
+	metric := storage.Metrics{ID: m.GetId(), MType: m.GetType(), Delta: m.Delta, Value: m.Value, Labels: labels}
+	if h := m.GetHistogram(); h != nil {
+		metric.Histogram = &storage.Histogram{Bounds: h.GetBounds(), Counts: h.GetCounts(), Sum: h.GetSum()}
+	}
+	if s := m.GetSummary(); s != nil {
+		metric.Summary = &storage.Summary{Count: s.GetCount(), Sum: s.GetSum()}
+		for _, q := range s.GetQuantiles() {
+			metric.Summary.Quantiles = append(metric.Summary.Quantiles, storage.Quantile{Quantile: q.GetQuantile(), Value: q.GetValue()})
+		}
+	}
+	return metric
+}
@@ -0,0 +1,299 @@
+package grpcapi
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/SerjZimmer/devops/internal/hash"
+	pb "github.com/SerjZimmer/devops/internal/proto"
+	"github.com/SerjZimmer/devops/internal/storage"
+	"github.com/SerjZimmer/devops/internal/subnet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+// newTestClient запускает сервис с ключом подписи key на соединении в памяти и возвращает клиент к нему.
+func newTestClient(t *testing.T, stor metricsStorage, key string, opts ...grpc.ServerOption) pb.MetricsClient {
+	return newLoggedTestClient(t, stor, zap.NewNop(), key, opts...)
+}
+
+// newLoggedTestClient работает как newTestClient, но сервис пишет лог в logger.
+func newLoggedTestClient(t *testing.T, stor metricsStorage, logger *zap.Logger, key string, opts ...grpc.ServerOption) pb.MetricsClient {
+	listener := bufconn.Listen(1 << 20)
+	server := NewServer(stor, logger, key, nil, false, opts...)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewMetricsClient(conn)
+}
+
+func float64Ptr(value float64) *float64 {
+	return &value
+}
+
+func int64Ptr(value int64) *int64 {
+	return &value
+}
+
+func TestMetricsServer(t *testing.T) {
+	client := newTestClient(t, storage.TestMetricStorage(), "")
+	ctx := context.Background()
+
+	resp, err := client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+		{Id: "HeapAlloc", Type: "gauge", Value: float64Ptr(1.5)},
+		{Id: "PollCount", Type: "counter", Delta: int64Ptr(2)},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), resp.GetAccepted())
+
+	got, err := client.GetMetric(ctx, &pb.GetMetricRequest{Id: "PollCount", Type: "counter"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), got.GetMetric().GetDelta())
+
+	_, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "Unknown", Type: "gauge"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "Broken", Type: "gauge"}}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	list, err := client.ListMetrics(ctx, &pb.ListMetricsRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.GetMetrics(), 2)
+	assert.Equal(t, "HeapAlloc", list.GetMetrics()[0].GetId())
+	assert.Equal(t, 1.5, list.GetMetrics()[0].GetValue())
+
+	// Ряды с метками хранятся отдельно от ряда без меток
+	web1 := map[string]string{"host": "web-1"}
+	_, err = client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+		{Id: "PollCount", Type: "counter", Delta: int64Ptr(5), Labels: web1},
+	}})
+	require.NoError(t, err)
+	got, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "PollCount", Type: "counter", Labels: web1})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), got.GetMetric().GetDelta())
+	assert.Equal(t, web1, got.GetMetric().GetLabels())
+
+	_, err = client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+		{Id: "PollCount", Type: "counter", Delta: int64Ptr(1), Labels: map[string]string{"host name": "x"}},
+	}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestMetricsServer_PushMetrics(t *testing.T) {
+	stor := storage.TestMetricStorage()
+	client := newTestClient(t, stor, "")
+
+	stream, err := client.PushMetrics(context.Background())
+	require.NoError(t, err)
+	for _, delta := range []int64{1, 2, 3} {
+		require.NoError(t, stream.Send(&pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "requests", Type: "counter", Delta: int64Ptr(delta)}}}))
+	}
+	resp, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), resp.GetAccepted())
+
+	value, err := stor.GetMetricByName(storage.Metrics{ID: "requests", MType: "counter"})
+	require.NoError(t, err)
+	assert.Equal(t, float64(6), value)
+}
+
+func TestLoggingInterceptors(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	client := newLoggedTestClient(t, storage.TestMetricStorage(), zap.New(core), "")
+
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "HeapAlloc", Type: "gauge", Value: float64Ptr(1.5)}}}
+	_, err := client.UpdateMetrics(context.Background(), req)
+	require.NoError(t, err)
+	stream, err := client.PushMetrics(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(req))
+	require.NoError(t, stream.Send(req))
+	_, err = stream.CloseAndRecv()
+	require.NoError(t, err)
+
+	// Логируются метод, код, длительность и размер, но не содержимое сообщений
+	entries := logs.All()
+	require.Len(t, entries, 2)
+	for i, size := range []int{proto.Size(req), 2 * proto.Size(req)} {
+		fields := entries[i].ContextMap()
+		assert.Equal(t, "OK", fields["Code"])
+		assert.Equal(t, int64(size), fields["Size"])
+		assert.Contains(t, fields, "Duration")
+		assert.NotContains(t, fields, "Request")
+	}
+	assert.Equal(t, "/metrics.Metrics/UpdateMetrics", entries[0].ContextMap()["Method"])
+}
+
+func TestHashUnaryInterceptor(t *testing.T) {
+	const key = "secret"
+	stor := storage.TestMetricStorage()
+	client := newTestClient(t, stor, key)
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "signed", Type: "counter", Delta: int64Ptr(1)}}}
+	data, err := pb.MarshalSigned(req)
+	require.NoError(t, err)
+
+	var header metadata.MD
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "HashSHA256", hash.Sign(key, data))
+	resp, err := client.UpdateMetrics(ctx, req, grpc.Header(&header))
+	require.NoError(t, err)
+	respData, err := pb.MarshalSigned(resp)
+	require.NoError(t, err)
+	require.Len(t, header.Get(hashMetadataKey), 1)
+	assert.True(t, hash.Verify(key, respData, header.Get(hashMetadataKey)[0]))
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "HashSHA256", hash.Sign("other", data))
+	_, err = client.UpdateMetrics(ctx, req)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Без подписи запрос отклоняется
+	_, err = client.UpdateMetrics(context.Background(), req)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	value, err := stor.GetMetricByName(storage.Metrics{ID: "signed", MType: "counter"})
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), value)
+}
+
+func TestHashStreamInterceptor(t *testing.T) {
+	stor := storage.TestMetricStorage()
+	client := newTestClient(t, stor, "secret")
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "streamed", Type: "counter", Delta: int64Ptr(1)}}}
+
+	// С ключом на сервере потоки отклоняются независимо от метаданных, и в хранилище ничего не пишется
+	for _, ctx := range []context.Context{
+		context.Background(),
+		metadata.AppendToOutgoingContext(context.Background(), "HashSHA256", "abc"),
+	} {
+		stream, err := client.PushMetrics(ctx)
+		require.NoError(t, err)
+		_ = stream.Send(req)
+		_, err = stream.CloseAndRecv()
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	}
+	_, err := stor.GetMetricByName(storage.Metrics{ID: "streamed", MType: "counter"})
+	assert.Error(t, err)
+}
+
+// newTCPTestClient запускает сервис с проверкой доверенной подсети на локальном TCP-адресе,
+// чтобы адресом клиента был 127.0.0.1, и возвращает клиент к нему.
+func newTCPTestClient(t *testing.T, key string, trusted *subnet.Trusted, reads bool) pb.MetricsClient {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := NewServer(storage.TestMetricStorage(), zap.NewNop(), key, trusted, reads)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewMetricsClient(conn)
+}
+
+func TestTrustedSubnetInterceptors(t *testing.T) {
+	behindProxy, err := subnet.Parse("192.168.1.0/24", []string{"127.0.0.0/8"})
+	require.NoError(t, err)
+	direct, err := subnet.Parse("192.168.1.0/24", nil)
+	require.NoError(t, err)
+	local, err := subnet.Parse("127.0.0.0/8", nil)
+	require.NoError(t, err)
+
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "requests", Type: "counter", Delta: int64Ptr(1)}}}
+	trusted := metadata.AppendToOutgoingContext(context.Background(), "X-Real-IP", "192.168.1.10")
+	untrusted := metadata.AppendToOutgoingContext(context.Background(), "X-Real-IP", "10.0.0.1")
+
+	for _, reads := range []bool{false, true} {
+		// Адрес из метаданных учитывается только для соединений от доверенного прокси
+		client := newTCPTestClient(t, "", behindProxy, reads)
+		_, err = client.UpdateMetrics(trusted, req)
+		assert.NoError(t, err)
+		_, err = client.UpdateMetrics(untrusted, req)
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		_, err = client.UpdateMetrics(context.Background(), req)
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+		stream, err := client.PushMetrics(untrusted)
+		require.NoError(t, err)
+		_, err = stream.CloseAndRecv()
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+		_, err = client.ListMetrics(untrusted, &pb.ListMetricsRequest{})
+		if reads {
+			assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		} else {
+			assert.NoError(t, err)
+		}
+
+		// Без доверенных прокси клиент не может подставить адрес из подсети
+		client = newTCPTestClient(t, "", direct, reads)
+		_, err = client.UpdateMetrics(trusted, req)
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+		client = newTCPTestClient(t, "", local, reads)
+		_, err = client.UpdateMetrics(untrusted, req)
+		assert.NoError(t, err)
+	}
+
+	// Подсеть проверяется до подписи
+	client := newTCPTestClient(t, "secret", direct, false)
+	_, err = client.UpdateMetrics(context.Background(), req)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestMetricsServer_Histogram(t *testing.T) {
+	client := newTestClient(t, storage.TestMetricStorage(), "")
+	ctx := context.Background()
+
+	h := &pb.Histogram{Bounds: []float64{0.1, 1}, Counts: []uint64{1, 2, 0}, Sum: 1.5}
+	_, err := client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+		{Id: "latency", Type: "histogram", Histogram: h},
+		{Id: "latency", Type: "histogram", Histogram: h},
+	}})
+	require.NoError(t, err)
+
+	got, err := client.GetMetric(ctx, &pb.GetMetricRequest{Id: "latency", Type: "histogram"})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{2, 4, 0}, got.GetMetric().GetHistogram().GetCounts())
+	assert.Equal(t, 3.0, got.GetMetric().GetHistogram().GetSum())
+
+	_, err = client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+		{Id: "latency", Type: "histogram", Histogram: &pb.Histogram{Bounds: []float64{1}, Counts: []uint64{1}}},
+	}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestMetricsServer_Summary(t *testing.T) {
+	client := newTestClient(t, storage.TestMetricStorage(), "")
+	ctx := context.Background()
+
+	s := &pb.Summary{Quantiles: []*pb.Summary_Quantile{{Quantile: 0.5, Value: 0.2}, {Quantile: 0.9, Value: 0.7}}, Count: 10, Sum: 3}
+	_, err := client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+		{Id: "rpc.duration", Type: "summary", Summary: s},
+	}})
+	require.NoError(t, err)
+
+	got, err := client.GetMetric(ctx, &pb.GetMetricRequest{Id: "rpc.duration", Type: "summary"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(10), got.GetMetric().GetSummary().GetCount())
+	assert.Equal(t, 0.7, got.GetMetric().GetSummary().GetQuantiles()[1].GetValue())
+
+	_, err = client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+		{Id: "rpc.duration", Type: "summary", Summary: &pb.Summary{Quantiles: []*pb.Summary_Quantile{{Quantile: 2}}}},
+	}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,64 @@
+package grpcapi
+
+import (
+	"context"
+
+	pb "github.com/SerjZimmer/devops/internal/proto"
+	"github.com/SerjZimmer/devops/internal/subnet"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// realIPMetadataKey - ключ метаданных, в котором доверенный прокси передает адрес клиента, аналог заголовка X-Real-IP.
+const realIPMetadataKey = "x-real-ip"
+
+// writeMethods - методы сервиса, записывающие метрики.
+var writeMethods = map[string]bool{
+	pb.Metrics_UpdateMetrics_FullMethodName: true,
+	pb.Metrics_PushMetrics_FullMethodName:   true,
+}
+
+// TrustedSubnetUnaryInterceptor повторяет TrustedSubnetMiddleware HTTP API для унарных вызовов:
+// вызовы записи (и чтения при reads) с адресом вне доверенной подсети trusted отклоняются с кодом PermissionDenied.
+// Если подсеть не задана, вызовы не проверяются.
+func TrustedSubnetUnaryInterceptor(trusted *subnet.Trusted, reads bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := checkSubnet(ctx, trusted, reads, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// TrustedSubnetStreamInterceptor повторяет TrustedSubnetMiddleware HTTP API для потоковых вызовов.
+func TrustedSubnetStreamInterceptor(trusted *subnet.Trusted, reads bool) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := checkSubnet(ss.Context(), trusted, reads, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// checkSubnet проверяет, что клиент входит в доверенную подсеть, если метод подлежит проверке.
+// Адрес из метаданных x-real-ip учитывается только для соединений от доверенных прокси.
+func checkSubnet(ctx context.Context, trusted *subnet.Trusted, reads bool, method string) error {
+	if trusted == nil || (!reads && !writeMethods[method]) {
+		return nil
+	}
+	var remoteAddr, realIP string
+	if p, ok := peer.FromContext(ctx); ok {
+		remoteAddr = p.Addr.String()
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(realIPMetadataKey); len(values) > 0 {
+		realIP = values[0]
+	}
+	if !trusted.Allows(remoteAddr, realIP) {
+		return status.Error(codes.PermissionDenied, "адрес не входит в доверенную подсеть")
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+// Package hash реализует подпись данных HMAC-SHA256, которой агент и сервер подтверждают
+// целостность и подлинность запросов и ответов.
+package hash
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Header - HTTP-заголовок (и ключ метаданных gRPC) с подписью тела запроса или ответа.
+const Header = "HashSHA256"
+
+// Sign возвращает подпись HMAC-SHA256 данных data на ключе key в шестнадцатеричном виде.
+func Sign(key string, data []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify проверяет подпись sign данных data на ключе key.
+// Сравнение выполняется за постоянное время, чтобы не раскрывать подпись через время ответа.
+func Verify(key string, data []byte, sign string) bool {
+	got, err := hex.DecodeString(sign)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(data)
+	return hmac.Equal(got, mac.Sum(nil))
+}
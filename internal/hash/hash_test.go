@@ -0,0 +1,37 @@
+package hash
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSign(t *testing.T) {
+	// Тестовый вектор RFC 4231, случай 2.
+	assert.Equal(t, "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843",
+		Sign("Jefe", []byte("what do ya want for nothing?")))
+}
+
+func TestVerify(t *testing.T) {
+	data := []byte(`{"id":"PollCount","type":"counter","delta":1}`)
+	sign := Sign("secret", data)
+
+	tests := []struct {
+		name string
+		key  string
+		data []byte
+		sign string
+		want bool
+	}{
+		{name: "valid", key: "secret", data: data, sign: sign, want: true},
+		{name: "wrong key", key: "other", data: data, sign: sign, want: false},
+		{name: "modified data", key: "secret", data: append([]byte("x"), data...), sign: sign, want: false},
+		{name: "not hex", key: "secret", data: data, sign: "zz", want: false},
+		{name: "empty", key: "secret", data: data, sign: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Verify(tt.key, tt.data, tt.sign))
+		})
+	}
+}
@@ -0,0 +1,10 @@
+package proto
+
+import protobuf "google.golang.org/protobuf/proto"
+
+// MarshalSigned сериализует сообщение для подписи HMAC-SHA256. Детерминированная сериализация дает
+// одинаковые байты на клиенте и сервере при одинаковом содержимом сообщения, поэтому ею пользуются
+// и клиенты при подписи запросов, и сервер при проверке подписи.
+func MarshalSigned(m protobuf.Message) ([]byte, error) {
+	return protobuf.MarshalOptions{Deterministic: true}.Marshal(m)
+}
@@ -0,0 +1,84 @@
+// Package selfmetrics хранит собственные операционные метрики сервера:
+// число обработанных запросов, ошибок, принятых обновлений и т.п.
+// Они отдаются вместе с метриками хранилища, но в отдельном пространстве имён Namespace.
+package selfmetrics
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/SerjZimmer/devops/internal/storage"
+)
+
+// Namespace - префикс имён собственных метрик сервера при экспорте.
+const Namespace = "devops_server_"
+
+// Registry представляет собой набор собственных метрик сервера.
+type Registry struct {
+	mu       sync.RWMutex
+	counters map[string]int64
+	gauges   map[string]float64
+	start    time.Time
+}
+
+// Default - общий набор собственных метрик сервера, в который пишут все его компоненты.
+var Default = New()
+
+// New создает новый пустой набор собственных метрик.
+func New() *Registry {
+	return &Registry{
+		counters: make(map[string]int64),
+		gauges:   make(map[string]float64),
+		start:    time.Now(),
+	}
+}
+
+// Add увеличивает счетчик name на delta.
+func (r *Registry) Add(name string, delta int64) {
+	r.mu.Lock()
+	r.counters[name] += delta
+	r.mu.Unlock()
+}
+
+// Set устанавливает значение gauge name.
+func (r *Registry) Set(name string, value float64) {
+	r.mu.Lock()
+	r.gauges[name] = value
+	r.mu.Unlock()
+}
+
+// Metrics возвращает снимок собственных метрик, упорядоченный по имени, вместе с метриками среды выполнения.
+// Имена возвращаются без префикса Namespace.
+func (r *Registry) Metrics() []storage.Metrics {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	runtimeGauges := map[string]float64{
+		"uptime_seconds":          time.Since(r.start).Seconds(),
+		"goroutines":              float64(runtime.NumGoroutine()),
+		"memory_heap_alloc_bytes": float64(mem.HeapAlloc),
+	}
+
+	r.mu.RLock()
+	metrics := make([]storage.Metrics, 0, len(r.counters)+len(r.gauges)+len(runtimeGauges))
+	for name, delta := range r.counters {
+		delta := delta
+		metrics = append(metrics, storage.Metrics{ID: name, MType: "counter", Delta: &delta})
+	}
+	for name, value := range r.gauges {
+		if _, exists := runtimeGauges[name]; !exists {
+			runtimeGauges[name] = value
+		}
+	}
+	r.mu.RUnlock()
+
+	for name, value := range runtimeGauges {
+		value := value
+		metrics = append(metrics, storage.Metrics{ID: name, MType: "gauge", Value: &value})
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].ID < metrics[j].ID
+	})
+	return metrics
+}
@@ -0,0 +1,25 @@
+package selfmetrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	r := New()
+	r.Add("requests_total", 2)
+	r.Add("requests_total", 3)
+	r.Set("queue_length", 4)
+
+	values := map[string]float64{}
+	for _, m := range r.Metrics() {
+		values[m.ID] = m.Float64()
+	}
+
+	assert.Equal(t, float64(5), values["requests_total"])
+	assert.Equal(t, float64(4), values["queue_length"])
+	require.Contains(t, values, "uptime_seconds")
+	assert.Greater(t, values["goroutines"], float64(0))
+}
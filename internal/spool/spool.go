@@ -0,0 +1,247 @@
+// Package spool реализует ограниченную очередь на диске для данных, которые не удалось отправить.
+//
+// Каждая запись хранится в отдельном файле каталога очереди, поэтому очередь переживает перезапуск
+// процесса. Имена файлов начинаются со времени добавления записи, что задает порядок очереди и
+// позволяет отбрасывать устаревшие записи без чтения файлов. При превышении размера очереди
+// отбрасываются самые старые записи.
+//
+// Запись удаляется только после того, как получатель подтвердит её отправку, поэтому записи,
+// выданные на отправку перед аварийным завершением процесса, будут отправлены повторно.
+package spool
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ext - расширение файлов записей очереди.
+const ext = ".spool"
+
+// ErrTooLarge возвращается при добавлении записи, размер которой превышает размер очереди.
+var ErrTooLarge = errors.New("spool entry is larger than the spool")
+
+// Queue - очередь записей в каталоге на диске. Методы Queue безопасны для одновременного вызова.
+type Queue struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+	now      func() time.Time
+
+	mu     sync.Mutex
+	size   int64
+	seq    uint64
+	leased map[string]bool
+	drain  sync.Mutex
+}
+
+// entry - файл записи очереди.
+type entry struct {
+	name    string
+	created time.Time
+	size    int64
+}
+
+// New открывает очередь в каталоге dir, создавая его при необходимости. Записи, оставшиеся
+// от предыдущего запуска, сохраняются. maxBytes ограничивает суммарный размер записей,
+// maxAge - время хранения записи; нулевое значение снимает ограничение.
+func New(dir string, maxBytes int64, maxAge time.Duration) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	q := &Queue{dir: dir, maxBytes: maxBytes, maxAge: maxAge, now: time.Now, leased: make(map[string]bool)}
+	entries, err := q.entries()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		q.size += e.size
+	}
+	return q, nil
+}
+
+// Push добавляет запись data в конец очереди. Если очередь переполнена, самые старые записи отбрасываются.
+// Возвращает число отброшенных записей.
+func (q *Queue) Push(data []byte) (int, error) {
+	size := int64(len(data))
+	if q.maxBytes > 0 && size > q.maxBytes {
+		return 0, ErrTooLarge
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	dropped := 0
+	if q.maxBytes > 0 && q.size+size > q.maxBytes {
+		entries, err := q.entries()
+		if err != nil {
+			return 0, err
+		}
+		for _, e := range entries {
+			if q.size+size <= q.maxBytes {
+				break
+			}
+			if err := q.remove(e); err != nil {
+				return dropped, err
+			}
+			dropped++
+		}
+	}
+
+	q.seq++
+	name := fmt.Sprintf("%020d-%010d%s", q.now().UnixNano(), q.seq, ext)
+	tmp, err := os.CreateTemp(q.dir, name+".tmp-*")
+	if err != nil {
+		return dropped, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return dropped, err
+	}
+	if err := tmp.Close(); err != nil {
+		return dropped, err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(q.dir, name)); err != nil {
+		return dropped, err
+	}
+	q.size += size
+	return dropped, nil
+}
+
+// Drain передает функции send записи, начиная с самой старой, пропуская выданные ранее и еще не подтвержденные.
+// Вместе с записью передается функция done, которую получатель вызывает после попытки отправки: done(true)
+// удаляет запись, done(false) возвращает её в очередь на прежнее место с исходным временем добавления.
+// Устаревшие записи удаляются без отправки. Если send возвращает false, например когда получателю некуда
+// поставить запись, разбор прекращается, а запись возвращается в очередь. Одновременно выполняется только
+// один разбор: если очередь уже разбирается, Drain сразу возвращает 0. Возвращает число выданных записей.
+func (q *Queue) Drain(send func(data []byte, done func(remove bool) error) bool) (int, error) {
+	if !q.drain.TryLock() {
+		return 0, nil
+	}
+	defer q.drain.Unlock()
+
+	q.mu.Lock()
+	entries, err := q.entries()
+	q.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, e := range entries {
+		q.mu.Lock()
+		if q.leased[e.name] {
+			q.mu.Unlock()
+			continue
+		}
+		if q.maxAge > 0 && q.now().Sub(e.created) > q.maxAge {
+			err := q.remove(e)
+			q.mu.Unlock()
+			if err != nil {
+				return sent, err
+			}
+			continue
+		}
+		q.leased[e.name] = true
+		q.mu.Unlock()
+
+		done := q.doneFunc(e)
+		data, err := os.ReadFile(filepath.Join(q.dir, e.name))
+		if errors.Is(err, os.ErrNotExist) {
+			// Запись отброшена при переполнении очереди во время разбора
+			done(false)
+			continue
+		}
+		if err != nil {
+			done(false)
+			return sent, err
+		}
+		if !send(data, done) {
+			done(false)
+			return sent, nil
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// doneFunc возвращает функцию подтверждения отправки выданной записи e. Повторные вызовы ничего не делают.
+func (q *Queue) doneFunc(e entry) func(remove bool) error {
+	var once sync.Once
+	return func(remove bool) error {
+		var err error
+		once.Do(func() {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			delete(q.leased, e.name)
+			if remove {
+				err = q.remove(e)
+			}
+		})
+		return err
+	}
+}
+
+// Len возвращает число записей в очереди.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entries, err := q.entries()
+	if err != nil {
+		return 0
+	}
+	return len(entries)
+}
+
+// Size возвращает суммарный размер записей в очереди в байтах.
+func (q *Queue) Size() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size
+}
+
+// entries возвращает записи очереди от самой старой к самой новой. Вызывается под блокировкой mu.
+func (q *Queue) entries() ([]entry, error) {
+	files, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, err
+	}
+	var entries []entry
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ext) {
+			continue
+		}
+		stamp, _, _ := strings.Cut(f.Name(), "-")
+		nanos, err := strconv.ParseInt(stamp, 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry{name: f.Name(), created: time.Unix(0, nanos), size: info.Size()})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+	return entries, nil
+}
+
+// remove удаляет запись из очереди. Вызывается под блокировкой mu.
+func (q *Queue) remove(e entry) error {
+	err := os.Remove(filepath.Join(q.dir, e.name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	q.size -= e.size
+	return nil
+}
@@ -0,0 +1,161 @@
+package spool
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// drainAll возвращает все записи очереди, удаляя их.
+func drainAll(t *testing.T, q *Queue) []string {
+	var got []string
+	_, err := q.Drain(func(data []byte, done func(bool) error) bool {
+		got = append(got, string(data))
+		assert.NoError(t, done(true))
+		return true
+	})
+	require.NoError(t, err)
+	return got
+}
+
+func TestQueue_PushDrain(t *testing.T) {
+	dir := t.TempDir()
+	q, err := New(dir, 0, 0)
+	require.NoError(t, err)
+
+	for _, s := range []string{"first", "second", "third"} {
+		dropped, err := q.Push([]byte(s))
+		require.NoError(t, err)
+		assert.Zero(t, dropped)
+	}
+	assert.Equal(t, 3, q.Len())
+	assert.Equal(t, int64(len("firstsecondthird")), q.Size())
+
+	// Записи сохраняются после перезапуска
+	q, err = New(dir, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("firstsecondthird")), q.Size())
+
+	// Если получателю некуда поставить запись, разбор прекращается, запись остается в очереди
+	var got []string
+	sent, err := q.Drain(func(data []byte, done func(bool) error) bool {
+		if string(data) == "second" {
+			return false
+		}
+		got = append(got, string(data))
+		assert.NoError(t, done(true))
+		return true
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, []string{"first"}, got)
+
+	assert.Equal(t, []string{"second", "third"}, drainAll(t, q))
+	assert.Zero(t, q.Len())
+	assert.Zero(t, q.Size())
+}
+
+func TestQueue_MaxBytes(t *testing.T) {
+	q, err := New(t.TempDir(), 10, 0)
+	require.NoError(t, err)
+
+	for _, s := range []string{"aaaa", "bbbb"} {
+		_, err := q.Push([]byte(s))
+		require.NoError(t, err)
+	}
+	// Для новой записи отбрасывается самая старая
+	dropped, err := q.Push([]byte("cccc"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, dropped)
+	assert.Equal(t, int64(8), q.Size())
+
+	_, err = q.Push([]byte("too large entry"))
+	assert.ErrorIs(t, err, ErrTooLarge)
+
+	assert.Equal(t, []string{"bbbb", "cccc"}, drainAll(t, q))
+}
+
+func TestQueue_MaxAge(t *testing.T) {
+	q, err := New(t.TempDir(), 0, time.Minute)
+	require.NoError(t, err)
+	now := time.Now()
+	q.now = func() time.Time { return now }
+
+	_, err = q.Push([]byte("old"))
+	require.NoError(t, err)
+	now = now.Add(50 * time.Second)
+	_, err = q.Push([]byte("new"))
+	require.NoError(t, err)
+
+	// Устаревшая запись удаляется без отправки
+	now = now.Add(30 * time.Second)
+	assert.Equal(t, []string{"new"}, drainAll(t, q))
+	assert.Zero(t, q.Size())
+}
+
+func TestQueue_IgnoresForeignFiles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("x"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bad-name.spool"), []byte("x"), 0644))
+
+	q, err := New(dir, 0, 0)
+	require.NoError(t, err)
+	assert.Zero(t, q.Len())
+	assert.Empty(t, drainAll(t, q))
+}
+
+func TestQueue_SingleDrain(t *testing.T) {
+	q, err := New(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+	_, err = q.Push([]byte("entry"))
+	require.NoError(t, err)
+
+	// Пока очередь разбирается, повторный разбор ничего не делает
+	sent, err := q.Drain(func(_ []byte, done func(bool) error) bool {
+		sent, err := q.Drain(func([]byte, func(bool) error) bool { return true })
+		assert.Zero(t, sent)
+		assert.NoError(t, err)
+		return done(true) == nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+}
+
+func TestQueue_Done(t *testing.T) {
+	dir := t.TempDir()
+	q, err := New(dir, 0, 0)
+	require.NoError(t, err)
+	for _, s := range []string{"first", "second"} {
+		_, err := q.Push([]byte(s))
+		require.NoError(t, err)
+	}
+
+	// Выданные записи остаются на диске до подтверждения и не выдаются повторно
+	dones := make(map[string]func(bool) error)
+	sent, err := q.Drain(func(data []byte, done func(bool) error) bool {
+		dones[string(data)] = done
+		return true
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	assert.Equal(t, 2, q.Len())
+	assert.Empty(t, drainAll(t, q))
+
+	// Записи переживают аварийный перезапуск до подтверждения
+	restarted, err := New(dir, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, restarted.Len())
+
+	// Неотправленная запись возвращается в очередь на прежнее место, отправленная удаляется
+	require.NoError(t, dones["first"](false))
+	require.NoError(t, dones["second"](true))
+	require.NoError(t, dones["second"](false))
+	_, err = q.Push([]byte("third"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first", "third"}, drainAll(t, q))
+	assert.Zero(t, q.Size())
+}
@@ -0,0 +1,69 @@
+package statsd
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/SerjZimmer/devops/internal/storage"
+)
+
+// sample представляет собой одно измерение StatsD, разобранное из строки name:value|type[|@rate][|#tags].
+type sample struct {
+	Name  string
+	Type  string
+	Value float64
+	Rate  float64
+	// Relative означает, что значение gauge задано со знаком и изменяет текущее значение, а не заменяет его.
+	Relative bool
+}
+
+// parseLine разбирает строку протокола StatsD. Поддерживаются счетчики (c), gauge (g) с относительными
+// изменениями +/-, таймеры (ms) и частота выборки @rate. Теги DogStatsD (#tag) пока не сохраняются.
+func parseLine(line string) (sample, error) {
+	name, rest, found := strings.Cut(line, ":")
+	if !found || name == "" {
+		return sample{}, fmt.Errorf("missing metric name in %q", line)
+	}
+	if !storage.ValidID(name) {
+		return sample{}, fmt.Errorf("invalid metric name %q", name)
+	}
+
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 {
+		return sample{}, fmt.Errorf("missing metric type in %q", line)
+	}
+
+	s := sample{Name: name, Type: parts[1], Rate: 1}
+	switch s.Type {
+	case "c", "g", "ms":
+	default:
+		return sample{}, fmt.Errorf("unsupported metric type %q", s.Type)
+	}
+
+	value := parts[0]
+	if s.Type == "g" && (strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-")) {
+		s.Relative = true
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return sample{}, fmt.Errorf("invalid value %q", value)
+	}
+	s.Value = v
+
+	for _, part := range parts[2:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+			rate, err := strconv.ParseFloat(part[1:], 64)
+			if err != nil || !(rate > 0 && rate <= 1) {
+				return sample{}, fmt.Errorf("invalid sample rate %q", part)
+			}
+			s.Rate = rate
+		case strings.HasPrefix(part, "#"):
+		default:
+			return sample{}, fmt.Errorf("unexpected section %q", part)
+		}
+	}
+	return s, nil
+}
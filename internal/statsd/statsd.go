@@ -0,0 +1,337 @@
+// Package statsd реализует прием метрик по протоколу StatsD через UDP и TCP.
+// Измерения агрегируются в памяти и раз в интервал сброса записываются в хранилище одним пакетом.
+// Если задана доверенная подсеть, датаграммы и соединения с адресов вне нее отбрасываются.
+package statsd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SerjZimmer/devops/internal/selfmetrics"
+	"github.com/SerjZimmer/devops/internal/storage"
+	"github.com/SerjZimmer/devops/internal/subnet"
+)
+
+// maxPacketSize - максимальный размер принимаемой UDP-датаграммы.
+const maxPacketSize = 65535
+
+// defaultFlushInterval - интервал сброса, используемый при неположительном значении в конфигурации.
+const defaultFlushInterval = 10 * time.Second
+
+// metricsStorage представляет хранилище, в которое записываются агрегированные метрики.
+type metricsStorage interface {
+	GetMetric(m storage.Metrics) (storage.Metrics, error)
+	UpdateMetricsValue(m []storage.Metrics) error
+}
+
+// timer представляет собой агрегат значений таймера за интервал сброса.
+type timer struct {
+	count   float64
+	samples int
+	sum     float64
+	min     float64
+	max     float64
+}
+
+// Server принимает метрики StatsD и периодически записывает их в хранилище.
+//
+// Счетчики за интервал суммируются с учетом частоты выборки и записываются как counter.
+// Gauge хранят последнее значение между сбросами, чтобы к нему можно было применять +/- изменения;
+// записываются только gauge, изменившиеся за интервал. Таймер name за интервал превращается
+// в counter name.count и gauge name.sum, name.min, name.max, name.mean.
+type Server struct {
+	addr          string
+	flushInterval time.Duration
+	stor          metricsStorage
+	trusted       *subnet.Trusted
+	self          *selfmetrics.Registry
+
+	mu       sync.Mutex
+	counters map[string]float64
+	gauges   map[string]float64
+	dirty    map[string]bool
+	timers   map[string]*timer
+
+	udp  net.PacketConn
+	tcp  net.Listener
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New создает новый экземпляр Server, слушающий addr и сбрасывающий метрики в stor раз в flushInterval.
+// Метрики принимаются только с адресов из доверенной подсети trusted; nil разрешает любые адреса.
+func New(addr string, flushInterval time.Duration, stor metricsStorage, trusted *subnet.Trusted) *Server {
+	if flushInterval <= 0 {
+		flushInterval = defaultFlushInterval
+	}
+	return &Server{
+		addr:          addr,
+		flushInterval: flushInterval,
+		stor:          stor,
+		trusted:       trusted,
+		self:          selfmetrics.Default,
+		counters:      make(map[string]float64),
+		gauges:        make(map[string]float64),
+		dirty:         make(map[string]bool),
+		timers:        make(map[string]*timer),
+		done:          make(chan struct{}),
+	}
+}
+
+// Start начинает прием метрик по UDP и TCP на адресе сервера и запускает периодический сброс.
+func (s *Server) Start() error {
+	udp, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return err
+	}
+	tcp, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		udp.Close()
+		return err
+	}
+	s.udp, s.tcp = udp, tcp
+	fmt.Printf("StatsD запущен на %v\n", s.addr)
+
+	s.wg.Add(3)
+	go s.serveUDP()
+	go s.serveTCP()
+	go s.flushLoop()
+	return nil
+}
+
+// Shutdown останавливает прием метрик и сбрасывает накопленные значения в хранилище.
+func (s *Server) Shutdown() {
+	close(s.done)
+	if s.udp != nil {
+		s.udp.Close()
+	}
+	if s.tcp != nil {
+		s.tcp.Close()
+	}
+	s.wg.Wait()
+
+	if err := s.Flush(); err != nil {
+		fmt.Println("Ошибка сброса метрик StatsD:", err)
+	}
+}
+
+// serveUDP принимает датаграммы; каждая может содержать несколько строк, разделенных переводом строки.
+func (s *Server) serveUDP() {
+	defer s.wg.Done()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			fmt.Println("Ошибка чтения StatsD:", err)
+			continue
+		}
+		if !s.trusted.AllowsAddr(addr) {
+			s.self.Add("statsd_rejected_packets_total", 1)
+			continue
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			s.handleLine(line)
+		}
+	}
+}
+
+// serveTCP принимает соединения, по которым строки StatsD передаются потоком.
+func (s *Server) serveTCP() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			fmt.Println("Ошибка подключения StatsD:", err)
+			continue
+		}
+		if !s.trusted.AllowsAddr(conn.RemoteAddr()) {
+			s.self.Add("statsd_rejected_packets_total", 1)
+			conn.Close()
+			continue
+		}
+		s.wg.Add(1)
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn читает строки StatsD из TCP-соединения до его закрытия или остановки сервера.
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer conn.Close()
+
+	closed := make(chan struct{})
+	defer close(closed)
+	go func() {
+		select {
+		case <-s.done:
+			conn.Close()
+		case <-closed:
+		}
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		s.handleLine(scanner.Text())
+	}
+}
+
+// flushLoop сбрасывает метрики в хранилище раз в интервал сброса.
+func (s *Server) flushLoop() {
+	defer s.wg.Done()
+	t := time.NewTicker(s.flushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-t.C:
+			if err := s.Flush(); err != nil {
+				fmt.Println("Ошибка сброса метрик StatsD:", err)
+			}
+		}
+	}
+}
+
+// handleLine разбирает строку StatsD и учитывает измерение в агрегатах текущего интервала.
+func (s *Server) handleLine(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	s.self.Add("statsd_lines_total", 1)
+
+	sample, err := parseLine(line)
+	if err != nil {
+		s.self.Add("statsd_parse_errors_total", 1)
+		return
+	}
+	s.add(sample)
+}
+
+// add учитывает измерение в агрегатах текущего интервала.
+func (s *Server) add(sample sample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch sample.Type {
+	case "c":
+		s.counters[sample.Name] += sample.Value / sample.Rate
+	case "g":
+		if sample.Relative {
+			s.gauges[sample.Name] = s.gaugeValue(sample.Name) + sample.Value
+		} else {
+			s.gauges[sample.Name] = sample.Value
+		}
+		s.dirty[sample.Name] = true
+	case "ms":
+		t, exists := s.timers[sample.Name]
+		if !exists {
+			t = &timer{min: sample.Value, max: sample.Value}
+			s.timers[sample.Name] = t
+		}
+		t.count += 1 / sample.Rate
+		t.samples++
+		t.sum += sample.Value
+		t.min = math.Min(t.min, sample.Value)
+		t.max = math.Max(t.max, sample.Value)
+	}
+}
+
+// gaugeValue возвращает текущее значение gauge: из памяти сервера или, если его там нет, из хранилища.
+func (s *Server) gaugeValue(name string) float64 {
+	if value, exists := s.gauges[name]; exists {
+		return value
+	}
+	stored, err := s.stor.GetMetric(storage.Metrics{ID: name, MType: "gauge"})
+	if err != nil || stored.Value == nil {
+		return 0
+	}
+	return *stored.Value
+}
+
+// Flush записывает накопленные за интервал метрики в хранилище одним пакетом.
+// Дробный остаток счетчиков, возникающий из-за частоты выборки, переносится в следующий интервал.
+// Если запись не удалась, агрегаты интервала возвращаются в текущие и уходят со следующим сбросом.
+func (s *Server) Flush() error {
+	s.mu.Lock()
+	counters, dirty, timers := s.counters, s.dirty, s.timers
+	s.counters = make(map[string]float64)
+	s.dirty = make(map[string]bool)
+	s.timers = make(map[string]*timer)
+
+	var metrics []storage.Metrics
+	for name, value := range counters {
+		delta := int64(value)
+		if delta != 0 {
+			metrics = append(metrics, storage.CounterMetric(name, delta))
+		}
+		if rest := value - float64(delta); rest != 0 {
+			s.counters[name] = rest
+		}
+	}
+	for name := range dirty {
+		metrics = append(metrics, storage.GaugeMetric(name, s.gauges[name]))
+	}
+	for name, t := range timers {
+		metrics = append(metrics,
+			storage.CounterMetric(name+".count", int64(math.Round(t.count))),
+			storage.GaugeMetric(name+".sum", t.sum),
+			storage.GaugeMetric(name+".min", t.min),
+			storage.GaugeMetric(name+".max", t.max),
+			storage.GaugeMetric(name+".mean", t.sum/float64(t.samples)),
+		)
+	}
+	s.mu.Unlock()
+
+	if len(metrics) == 0 {
+		return nil
+	}
+	err := s.stor.UpdateMetricsValue(metrics)
+	if err != nil {
+		s.requeue(counters, dirty, timers)
+		s.self.Add("statsd_flush_errors_total", 1)
+		return err
+	}
+	s.self.Add("statsd_flushed_metrics_total", int64(len(metrics)))
+	return nil
+}
+
+// requeue возвращает агрегаты интервала, который не удалось записать, в агрегаты текущего интервала.
+// Остатки счетчиков уже перенесены в текущий интервал, поэтому возвращаются только их целые части.
+func (s *Server) requeue(counters map[string]float64, dirty map[string]bool, timers map[string]*timer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, value := range counters {
+		if delta := int64(value); delta != 0 {
+			s.counters[name] += float64(delta)
+		}
+	}
+	for name := range dirty {
+		s.dirty[name] = true
+	}
+	for name, t := range timers {
+		current, exists := s.timers[name]
+		if !exists {
+			s.timers[name] = t
+			continue
+		}
+		current.count += t.count
+		current.samples += t.samples
+		current.sum += t.sum
+		current.min = math.Min(current.min, t.min)
+		current.max = math.Max(current.max, t.max)
+	}
+}
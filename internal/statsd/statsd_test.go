@@ -0,0 +1,176 @@
+package statsd
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/SerjZimmer/devops/internal/selfmetrics"
+	"github.com/SerjZimmer/devops/internal/storage"
+	"github.com/SerjZimmer/devops/internal/subnet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	testCases := []struct {
+		line     string
+		expected sample
+	}{
+		{"hits:1|c", sample{Name: "hits", Type: "c", Value: 1, Rate: 1}},
+		{"hits:2|c|@0.5", sample{Name: "hits", Type: "c", Value: 2, Rate: 0.5}},
+		{"temp:21.5|g", sample{Name: "temp", Type: "g", Value: 21.5, Rate: 1}},
+		{"temp:-3|g", sample{Name: "temp", Type: "g", Value: -3, Rate: 1, Relative: true}},
+		{"temp:+3|g", sample{Name: "temp", Type: "g", Value: 3, Rate: 1, Relative: true}},
+		{"latency:320|ms|#env:prod", sample{Name: "latency", Type: "ms", Value: 320, Rate: 1}},
+	}
+	for _, tc := range testCases {
+		s, err := parseLine(tc.line)
+		require.NoError(t, err, tc.line)
+		assert.Equal(t, tc.expected, s, tc.line)
+	}
+
+	for _, line := range []string{"hits", ":1|c", "hits:1", "hits:x|c", "hits:1|s", "hits:1|c|@2", "hits:1|c|x",
+		"hits:NaN|c", "temp:Inf|g", "temp:+Inf|g", "latency:-Inf|ms", "hits:1|c|@NaN", "hits:1|c|@0", "hits:1|c|@-0.5",
+		`HeapAlloc{agent_id="a1",host="web-1"}:5|g`, "hits,total:1|c"} {
+		_, err := parseLine(line)
+		assert.Error(t, err, line)
+	}
+}
+
+func TestServer_Flush(t *testing.T) {
+	stor := storage.NewMemoryStorage()
+	stor.Gauges["temp"] = 20
+	s := New("", time.Second, stor, nil)
+
+	for _, line := range []string{
+		"hits:1|c",
+		"hits:1|c|@0.5",
+		"hits:1|c|@0.4",
+		"temp:+1.5|g",
+		"latency:100|ms",
+		"latency:300|ms|@0.5",
+		"broken",
+	} {
+		s.handleLine(line)
+	}
+	require.NoError(t, s.Flush())
+
+	// 1 + 2 + 2.5 = 5.5: в хранилище попадает 5, остаток 0.5 переносится
+	assert.Equal(t, int64(5), stor.Counters["hits"])
+	assert.Equal(t, 21.5, stor.Gauges["temp"])
+	assert.Equal(t, int64(3), stor.Counters["latency.count"])
+	assert.Equal(t, float64(400), stor.Gauges["latency.sum"])
+	assert.Equal(t, float64(100), stor.Gauges["latency.min"])
+	assert.Equal(t, float64(300), stor.Gauges["latency.max"])
+	assert.Equal(t, float64(200), stor.Gauges["latency.mean"])
+
+	// Неизменившиеся gauge и таймеры прошлого интервала повторно не записываются
+	stor.Gauges["temp"] = 0
+	s.handleLine("hits:0.5|c")
+	require.NoError(t, s.Flush())
+	assert.Equal(t, int64(6), stor.Counters["hits"])
+	assert.Equal(t, float64(0), stor.Gauges["temp"])
+	assert.Equal(t, int64(3), stor.Counters["latency.count"])
+}
+
+// failingStorage - хранилище, запись в которое не удается, пока fail равен true.
+type failingStorage struct {
+	*storage.MemoryStorage
+	fail bool
+}
+
+func (f *failingStorage) UpdateMetricsValue(m []storage.Metrics) error {
+	if f.fail {
+		return errors.New("storage unavailable")
+	}
+	return f.MemoryStorage.UpdateMetricsValue(m)
+}
+
+func TestServer_FlushError(t *testing.T) {
+	stor := &failingStorage{MemoryStorage: storage.NewMemoryStorage(), fail: true}
+	s := New("", time.Second, stor, nil)
+
+	for _, line := range []string{"hits:3|c|@0.4", "temp:21.5|g", "latency:100|ms"} {
+		s.handleLine(line)
+	}
+	require.Error(t, s.Flush())
+
+	// Агрегаты интервала, который не удалось записать, объединяются с новыми и уходят со следующим сбросом
+	for _, line := range []string{"hits:1|c", "latency:300|ms"} {
+		s.handleLine(line)
+	}
+	stor.fail = false
+	require.NoError(t, s.Flush())
+
+	// 3 / 0.4 + 1 = 8.5: записывается 8, остаток 0.5 переносится
+	assert.Equal(t, int64(8), stor.Counters["hits"])
+	assert.Equal(t, 21.5, stor.Gauges["temp"])
+	assert.Equal(t, int64(2), stor.Counters["latency.count"])
+	assert.Equal(t, float64(100), stor.Gauges["latency.min"])
+	assert.Equal(t, float64(300), stor.Gauges["latency.max"])
+	assert.Equal(t, float64(200), stor.Gauges["latency.mean"])
+}
+
+func TestServer_Listen(t *testing.T) {
+	stor := storage.NewMemoryStorage()
+	s := New("127.0.0.1:0", time.Hour, stor, nil)
+	require.NoError(t, s.Start())
+
+	udp, err := net.Dial("udp", s.udp.LocalAddr().String())
+	require.NoError(t, err)
+	_, err = udp.Write([]byte("udp.hits:2|c\nudp.temp:5|g"))
+	require.NoError(t, err)
+	udp.Close()
+
+	tcp, err := net.Dial("tcp", s.tcp.Addr().String())
+	require.NoError(t, err)
+	_, err = tcp.Write([]byte("tcp.hits:3|c\n"))
+	require.NoError(t, err)
+	tcp.Close()
+
+	assert.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.counters["udp.hits"] == 2 && s.counters["tcp.hits"] == 3
+	}, time.Second, 10*time.Millisecond)
+
+	// При остановке накопленные метрики сбрасываются в хранилище
+	s.Shutdown()
+	assert.Equal(t, int64(2), stor.Counters["udp.hits"])
+	assert.Equal(t, int64(3), stor.Counters["tcp.hits"])
+	assert.Equal(t, float64(5), stor.Gauges["udp.temp"])
+}
+
+func TestServer_TrustedSubnet(t *testing.T) {
+	trusted, err := subnet.Parse("192.168.1.0/24", nil)
+	require.NoError(t, err)
+	stor := storage.NewMemoryStorage()
+	s := New("127.0.0.1:0", time.Hour, stor, trusted)
+	s.self = selfmetrics.New()
+	require.NoError(t, s.Start())
+
+	// Датаграммы и соединения с адресов вне подсети отбрасываются
+	udp, err := net.Dial("udp", s.udp.LocalAddr().String())
+	require.NoError(t, err)
+	_, err = udp.Write([]byte("udp.hits:2|c"))
+	require.NoError(t, err)
+	udp.Close()
+
+	tcp, err := net.Dial("tcp", s.tcp.Addr().String())
+	require.NoError(t, err)
+	_, _ = tcp.Write([]byte("tcp.hits:3|c\n"))
+	tcp.Close()
+
+	assert.Eventually(t, func() bool {
+		for _, m := range s.self.Metrics() {
+			if m.ID == "statsd_rejected_packets_total" {
+				return m.Float64() == 2
+			}
+		}
+		return false
+	}, time.Second, 10*time.Millisecond)
+	s.Shutdown()
+	assert.Empty(t, stor.Counters)
+}
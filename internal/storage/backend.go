@@ -0,0 +1,30 @@
+package storage
+
+// Backend описывает бэкенд хранилища метрик.
+// Любая реализация удовлетворяет интерфейсу хранилища, которым пользуются обработчики API.
+type Backend interface {
+	UpdateMetricValue(m Metrics) error
+	UpdateMetricsValue(m []Metrics) error
+	GetMetric(m Metrics) (Metrics, error)
+	GetMetricByName(m Metrics) (float64, error)
+	ListMetrics() ([]Metrics, error)
+	SortMetricByName() []string
+	GetAllMetrics() string
+	PingDB() error
+	Shutdown()
+	// ForTenant возвращает бэкенд того же вида с отдельным пространством метрик арендатора tenant.
+	ForTenant(tenant string) Backend
+}
+
+// NewBackend создает бэкенд хранилища, выбранный на основе конфигурации:
+// PostgreSQL при заданном DatabaseDSN, файл при заданном FileStoragePath, иначе память.
+func NewBackend(c *Config) Backend {
+	switch {
+	case c.DatabaseDSN != "":
+		return NewPostgresStorage(c)
+	case c.FileStoragePath != "":
+		return NewFileStorage(c)
+	default:
+		return NewMemoryStorage()
+	}
+}
@@ -8,11 +8,16 @@ import (
 
 // Config представляет собой структуру конфигурации для хранилища метрик.
 type Config struct {
-	RestoreFlag     bool
-	MaxConnections  int
-	DatabaseDSN     string
-	StoreInterval   int
-	FileStoragePath string
+	RestoreFlag      bool
+	MaxConnections   int
+	DatabaseDSN      string
+	StoreInterval    int
+	FileStoragePath  string
+	SnapshotKeep     int
+	HistoryRetention int
+	MinuteRetention  int
+	HourRetention    int
+	CompactInterval  int
 }
 
 // NewConfig создает новый экземпляр конфигурации хранилища метрик.
@@ -20,11 +25,16 @@ func NewConfig(needStoreInterval bool) *Config {
 
 	config := &Config{
 
-		MaxConnections:  getEnvAsInt("MAX_CONNECTIONS", 100),
-		DatabaseDSN:     getEnv("DATABASE_DSN", ""),
-		RestoreFlag:     getEnvAsBool("RESTORE", true),
-		StoreInterval:   getEnvAsInt("STORE_INTERVAL", 300),
-		FileStoragePath: getEnv("FILE_STORAGE_PATH", "/tmp/metrics-db.json"),
+		MaxConnections:   getEnvAsInt("MAX_CONNECTIONS", 100),
+		DatabaseDSN:      getEnv("DATABASE_DSN", ""),
+		RestoreFlag:      getEnvAsBool("RESTORE", true),
+		StoreInterval:    getEnvAsInt("STORE_INTERVAL", 300),
+		FileStoragePath:  getEnv("FILE_STORAGE_PATH", "/tmp/metrics-db.json"),
+		SnapshotKeep:     getEnvAsInt("SNAPSHOT_KEEP", 3),
+		HistoryRetention: getEnvAsInt("HISTORY_RETENTION", 86400),
+		MinuteRetention:  getEnvAsInt("HISTORY_RETENTION_1M", 604800),
+		HourRetention:    getEnvAsInt("HISTORY_RETENTION_1H", 7776000),
+		CompactInterval:  getEnvAsInt("COMPACT_INTERVAL", 60),
 	}
 	flag.StringVar(&config.FileStoragePath, "f", getEnv("FILE_STORAGE_PATH", "/tmp/metrics-db.json"), "Path to the file for storing metrics")
 	flag.IntVar(&config.MaxConnections, "c", getEnvAsInt("MAX_CONNECTIONS", 100), "Maximum number of concurrent connections")
@@ -33,6 +43,11 @@ func NewConfig(needStoreInterval bool) *Config {
 		flag.BoolVar(&config.RestoreFlag, "r", getEnvAsBool("RESTORE", true), "Whether to restore previously saved metrics on server start")
 	}
 	flag.IntVar(&config.StoreInterval, "i", getEnvAsInt("STORE_INTERVAL", 300), "Interval in seconds for storing server metrics on disk")
+	flag.IntVar(&config.SnapshotKeep, "snapshot-keep", getEnvAsInt("SNAPSHOT_KEEP", 3), "Number of latest metric snapshots kept on disk for restore fallback")
+	flag.IntVar(&config.HistoryRetention, "history-retention", getEnvAsInt("HISTORY_RETENTION", 86400), "Retention in seconds for metric history, 0 disables history")
+	flag.IntVar(&config.MinuteRetention, "history-retention-1m", getEnvAsInt("HISTORY_RETENTION_1M", 604800), "Retention in seconds for 1m metric aggregates, 0 disables the tier")
+	flag.IntVar(&config.HourRetention, "history-retention-1h", getEnvAsInt("HISTORY_RETENTION_1H", 7776000), "Retention in seconds for 1h metric aggregates, 0 disables the tier")
+	flag.IntVar(&config.CompactInterval, "compact-interval", getEnvAsInt("COMPACT_INTERVAL", 60), "Interval in seconds for compacting metric history, 0 disables compaction")
 	return config
 }
 
@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileStorage хранит метрики в памяти и сохраняет их снимки в файл FileStoragePath.
+// Каждое обновление сначала дописывается в журнал предзаписи рядом со снимком,
+// а снимок перезаписывается на контрольных точках: каждые StoreInterval секунд,
+// а при StoreInterval равном 0 - когда журнал разрастается. На диске хранятся SnapshotKeep последних снимков.
+type FileStorage struct {
+	*MemoryStorage
+	c   *Config
+	wal *wal
+
+	stop  chan struct{}  // закрывается при завершении работы, останавливая контрольные точки по таймеру
+	saver sync.WaitGroup // горутина периодических контрольных точек
+}
+
+// NewFileStorage создает новый экземпляр FileStorage, восстанавливает метрики со снимка и журнала
+// при необходимости и запускает периодическое создание контрольных точек.
+func NewFileStorage(c *Config) *FileStorage {
+	s := &FileStorage{
+		MemoryStorage: NewMemoryStorage(),
+		c:             c,
+	}
+
+	var tail walTail
+	if c.RestoreFlag {
+		var err error
+		tail, err = s.readFromDisk()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Println("Ошибка восстановления метрик:", err)
+		}
+	} else {
+		// Метрики с диска не загружаются, но нумерация журнала продолжается с последнего номера:
+		// иначе при следующем восстановлении новые записи были бы пропущены как уже учтённые в старом снимке.
+		old := &FileStorage{MemoryStorage: NewMemoryStorage(), c: c}
+		last, _ := old.readFromDisk()
+		tail.seq = last.seq
+	}
+
+	w, err := openWAL(walPath(c.FileStoragePath), tail.seq, tail.size)
+	if err != nil {
+		panic(err)
+	}
+	s.wal = w
+
+	// Старый снимок заменяется пустым, чтобы не загруженные метрики не вернулись при следующем восстановлении
+	if !c.RestoreFlag && tail.seq > 0 {
+		if err := s.writeToDisk(); err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	if c.StoreInterval > 0 {
+		s.stop = make(chan struct{})
+		s.saver.Add(1)
+		go func() {
+			defer s.saver.Done()
+			t := time.NewTicker(time.Duration(c.StoreInterval) * time.Second)
+			defer t.Stop()
+			for {
+				select {
+				case <-t.C:
+					if err := s.writeToDisk(); err != nil {
+						fmt.Println(err)
+					}
+				case <-s.stop:
+					return
+				}
+			}
+		}()
+	}
+	return s
+}
+
+// UpdateMetricValue записывает обновление метрики в журнал и применяет его в памяти.
+func (s *FileStorage) UpdateMetricValue(m Metrics) error {
+	return s.UpdateMetricsValue([]Metrics{m})
+}
+
+// UpdateMetricsValue записывает пакет обновлений в журнал одной записью и применяет его в памяти.
+func (s *FileStorage) UpdateMetricsValue(metrics []Metrics) error {
+	size, err := s.wal.append(metrics, func() error {
+		return s.MemoryStorage.UpdateMetricsValue(metrics)
+	})
+	if err != nil {
+		return err
+	}
+	if s.c.StoreInterval == 0 && size > walMaxSize {
+		return s.writeToDisk()
+	}
+	return nil
+}
+
+// Shutdown останавливает контрольные точки по таймеру и сохраняет данные о метриках при завершении работы.
+func (s *FileStorage) Shutdown() {
+	if s.stop != nil {
+		close(s.stop)
+		s.saver.Wait()
+	}
+	if err := s.writeToDisk(); err != nil {
+		fmt.Println(err)
+	}
+	if s.wal != nil {
+		_ = s.wal.close()
+	}
+}
+
+// ForTenant возвращает файловое хранилище арендатора со снимками и журналом в отдельных файлах рядом с основными.
+func (s *FileStorage) ForTenant(tenant string) Backend {
+	c := *s.c
+	c.FileStoragePath = tenantPath(s.c.FileStoragePath, tenant)
+	return NewFileStorage(&c)
+}
+
+// tenantPath возвращает путь к снимку арендатора: имя арендатора вставляется перед расширением файла.
+func tenantPath(path, tenant string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + tenant + ext
+}
+
+// ReadFromDisk считывает данные о метриках со снимка на диске и применяет к ним журнал предзаписи.
+func (s *FileStorage) ReadFromDisk() error {
+	_, err := s.readFromDisk()
+	return err
+}
+
+// readFromDisk восстанавливает метрики со снимка и журнала и возвращает конец целой части журнала
+// с номером, с которого продолжается нумерация: наибольшим из номеров снимка и записей журнала.
+// Записи журнала, уже учтённые в снимке, пропускаются.
+func (s *FileStorage) readFromDisk() (walTail, error) {
+	var seq uint64
+
+	snap, snapshotErr := s.loadSnapshot()
+	if snapshotErr == nil {
+		s.restore(snap)
+		seq = snap.Seq
+	}
+
+	records, tail, walErr := readWAL(walPath(s.c.FileStoragePath), seq)
+	for _, record := range records {
+		_ = s.MemoryStorage.UpdateMetricsValue(record.Metrics)
+	}
+	tail.seq = max(tail.seq, seq)
+	switch {
+	case walErr != nil && !errors.Is(walErr, os.ErrNotExist):
+		return tail, walErr
+	case walErr == nil && errors.Is(snapshotErr, os.ErrNotExist):
+		return tail, nil
+	default:
+		return tail, snapshotErr
+	}
+}
+
+// loadSnapshot считывает самый свежий целый снимок из хранимых на диске.
+// Если последний снимок повреждён, восстановление откатывается к более старому, о чём громко сообщается.
+func (s *FileStorage) loadSnapshot() (snapshot, error) {
+	var corrupted []error
+	for _, path := range snapshotPaths(s.c.FileStoragePath, s.c.SnapshotKeep) {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err == nil {
+			var snap snapshot
+			if snap, err = decodeSnapshotFile(data); err == nil {
+				if len(corrupted) > 0 {
+					fmt.Printf("ВНИМАНИЕ: последний снимок метрик повреждён, метрики восстановлены из более старого снимка %s\n", path)
+				}
+				return snap, nil
+			}
+		}
+		fmt.Printf("ВНИМАНИЕ: снимок метрик %s повреждён: %v\n", path, err)
+		corrupted = append(corrupted, fmt.Errorf("%s: %w", path, err))
+	}
+	if len(corrupted) > 0 {
+		return snapshot{}, fmt.Errorf("no valid snapshot: %w", errors.Join(corrupted...))
+	}
+	return snapshot{}, os.ErrNotExist
+}
+
+// decodeSnapshot разбирает содержимое файла с метриками.
+// Файлы прежнего формата без разделения типов содержат плоский словарь и восстанавливаются как gauge.
+func decodeSnapshot(data []byte) (snapshot, error) {
+	var snap snapshot
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&snap); err == nil {
+		return snap, nil
+	}
+
+	var legacy map[string]float64
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return snapshot{}, err
+	}
+	return snapshot{Gauges: legacy}, nil
+}
+
+// writeToDisk создает контрольную точку: сохраняет снимок метрик на диск и очищает журнал предзаписи.
+func (s *FileStorage) writeToDisk() error {
+	if s.wal == nil {
+		return s.writeSnapshot(0)
+	}
+	return s.wal.checkpoint(s.writeSnapshot)
+}
+
+// writeSnapshot атомарно сохраняет снимок метрик с номером последней учтённой записи журнала.
+func (s *FileStorage) writeSnapshot(seq uint64) error {
+	snap := s.snapshot()
+	snap.Seq = seq
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	return writeSnapshotFile(s.c.FileStoragePath, encodeSnapshotFile(data), s.c.SnapshotKeep)
+}
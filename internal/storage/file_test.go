@@ -0,0 +1,326 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Тест для функции writeToDisk
+func TestWriteToDisk(t *testing.T) {
+	// Создаем временный файл
+	tempFile, err := os.CreateTemp("", "testfile")
+	if err != nil {
+		t.Fatalf("Could not create temp file: %v", err)
+	}
+	defer os.Remove(tempFile.Name())
+
+	// Создаем экземпляр хранилища метрик для использования в тестах.
+	storage := &FileStorage{
+		MemoryStorage: NewMemoryStorage(),
+		c: &Config{
+			FileStoragePath: tempFile.Name(),
+		},
+	}
+
+	// Добавляем тестовые данные
+	storage.Gauges["metric1"] = 123.45
+	storage.Counters["metric2"] = 678
+
+	// Записываем в файл
+	err = storage.writeToDisk()
+	if err != nil {
+		t.Fatalf("writeToDisk failed: %v", err)
+	}
+
+	// Читаем данные из файла
+	content, err := os.ReadFile(tempFile.Name())
+	if err != nil {
+		t.Fatalf("Could not read from temp file: %v", err)
+	}
+
+	// Распаковываем данные и сравниваем
+	readMetrics, err := decodeSnapshotFile(content)
+	if err != nil {
+		t.Fatalf("Failed to decode snapshot: %v", err)
+	}
+
+	// Проверяем, что прочитанные метрики совпадают с ожидаемыми
+	assert.Equal(t, storage.Gauges, readMetrics.Gauges)
+	assert.Equal(t, storage.Counters, readMetrics.Counters)
+}
+
+func TestFileStorage_ReadFromDisk(t *testing.T) {
+	// Подготовка временного файла с данными
+	tempFile, err := os.CreateTemp("", "testfile.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tempFile.Name())
+
+	// Подготовка тестовых данных
+	testMetrics := snapshot{
+		Gauges:   map[string]float64{"metric1": 123.5},
+		Counters: map[string]int64{"metric1": 1 << 62, "metric2": 0},
+	}
+	testMetricsBytes, err := json.Marshal(testMetrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Запись данных во временный файл
+	if err := os.WriteFile(tempFile.Name(), testMetricsBytes, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Подготовка объекта FileStorage для теста
+	storage := FileStorage{
+		MemoryStorage: NewMemoryStorage(),
+		c:             &Config{FileStoragePath: tempFile.Name()},
+	}
+
+	// Вызов тестируемой функции
+	err = storage.ReadFromDisk()
+
+	// Проверка результата
+	assert.NoError(t, err)
+	assert.Equal(t, testMetrics.Gauges, storage.Gauges)
+	assert.Equal(t, testMetrics.Counters, storage.Counters)
+}
+
+func TestDecodeSnapshot_Legacy(t *testing.T) {
+	// Файл прежнего формата восстанавливается как набор gauge
+	snap, err := decodeSnapshot([]byte(`{"metric1": 123, "metric2": 0.5}`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"metric1": 123, "metric2": 0.5}, snap.Gauges)
+	assert.Empty(t, snap.Counters)
+
+	_, err = decodeSnapshot([]byte(`not json`))
+	assert.Error(t, err)
+}
+
+func TestFileStorage_SyncWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	storage := NewFileStorage(&Config{FileStoragePath: path, StoreInterval: 0})
+
+	// В синхронном режиме каждое обновление сразу попадает в журнал на диске
+	require.NoError(t, storage.UpdateMetricValue(Metrics{ID: "gauge1", MType: "gauge", Value: float64Ptr(1.5)}))
+	require.NoError(t, storage.UpdateMetricsValue([]Metrics{
+		{ID: "counter1", MType: "counter", Delta: int64Ptr(2)},
+		{ID: "counter1", MType: "counter", Delta: int64Ptr(3)},
+	}))
+
+	// Новое хранилище восстанавливает метрики из файла
+	restored := NewFileStorage(&Config{FileStoragePath: path, StoreInterval: 0, RestoreFlag: true})
+
+	value, err := restored.GetMetricByName(Metrics{ID: "gauge1"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1.5, value)
+
+	value, err = restored.GetMetricByName(Metrics{ID: "counter1"})
+	assert.NoError(t, err)
+	assert.Equal(t, float64(5), value)
+}
+
+func TestFileStorage_Shutdown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	storage := NewFileStorage(&Config{FileStoragePath: path, StoreInterval: 300})
+
+	require.NoError(t, storage.UpdateMetricValue(Metrics{ID: "gauge1", MType: "gauge", Value: float64Ptr(2.5)}))
+
+	// До завершения работы файл не записывается
+	_, err := os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	storage.Shutdown()
+
+	restored := NewFileStorage(&Config{FileStoragePath: path, StoreInterval: 300, RestoreFlag: true})
+	value, err := restored.GetMetricByName(Metrics{ID: "gauge1"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2.5, value)
+}
+
+func TestFileStorage_CrashRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	storage := NewFileStorage(&Config{FileStoragePath: path, StoreInterval: 300})
+
+	require.NoError(t, storage.UpdateMetricValue(Metrics{ID: "counter1", MType: "counter", Delta: int64Ptr(2)}))
+	require.NoError(t, storage.writeToDisk())
+	require.NoError(t, storage.UpdateMetricValue(Metrics{ID: "counter1", MType: "counter", Delta: int64Ptr(3)}))
+	require.NoError(t, storage.UpdateMetricValue(Metrics{ID: "gauge1", MType: "gauge", Value: float64Ptr(4.5)}))
+
+	// Процесс завершается без Shutdown: обновления после контрольной точки есть только в журнале
+	restored := NewFileStorage(&Config{FileStoragePath: path, StoreInterval: 300, RestoreFlag: true})
+
+	value, err := restored.GetMetricByName(Metrics{ID: "counter1", MType: "counter"})
+	assert.NoError(t, err)
+	assert.Equal(t, float64(5), value)
+
+	value, err = restored.GetMetricByName(Metrics{ID: "gauge1", MType: "gauge"})
+	assert.NoError(t, err)
+	assert.Equal(t, 4.5, value)
+
+	// Нумерация записей продолжается после восстановления
+	require.NoError(t, restored.UpdateMetricValue(Metrics{ID: "counter1", MType: "counter", Delta: int64Ptr(1)}))
+	records, _, err := readWAL(walPath(path), 0)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, uint64(4), records[2].Seq)
+}
+
+func TestFileStorage_WithoutRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	storage := NewFileStorage(&Config{FileStoragePath: path, StoreInterval: 300})
+	require.NoError(t, storage.UpdateMetricValue(Metrics{ID: "counter1", MType: "counter", Delta: int64Ptr(2)}))
+	require.NoError(t, storage.UpdateMetricValue(Metrics{ID: "counter1", MType: "counter", Delta: int64Ptr(3)}))
+	storage.Shutdown()
+
+	// Запуск без восстановления продолжает нумерацию журнала и заменяет старый снимок пустым
+	fresh := NewFileStorage(&Config{FileStoragePath: path, StoreInterval: 300})
+	_, err := fresh.GetMetricByName(Metrics{ID: "counter1"})
+	assert.Error(t, err)
+	require.NoError(t, fresh.UpdateMetricValue(Metrics{ID: "gauge1", MType: "gauge", Value: float64Ptr(1.5)}))
+	records, _, err := readWAL(walPath(path), 0)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, uint64(3), records[0].Seq)
+
+	// Процесс завершается без Shutdown: запись из журнала не теряется при восстановлении
+	restored := NewFileStorage(&Config{FileStoragePath: path, StoreInterval: 300, RestoreFlag: true})
+	value, err := restored.GetMetricByName(Metrics{ID: "gauge1"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1.5, value)
+	_, err = restored.GetMetricByName(Metrics{ID: "counter1"})
+	assert.Error(t, err)
+}
+
+func TestFileStorage_ShutdownStopsTicker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	storage := NewFileStorage(&Config{FileStoragePath: path, StoreInterval: 1})
+	storage.Shutdown()
+
+	// После Shutdown контрольные точки по таймеру больше не выполняются
+	select {
+	case <-storage.stop:
+	default:
+		t.Fatal("контрольные точки по таймеру не остановлены")
+	}
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	time.Sleep(1100 * time.Millisecond)
+	after, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, info.ModTime(), after.ModTime())
+}
+
+func TestFileStorage_Checkpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	storage := NewFileStorage(&Config{FileStoragePath: path, StoreInterval: 300})
+
+	require.NoError(t, storage.UpdateMetricValue(Metrics{ID: "counter1", MType: "counter", Delta: int64Ptr(2)}))
+	require.NoError(t, storage.writeToDisk())
+
+	// После контрольной точки журнал пуст, а снимок помнит номер последней записи
+	info, err := os.Stat(walPath(path))
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	snap, err := decodeSnapshotFile(data)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), snap.Seq)
+	assert.Equal(t, int64(2), snap.Counters["counter1"])
+}
+
+func TestFileStorage_SkipsCheckpointedRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	// Сбой между записью снимка и очисткой журнала: запись 1 уже учтена в снимке
+	data, err := json.Marshal(snapshot{Seq: 1, Counters: map[string]int64{"counter1": 2}})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	require.NoError(t, os.WriteFile(walPath(path), []byte(
+		`{"seq":1,"metrics":[{"id":"counter1","type":"counter","delta":2}]}`+"\n"+
+			`{"seq":2,"metrics":[{"id":"counter1","type":"counter","delta":3}]}`+"\n"), 0644))
+
+	storage := NewFileStorage(&Config{FileStoragePath: path, StoreInterval: 300, RestoreFlag: true})
+	value, err := storage.GetMetricByName(Metrics{ID: "counter1", MType: "counter"})
+	assert.NoError(t, err)
+	assert.Equal(t, float64(5), value)
+}
+
+func TestFileStorage_RestoreWithoutSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	// Снимок повреждён, а журнал оборван на последней записи
+	require.NoError(t, os.WriteFile(path, encodeSnapshotFile([]byte("{}"))[:20], 0644))
+	require.NoError(t, os.WriteFile(walPath(path), []byte(
+		`{"seq":7,"metrics":[{"id":"counter1","type":"counter","delta":2}]}`+"\n"+
+			`{"seq":8,"metrics":[{"id":"coun`), 0644))
+
+	storage := NewFileStorage(&Config{FileStoragePath: path, StoreInterval: 300, RestoreFlag: true})
+	require.NoError(t, storage.UpdateMetricValue(Metrics{ID: "counter1", MType: "counter", Delta: int64Ptr(3)}))
+
+	// Нумерация продолжается с последней записи журнала, новая запись идёт сразу за целой частью
+	records, _, err := readWAL(walPath(path), 0)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, uint64(8), records[1].Seq)
+
+	restored := NewFileStorage(&Config{FileStoragePath: path, StoreInterval: 300, RestoreFlag: true})
+	value, err := restored.GetMetricByName(Metrics{ID: "counter1", MType: "counter"})
+	assert.NoError(t, err)
+	assert.Equal(t, float64(5), value)
+}
+
+func TestFileStorage_NoRestoreTruncatesWAL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	require.NoError(t, os.WriteFile(walPath(path), []byte(
+		`{"seq":1,"metrics":[{"id":"counter1","type":"counter","delta":2}]}`+"\n"), 0644))
+
+	storage := NewFileStorage(&Config{FileStoragePath: path, StoreInterval: 300})
+	_, err := storage.GetMetricByName(Metrics{ID: "counter1", MType: "counter"})
+	assert.Error(t, err)
+
+	info, err := os.Stat(walPath(path))
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+}
+
+func TestFileStorage_ForTenant(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics-db.json")
+	c := &Config{FileStoragePath: path, RestoreFlag: true}
+	s := NewFileStorage(c)
+	teamA := s.ForTenant("team-a")
+
+	require.NoError(t, s.UpdateMetricValue(Metrics{ID: "HeapAlloc", MType: "gauge", Value: float64Ptr(1)}))
+	require.NoError(t, teamA.UpdateMetricValue(Metrics{ID: "HeapAlloc", MType: "gauge", Value: float64Ptr(2)}))
+	s.Shutdown()
+	teamA.Shutdown()
+
+	assert.FileExists(t, filepath.Join(filepath.Dir(path), "metrics-db.team-a.json"))
+
+	// После перезапуска каждый арендатор восстанавливает свои метрики
+	restored := NewFileStorage(c)
+	defer restored.Shutdown()
+	restoredA := restored.ForTenant("team-a")
+	defer restoredA.Shutdown()
+
+	value, err := restored.GetMetricByName(Metrics{ID: "HeapAlloc", MType: "gauge"})
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), value)
+	value, err = restoredA.GetMetricByName(Metrics{ID: "HeapAlloc", MType: "gauge"})
+	require.NoError(t, err)
+	assert.Equal(t, float64(2), value)
+}
+
+func TestTenantPath(t *testing.T) {
+	assert.Equal(t, "/tmp/metrics-db.team-a.json", tenantPath("/tmp/metrics-db.json", "team-a"))
+	assert.Equal(t, "/tmp/metrics.team-a", tenantPath("/tmp/metrics", "team-a"))
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// errHistogramBounds возвращается при слиянии гистограмм с разными границами корзин.
+var errHistogramBounds = errors.New("histogram bounds mismatch")
+
+// Histogram представляет собой распределение значений метрики типа histogram по корзинам.
+// Bounds - возрастающие верхние границы корзин; Counts[i] - число значений в интервале (Bounds[i-1], Bounds[i]],
+// последний элемент Counts - число значений больше всех границ. Sum - сумма всех значений.
+// Как и counter, гистограмма передается приращениями: присланные значения прибавляются к хранимым,
+// поэтому гистограммы нескольких агентов с одинаковыми границами складываются.
+type Histogram struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []uint64  `json:"counts"`
+	Sum    float64   `json:"sum"`
+}
+
+// Validate проверяет, что границы конечны и строго возрастают, а корзин на одну больше, чем границ.
+func (h *Histogram) Validate() error {
+	if len(h.Counts) != len(h.Bounds)+1 {
+		return fmt.Errorf("histogram must have %d counts for %d bounds", len(h.Bounds)+1, len(h.Bounds))
+	}
+	for i, b := range h.Bounds {
+		if math.IsNaN(b) || math.IsInf(b, 0) {
+			return fmt.Errorf("invalid histogram bound: %v", b)
+		}
+		if i > 0 && b <= h.Bounds[i-1] {
+			return fmt.Errorf("histogram bounds must be increasing")
+		}
+	}
+	if math.IsNaN(h.Sum) || math.IsInf(h.Sum, 0) {
+		return fmt.Errorf("invalid histogram sum: %v", h.Sum)
+	}
+	return nil
+}
+
+// Count возвращает общее число значений гистограммы.
+func (h *Histogram) Count() uint64 {
+	var count uint64
+	for _, c := range h.Counts {
+		count += c
+	}
+	return count
+}
+
+// Merge прибавляет к гистограмме значения other с теми же границами корзин.
+func (h *Histogram) Merge(other *Histogram) error {
+	if len(h.Bounds) != len(other.Bounds) || len(h.Counts) != len(other.Counts) {
+		return errHistogramBounds
+	}
+	for i := range h.Bounds {
+		if h.Bounds[i] != other.Bounds[i] {
+			return errHistogramBounds
+		}
+	}
+	for i := range h.Counts {
+		h.Counts[i] += other.Counts[i]
+	}
+	h.Sum += other.Sum
+	return nil
+}
+
+// Quantile оценивает квантиль q из [0, 1] линейной интерполяцией внутри корзины, как histogram_quantile
+// в Prometheus. Нижней границей первой корзины считается 0, если её верхняя граница положительна.
+// Если квантиль попадает в последнюю корзину, возвращается наибольшая граница.
+// Для пустой гистограммы или q вне [0, 1] возвращается NaN.
+func (h *Histogram) Quantile(q float64) float64 {
+	count := h.Count()
+	if count == 0 || q < 0 || q > 1 || math.IsNaN(q) {
+		return math.NaN()
+	}
+
+	rank := q * float64(count)
+	var cumulative float64
+	for i, c := range h.Counts {
+		if c == 0 || cumulative+float64(c) < rank {
+			cumulative += float64(c)
+			continue
+		}
+		if i == len(h.Bounds) {
+			break
+		}
+		upper := h.Bounds[i]
+		lower := 0.0
+		switch {
+		case i > 0:
+			lower = h.Bounds[i-1]
+		case upper <= 0:
+			return upper
+		}
+		return lower + (upper-lower)*(rank-cumulative)/float64(c)
+	}
+	if len(h.Bounds) == 0 {
+		return math.NaN()
+	}
+	return h.Bounds[len(h.Bounds)-1]
+}
+
+// Clone возвращает копию гистограммы.
+func (h *Histogram) Clone() *Histogram {
+	return &Histogram{
+		Bounds: append([]float64(nil), h.Bounds...),
+		Counts: append([]uint64(nil), h.Counts...),
+		Sum:    h.Sum,
+	}
+}
+
+// String возвращает сводку гистограммы для списка метрик: число и сумму значений и оценки квантилей 0.5, 0.9 и 0.99.
+func (h *Histogram) String() string {
+	s := "count=" + strconv.FormatUint(h.Count(), 10) + " sum=" + strconv.FormatFloat(h.Sum, 'g', -1, 64)
+	if h.Count() == 0 {
+		return s
+	}
+	for _, q := range []struct {
+		name  string
+		value float64
+	}{{"p50", 0.5}, {"p90", 0.9}, {"p99", 0.99}} {
+		s += " " + q.name + "=" + strconv.FormatFloat(h.Quantile(q.value), 'g', -1, 64)
+	}
+	return s
+}
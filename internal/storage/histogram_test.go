@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogram_Validate(t *testing.T) {
+	assert.NoError(t, (&Histogram{Bounds: []float64{0.1, 1}, Counts: []uint64{1, 2, 3}}).Validate())
+	assert.NoError(t, (&Histogram{Counts: []uint64{5}}).Validate())
+
+	for _, h := range []Histogram{
+		{Bounds: []float64{0.1, 1}, Counts: []uint64{1, 2}},
+		{Bounds: []float64{1, 1}, Counts: []uint64{1, 2, 3}},
+		{Bounds: []float64{math.Inf(1)}, Counts: []uint64{1, 2}},
+		{Bounds: []float64{1}, Counts: []uint64{1, 2}, Sum: math.NaN()},
+	} {
+		assert.Error(t, h.Validate(), h)
+	}
+}
+
+func TestHistogram_Merge(t *testing.T) {
+	h := &Histogram{Bounds: []float64{1, 5}, Counts: []uint64{1, 2, 0}, Sum: 7}
+	require.NoError(t, h.Merge(&Histogram{Bounds: []float64{1, 5}, Counts: []uint64{0, 1, 1}, Sum: 12}))
+	assert.Equal(t, &Histogram{Bounds: []float64{1, 5}, Counts: []uint64{1, 3, 1}, Sum: 19}, h)
+	assert.Equal(t, uint64(5), h.Count())
+
+	// Гистограммы с разными границами не складываются
+	assert.ErrorIs(t, h.Merge(&Histogram{Bounds: []float64{1, 10}, Counts: []uint64{1, 1, 1}}), errHistogramBounds)
+	assert.ErrorIs(t, h.Merge(&Histogram{Bounds: []float64{1}, Counts: []uint64{1, 1}}), errHistogramBounds)
+	assert.Equal(t, uint64(5), h.Count())
+}
+
+func TestHistogram_Quantile(t *testing.T) {
+	h := &Histogram{Bounds: []float64{1, 2, 4}, Counts: []uint64{10, 10, 0, 0}}
+	assert.InDelta(t, 0.5, h.Quantile(0.25), 1e-9)
+	assert.InDelta(t, 1, h.Quantile(0.5), 1e-9)
+	assert.InDelta(t, 1.5, h.Quantile(0.75), 1e-9)
+	assert.InDelta(t, 2, h.Quantile(1), 1e-9)
+
+	// Значения больше всех границ оцениваются наибольшей границей
+	h = &Histogram{Bounds: []float64{1, 2}, Counts: []uint64{1, 0, 9}}
+	assert.Equal(t, 2.0, h.Quantile(0.9))
+
+	assert.True(t, math.IsNaN(h.Quantile(1.5)))
+	assert.True(t, math.IsNaN((&Histogram{Bounds: []float64{1}, Counts: []uint64{0, 0}}).Quantile(0.5)))
+	assert.True(t, math.IsNaN((&Histogram{Counts: []uint64{3}}).Quantile(0.5)))
+
+	assert.Equal(t, "count=20 sum=25 p50=1 p90=1.8 p99=1.98",
+		(&Histogram{Bounds: []float64{1, 2, 4}, Counts: []uint64{10, 10, 0, 0}, Sum: 25}).String())
+}
+
+func TestMemoryStorage_Histogram(t *testing.T) {
+	s := NewMemoryStorage()
+	bounds := []float64{0.1, 1}
+	web1 := map[string]string{"host": "web-1"}
+
+	// Гистограммы нескольких агентов складываются
+	require.NoError(t, s.UpdateMetricValue(Metrics{ID: "latency", MType: "histogram",
+		Histogram: &Histogram{Bounds: bounds, Counts: []uint64{1, 1, 0}, Sum: 0.55}}))
+	require.NoError(t, s.UpdateMetricsValue([]Metrics{{ID: "latency", MType: "histogram",
+		Histogram: &Histogram{Bounds: bounds, Counts: []uint64{2, 0, 1}, Sum: 3.1}}}))
+	require.NoError(t, s.UpdateMetricValue(Metrics{ID: "latency", MType: "histogram", Labels: web1,
+		Histogram: &Histogram{Bounds: bounds, Counts: []uint64{0, 0, 4}, Sum: 8}}))
+
+	stored, err := s.GetMetric(Metrics{ID: "latency"})
+	require.NoError(t, err)
+	assert.Equal(t, "histogram", stored.MType)
+	assert.Equal(t, []uint64{3, 1, 1}, stored.Histogram.Counts)
+	assert.InDelta(t, 3.65, stored.Histogram.Sum, 1e-9)
+
+	// Возвращается копия: изменение ответа не меняет хранилище
+	stored.Histogram.Counts[0] = 100
+	value, err := s.GetMetricByName(Metrics{ID: "latency", MType: "histogram"})
+	require.NoError(t, err)
+	assert.Equal(t, 5.0, value)
+
+	assert.Error(t, s.UpdateMetricValue(Metrics{ID: "latency", MType: "histogram",
+		Histogram: &Histogram{Bounds: []float64{1}, Counts: []uint64{1, 1}}}))
+	assert.Error(t, s.UpdateMetricValue(Metrics{ID: "latency", MType: "histogram"}))
+
+	metrics, err := s.ListMetrics()
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	assert.Equal(t, web1, metrics[1].Labels)
+	assert.Contains(t, s.GetAllMetrics(), "latency/count=5 sum=3.65")
+
+	// Гистограммы сохраняются в снимке хранилища
+	restored := NewMemoryStorage()
+	restored.restore(s.snapshot())
+	assert.Equal(t, s.Histograms, restored.Histograms)
+}
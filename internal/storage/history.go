@@ -0,0 +1,409 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Sample представляет собой значение метрики в момент времени.
+type Sample struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+}
+
+// Aggregate представляет собой сводку значений метрики за интервал, начинающийся в Timestamp.
+// Для gauge заполняются Min, Max, Avg, Last и Sum, для counter - Last и Rate (прирост в секунду).
+type Aggregate struct {
+	Timestamp time.Time `json:"timestamp"`
+	Count     int       `json:"count"`
+	Min       float64   `json:"min"`
+	Max       float64   `json:"max"`
+	Avg       float64   `json:"avg"`
+	Last      float64   `json:"last"`
+	Sum       float64   `json:"sum"`
+	Rate      float64   `json:"rate"`
+}
+
+// RangeQuery описывает запрос истории значений метрики за интервал времени.
+// При нулевом Step возвращаются все сохранённые значения из интервала.
+// Aggregation выбирает поле агрегатов (min, max, avg, last, sum, rate), когда ответ строится
+// по уплотнённым данным; по умолчанию avg для gauge и last для counter. Labels выбирает ряд метрики
+// с точно таким набором меток.
+type RangeQuery struct {
+	ID          string
+	MType       string
+	Labels      map[string]string
+	From        time.Time
+	To          time.Time
+	Step        time.Duration
+	Aggregation string
+}
+
+// Series представляет собой историю значений одной метрики.
+type Series struct {
+	ID         string            `json:"id"`
+	MType      string            `json:"type"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Resolution string            `json:"resolution"`
+	Points     []Sample          `json:"points"`
+}
+
+// TierConfig описывает уровень уплотнения истории: разрешение агрегатов и срок их хранения.
+// Нулевой срок хранения означает, что агрегаты не удаляются.
+type TierConfig struct {
+	Resolution time.Duration
+	Retention  time.Duration
+}
+
+// seriesKey идентифицирует историю метрики по типу, имени и канонической записи меток.
+type seriesKey struct {
+	MType  string
+	ID     string
+	Labels string
+}
+
+// tier хранит агрегаты значений метрик с одним разрешением.
+type tier struct {
+	TierConfig
+	series map[seriesKey][]Aggregate
+	// compacted - конец последнего уже агрегированного интервала для каждой метрики.
+	compacted map[seriesKey]time.Time
+}
+
+// History хранит в памяти историю значений метрик в пределах срока хранения
+// и уплотняет её в агрегаты с более грубым разрешением.
+// Нулевой срок хранения означает, что значения не удаляются.
+type History struct {
+	mu        sync.RWMutex
+	retention time.Duration
+	series    map[seriesKey][]Sample
+	tiers     []*tier
+}
+
+// NewHistory создает новый экземпляр History с заданным сроком хранения значений и уровнями уплотнения.
+// Уровни должны идти по возрастанию разрешения, каждое разрешение кратно предыдущему.
+func NewHistory(retention time.Duration, tiers ...TierConfig) *History {
+	h := &History{
+		retention: retention,
+		series:    make(map[seriesKey][]Sample),
+	}
+	for _, c := range tiers {
+		h.tiers = append(h.tiers, &tier{
+			TierConfig: c,
+			series:     make(map[seriesKey][]Aggregate),
+			compacted:  make(map[seriesKey]time.Time),
+		})
+	}
+	return h
+}
+
+// empty возвращает пустую историю с тем же сроком хранения и уровнями уплотнения.
+func (h *History) empty() *History {
+	tiers := make([]TierConfig, 0, len(h.tiers))
+	for _, t := range h.tiers {
+		tiers = append(tiers, t.TierConfig)
+	}
+	return NewHistory(h.retention, tiers...)
+}
+
+// Append добавляет значение метрики в историю и удаляет значения старше срока хранения.
+func (h *History) Append(m Metrics, ts time.Time) {
+	key := seriesKey{MType: m.MType, ID: m.ID, Labels: LabelsKey(m.Labels)}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	samples := h.series[key]
+	// Значения обычно приходят по порядку, но при гонке запросов вставляем в нужное место.
+	i := sort.Search(len(samples), func(i int) bool { return samples[i].Timestamp.After(ts) })
+	samples = append(samples, Sample{})
+	copy(samples[i+1:], samples[i:])
+	samples[i] = Sample{Timestamp: ts, Value: m.Float64()}
+
+	if h.retention > 0 {
+		samples = trimSamples(samples, ts.Add(-h.retention))
+	}
+	h.series[key] = samples
+}
+
+// trimSamples отбрасывает значения, сохранённые раньше момента cutoff.
+func trimSamples(samples []Sample, cutoff time.Time) []Sample {
+	i := sort.Search(len(samples), func(i int) bool { return !samples[i].Timestamp.Before(cutoff) })
+	return samples[i:]
+}
+
+// trimAggregates отбрасывает агрегаты интервалов, начавшихся раньше момента cutoff.
+func trimAggregates(aggregates []Aggregate, cutoff time.Time) []Aggregate {
+	i := sort.Search(len(aggregates), func(i int) bool { return !aggregates[i].Timestamp.Before(cutoff) })
+	return aggregates[i:]
+}
+
+// Compact агрегирует завершившиеся к моменту now интервалы на каждом уровне уплотнения
+// и удаляет значения и агрегаты старше срока хранения своего уровня.
+func (h *History) Compact(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, t := range h.tiers {
+		if i == 0 {
+			for key, samples := range h.series {
+				t.compactSamples(key, samples, now)
+			}
+			continue
+		}
+		prev := h.tiers[i-1]
+		for key, aggregates := range prev.series {
+			t.compactAggregates(key, aggregates, prev.Resolution, now)
+		}
+	}
+
+	if h.retention > 0 {
+		for key, samples := range h.series {
+			if samples = trimSamples(samples, now.Add(-h.retention)); len(samples) == 0 {
+				delete(h.series, key)
+				continue
+			}
+			h.series[key] = samples
+		}
+	}
+	for _, t := range h.tiers {
+		if t.Retention <= 0 {
+			continue
+		}
+		for key, aggregates := range t.series {
+			if aggregates = trimAggregates(aggregates, now.Add(-t.Retention)); len(aggregates) == 0 {
+				delete(t.series, key)
+				delete(t.compacted, key)
+				continue
+			}
+			t.series[key] = aggregates
+		}
+	}
+}
+
+// compactSamples сворачивает исходные значения метрики в агрегаты завершившихся интервалов.
+func (t *tier) compactSamples(key seriesKey, samples []Sample, now time.Time) {
+	end := now.Truncate(t.Resolution)
+	start := t.compacted[key]
+	first := sort.Search(len(samples), func(i int) bool { return !samples[i].Timestamp.Before(start) })
+
+	for i := first; i < len(samples) && samples[i].Timestamp.Before(end); {
+		bucket := samples[i].Timestamp.Truncate(t.Resolution)
+		agg := Aggregate{Timestamp: bucket, Min: math.Inf(1), Max: math.Inf(-1)}
+
+		// Прирост counter считается от последнего значения перед интервалом, если оно есть.
+		var increase float64
+		prev, hasPrev := 0.0, i > 0
+		if hasPrev {
+			prev = samples[i-1].Value
+		}
+		for ; i < len(samples) && samples[i].Timestamp.Truncate(t.Resolution).Equal(bucket); i++ {
+			v := samples[i].Value
+			agg.Count++
+			agg.Min = math.Min(agg.Min, v)
+			agg.Max = math.Max(agg.Max, v)
+			agg.Sum += v
+			agg.Last = v
+			if hasPrev {
+				increase += counterIncrease(prev, v)
+			}
+			prev, hasPrev = v, true
+		}
+		agg.Avg = agg.Sum / float64(agg.Count)
+		if monotonic(key.MType) {
+			agg.Rate = increase / t.Resolution.Seconds()
+		}
+
+		t.series[key] = append(t.series[key], agg)
+		t.compacted[key] = bucket.Add(t.Resolution)
+	}
+}
+
+// compactAggregates сворачивает агрегаты предыдущего уровня в агрегаты завершившихся интервалов.
+func (t *tier) compactAggregates(key seriesKey, source []Aggregate, sourceResolution time.Duration, now time.Time) {
+	end := now.Truncate(t.Resolution)
+	start := t.compacted[key]
+	first := sort.Search(len(source), func(i int) bool { return !source[i].Timestamp.Before(start) })
+
+	for i := first; i < len(source) && source[i].Timestamp.Before(end); {
+		bucket := source[i].Timestamp.Truncate(t.Resolution)
+		agg := Aggregate{Timestamp: bucket, Min: math.Inf(1), Max: math.Inf(-1)}
+
+		var increase float64
+		for ; i < len(source) && source[i].Timestamp.Truncate(t.Resolution).Equal(bucket); i++ {
+			s := source[i]
+			agg.Count += s.Count
+			agg.Min = math.Min(agg.Min, s.Min)
+			agg.Max = math.Max(agg.Max, s.Max)
+			agg.Sum += s.Sum
+			agg.Last = s.Last
+			increase += s.Rate * sourceResolution.Seconds()
+		}
+		agg.Avg = agg.Sum / float64(agg.Count)
+		if monotonic(key.MType) {
+			agg.Rate = increase / t.Resolution.Seconds()
+		}
+
+		t.series[key] = append(t.series[key], agg)
+		t.compacted[key] = bucket.Add(t.Resolution)
+	}
+}
+
+// monotonic сообщает, что история метрики типа mType растет, как у counter. История histogram и summary
+// хранит общее число значений, поэтому агрегируется так же, как counter.
+func monotonic(mType string) bool {
+	return mType == "counter" || mType == "histogram" || mType == "summary"
+}
+
+// counterIncrease возвращает прирост counter между двумя значениями с учетом сброса счетчика.
+func counterIncrease(prev, cur float64) float64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
+
+// QueryRange возвращает историю метрики за интервал запроса.
+// Если тип метрики не указан, сначала ищется gauge, затем counter, histogram и summary.
+// Ответ строится по исходным значениям или по одному из уровней уплотнения, см. selectLevel.
+// При ненулевом Step значения выравниваются по сетке From, From+Step, ..., To:
+// в каждой точке берётся последнее значение за предшествующий ей шаг.
+func (h *History) QueryRange(q RangeQuery) (Series, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	key, ok := h.lookup(q)
+	if !ok {
+		return Series{}, fmt.Errorf("undefind metricName: %v", q.ID)
+	}
+
+	now := time.Now()
+	level := h.selectLevel(q, now)
+
+	var (
+		points     []Sample
+		retention  = h.retention
+		resolution = "raw"
+	)
+	if level < 0 {
+		points = h.series[key]
+	} else {
+		t := h.tiers[level]
+		retention, resolution = t.Retention, formatResolution(t.Resolution)
+		field := q.Aggregation
+		if field == "" && monotonic(key.MType) {
+			field = "last"
+		}
+		for _, agg := range t.series[key] {
+			points = append(points, Sample{Timestamp: agg.Timestamp, Value: aggregateValue(agg, field)})
+		}
+	}
+
+	cutoff := q.From
+	if retention > 0 {
+		if oldest := now.Add(-retention); oldest.After(cutoff) {
+			cutoff = oldest
+		}
+	}
+	first := sort.Search(len(points), func(i int) bool { return !points[i].Timestamp.Before(cutoff) })
+	last := sort.Search(len(points), func(i int) bool { return points[i].Timestamp.After(q.To) })
+	points = points[first:last]
+
+	series := Series{ID: key.ID, MType: key.MType, Labels: q.Labels, Resolution: resolution, Points: []Sample{}}
+	if q.Step <= 0 {
+		series.Points = append(series.Points, points...)
+		return series, nil
+	}
+
+	i := 0
+	for t := q.From; !t.After(q.To); t = t.Add(q.Step) {
+		for i < len(points) && !points[i].Timestamp.After(t) {
+			i++
+		}
+		if i > 0 && points[i-1].Timestamp.After(t.Add(-q.Step)) {
+			series.Points = append(series.Points, Sample{Timestamp: t, Value: points[i-1].Value})
+		}
+	}
+	return series, nil
+}
+
+// selectLevel выбирает, по каким данным строить ответ: -1 для исходных значений или индекс уровня уплотнения.
+// Без шага берётся самый подробный уровень, срок хранения которого покрывает начало запроса.
+// С шагом берётся самый грубый такой уровень с разрешением не больше шага,
+// чтобы точки строились по агрегатам, а не по отдельным значениям.
+// Если ни один подходящий уровень не покрывает начало запроса, берётся самый грубый.
+func (h *History) selectLevel(q RangeQuery, now time.Time) int {
+	covers := func(retention time.Duration) bool {
+		return retention <= 0 || !q.From.Before(now.Add(-retention))
+	}
+
+	if q.Step > 0 {
+		for i := len(h.tiers) - 1; i >= 0; i-- {
+			if h.tiers[i].Resolution <= q.Step && covers(h.tiers[i].Retention) {
+				return i
+			}
+		}
+	}
+	if covers(h.retention) || len(h.tiers) == 0 {
+		return -1
+	}
+	for i, t := range h.tiers {
+		if covers(t.Retention) {
+			return i
+		}
+	}
+	return len(h.tiers) - 1
+}
+
+// formatResolution возвращает разрешение уровня уплотнения в коротком виде, например 1m или 1h.
+func formatResolution(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return d.String()
+	}
+}
+
+// aggregateValue возвращает выбранное поле агрегата, по умолчанию среднее значение.
+func aggregateValue(agg Aggregate, field string) float64 {
+	switch field {
+	case "min":
+		return agg.Min
+	case "max":
+		return agg.Max
+	case "last":
+		return agg.Last
+	case "sum":
+		return agg.Sum
+	case "rate":
+		return agg.Rate
+	default:
+		return agg.Avg
+	}
+}
+
+// lookup находит ключ истории метрики по запросу среди исходных значений и уровней уплотнения.
+func (h *History) lookup(q RangeQuery) (seriesKey, bool) {
+	types := []string{q.MType}
+	if q.MType == "" {
+		types = []string{"gauge", "counter", "histogram", "summary"}
+	}
+	for _, mType := range types {
+		key := seriesKey{MType: mType, ID: q.ID, Labels: LabelsKey(q.Labels)}
+		if _, exists := h.series[key]; exists {
+			return key, true
+		}
+		for _, t := range h.tiers {
+			if _, exists := t.series[key]; exists {
+				return key, true
+			}
+		}
+	}
+	return seriesKey{}, false
+}
@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory_QueryRange(t *testing.T) {
+	h := NewHistory(0)
+	start := time.Now().Truncate(time.Second).Add(-time.Minute)
+
+	// Значения gauge каждые 10 секунд, одно из них пришло не по порядку
+	for _, i := range []int{0, 1, 3, 2, 4, 5} {
+		h.Append(Metrics{ID: "HeapAlloc", MType: "gauge", Value: float64Ptr(float64(i))}, start.Add(time.Duration(i)*10*time.Second))
+	}
+	h.Append(Metrics{ID: "HeapAlloc", MType: "counter", Delta: int64Ptr(100)}, start)
+
+	t.Run("Raw Samples", func(t *testing.T) {
+		series, err := h.QueryRange(RangeQuery{ID: "HeapAlloc", From: start.Add(10 * time.Second), To: start.Add(30 * time.Second)})
+		require.NoError(t, err)
+		assert.Equal(t, "gauge", series.MType)
+		assert.Equal(t, []Sample{
+			{Timestamp: start.Add(10 * time.Second), Value: 1},
+			{Timestamp: start.Add(20 * time.Second), Value: 2},
+			{Timestamp: start.Add(30 * time.Second), Value: 3},
+		}, series.Points)
+	})
+
+	t.Run("Step", func(t *testing.T) {
+		series, err := h.QueryRange(RangeQuery{ID: "HeapAlloc", MType: "gauge", From: start.Add(-20 * time.Second), To: start.Add(50 * time.Second), Step: 25 * time.Second})
+		require.NoError(t, err)
+		// Точка start-20s пропускается: за предшествующий шаг значений нет
+		assert.Equal(t, []Sample{
+			{Timestamp: start.Add(5 * time.Second), Value: 0},
+			{Timestamp: start.Add(30 * time.Second), Value: 3},
+		}, series.Points)
+	})
+
+	t.Run("Counter Namespace", func(t *testing.T) {
+		series, err := h.QueryRange(RangeQuery{ID: "HeapAlloc", MType: "counter", From: start, To: start})
+		require.NoError(t, err)
+		assert.Equal(t, []Sample{{Timestamp: start, Value: 100}}, series.Points)
+	})
+
+	t.Run("Unknown Metric", func(t *testing.T) {
+		_, err := h.QueryRange(RangeQuery{ID: "Unknown", From: start, To: start})
+		assert.Error(t, err)
+	})
+}
+
+func TestHistory_Retention(t *testing.T) {
+	h := NewHistory(time.Minute)
+	now := time.Now()
+
+	h.Append(Metrics{ID: "g", MType: "gauge", Value: float64Ptr(1)}, now.Add(-2*time.Minute))
+	h.Append(Metrics{ID: "g", MType: "gauge", Value: float64Ptr(2)}, now.Add(-30*time.Second))
+	h.Append(Metrics{ID: "g", MType: "gauge", Value: float64Ptr(3)}, now)
+
+	// Значения старше срока хранения удаляются при добавлении новых
+	assert.Len(t, h.series[seriesKey{MType: "gauge", ID: "g"}], 2)
+
+	series, err := h.QueryRange(RangeQuery{ID: "g", From: now.Add(-time.Hour), To: now})
+	require.NoError(t, err)
+	assert.Equal(t, []Sample{
+		{Timestamp: now.Add(-30 * time.Second), Value: 2},
+		{Timestamp: now, Value: 3},
+	}, series.Points)
+}
+
+func TestMetricsStorage_History(t *testing.T) {
+	s := TestMetricStorage()
+
+	require.NoError(t, s.UpdateMetricValue(Metrics{ID: "c", MType: "counter", Delta: int64Ptr(2)}))
+	require.NoError(t, s.UpdateMetricsValue([]Metrics{
+		{ID: "c", MType: "counter", Delta: int64Ptr(3)},
+		{ID: "c", MType: "counter", Delta: int64Ptr(4)},
+		{ID: "g", MType: "gauge", Value: float64Ptr(1)},
+		{ID: "g", MType: "gauge", Value: float64Ptr(2)},
+	}))
+
+	// В историю counter попадает накопленное значение, по одному на обновление
+	series, err := s.QueryRange(RangeQuery{ID: "c", MType: "counter", From: time.Now().Add(-time.Minute), To: time.Now()})
+	require.NoError(t, err)
+	require.Len(t, series.Points, 2)
+	assert.Equal(t, float64(2), series.Points[0].Value)
+	assert.Equal(t, float64(9), series.Points[1].Value)
+
+	// Из пакета в историю gauge попадает последнее значение
+	series, err = s.QueryRange(RangeQuery{ID: "g", From: time.Now().Add(-time.Minute), To: time.Now()})
+	require.NoError(t, err)
+	require.Len(t, series.Points, 1)
+	assert.Equal(t, float64(2), series.Points[0].Value)
+
+	_, err = (&MetricsStorage{Backend: NewMemoryStorage()}).QueryRange(RangeQuery{ID: "c"})
+	assert.ErrorIs(t, err, ErrHistoryDisabled)
+}
+
+func TestMetricsStorage_UpdateMetricsValueAt(t *testing.T) {
+	s := TestMetricStorage()
+	now := time.Now()
+	t1, t2 := now.Add(-20*time.Minute), now.Add(-10*time.Minute)
+
+	require.NoError(t, s.UpdateMetricsValueAt([]Metrics{
+		{ID: "g", MType: "gauge", Value: float64Ptr(1)},
+		{ID: "g", MType: "gauge", Value: float64Ptr(2)},
+		{ID: "c", MType: "counter", Delta: int64Ptr(3)},
+		{ID: "future", MType: "gauge", Value: float64Ptr(4)},
+	}, []time.Time{t1, t2, t2, now.Add(time.Hour)}))
+
+	// Каждое значение gauge попадает в историю со своей меткой времени
+	series, err := s.QueryRange(RangeQuery{ID: "g", From: now.Add(-time.Hour), To: now})
+	require.NoError(t, err)
+	assert.Equal(t, []Sample{{Timestamp: t1, Value: 1}, {Timestamp: t2, Value: 2}}, series.Points)
+
+	series, err = s.QueryRange(RangeQuery{ID: "c", MType: "counter", From: now.Add(-time.Hour), To: now})
+	require.NoError(t, err)
+	assert.Equal(t, []Sample{{Timestamp: t2, Value: 3}}, series.Points)
+
+	// Метка из будущего заменяется текущим временем
+	series, err = s.QueryRange(RangeQuery{ID: "future", From: now.Add(-time.Hour), To: time.Now()})
+	require.NoError(t, err)
+	require.Len(t, series.Points, 1)
+	assert.False(t, series.Points[0].Timestamp.After(time.Now()))
+
+	assert.Error(t, s.UpdateMetricsValueAt([]Metrics{{ID: "g", MType: "gauge", Value: float64Ptr(1)}}, nil))
+}
+
+func TestHistory_Compact(t *testing.T) {
+	h := NewHistory(2*time.Hour,
+		TierConfig{Resolution: time.Minute, Retention: 24 * time.Hour},
+		TierConfig{Resolution: time.Hour},
+	)
+	base := time.Now().Truncate(time.Hour).Add(-3 * time.Hour)
+	gauge := seriesKey{MType: "gauge", ID: "g"}
+	counter := seriesKey{MType: "counter", ID: "c"}
+
+	for _, s := range []Sample{{base, 1}, {base.Add(20 * time.Second), 3}, {base.Add(40 * time.Second), 2}, {base.Add(60 * time.Second), 10}, {base.Add(80 * time.Second), 4}} {
+		h.Append(Metrics{ID: "g", MType: "gauge", Value: float64Ptr(s.Value)}, s.Timestamp)
+	}
+	for _, s := range []Sample{{base, 0}, {base.Add(30 * time.Second), 30}, {base.Add(60 * time.Second), 90}, {base.Add(90 * time.Second), 120}} {
+		h.Append(Metrics{ID: "c", MType: "counter", Delta: int64Ptr(int64(s.Value))}, s.Timestamp)
+	}
+
+	// Агрегируются только завершившиеся интервалы
+	h.Compact(base.Add(90 * time.Second))
+	minute, hour := h.tiers[0], h.tiers[1]
+	assert.Equal(t, []Aggregate{{Timestamp: base, Count: 3, Min: 1, Max: 3, Avg: 2, Last: 2, Sum: 6}}, minute.series[gauge])
+	assert.Equal(t, []Aggregate{{Timestamp: base, Count: 2, Min: 0, Max: 30, Avg: 15, Last: 30, Sum: 30, Rate: 0.5}}, minute.series[counter])
+	assert.Empty(t, hour.series)
+
+	// Повторное уплотнение не дублирует агрегаты, прирост counter считается от предыдущего интервала
+	h.Compact(base.Add(2*time.Hour + 30*time.Second))
+	require.Len(t, minute.series[gauge], 2)
+	assert.Equal(t, Aggregate{Timestamp: base.Add(time.Minute), Count: 2, Min: 4, Max: 10, Avg: 7, Last: 4, Sum: 14}, minute.series[gauge][1])
+	require.Len(t, minute.series[counter], 2)
+	assert.Equal(t, 1.5, minute.series[counter][1].Rate)
+
+	assert.Equal(t, []Aggregate{{Timestamp: base, Count: 5, Min: 1, Max: 10, Avg: 4, Last: 4, Sum: 20}}, hour.series[gauge])
+	require.Len(t, hour.series[counter], 1)
+	assert.InDelta(t, 120.0/3600, hour.series[counter][0].Rate, 1e-9)
+
+	// Исходные значения старше срока хранения удалены
+	assert.Len(t, h.series[gauge], 3)
+
+	t.Run("Query Uses Tiers", func(t *testing.T) {
+		series, err := h.QueryRange(RangeQuery{ID: "g", From: base, To: base.Add(10 * time.Minute), Aggregation: "max"})
+		require.NoError(t, err)
+		assert.Equal(t, "1m", series.Resolution)
+		assert.Equal(t, []Sample{{base, 3}, {base.Add(time.Minute), 10}}, series.Points)
+
+		series, err = h.QueryRange(RangeQuery{ID: "c", From: base, To: base.Add(10 * time.Minute), Aggregation: "rate"})
+		require.NoError(t, err)
+		assert.Equal(t, []Sample{{base, 0.5}, {base.Add(time.Minute), 1.5}}, series.Points)
+
+		series, err = h.QueryRange(RangeQuery{ID: "g", From: base, To: base.Add(2 * time.Hour), Step: time.Hour})
+		require.NoError(t, err)
+		assert.Equal(t, "1h", series.Resolution)
+		assert.Equal(t, []Sample{{base, 4}}, series.Points)
+	})
+
+	// Агрегаты старше срока хранения своего уровня удаляются
+	h.Compact(base.Add(30 * time.Hour))
+	assert.Empty(t, minute.series)
+	assert.Empty(t, h.series)
+	assert.Len(t, hour.series[gauge], 1)
+}
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LabelsKey возвращает каноническую запись набора меток вида {a="1",b="2"} с метками,
+// упорядоченными по имени. Пустой набор записывается пустой строкой, поэтому метрики без меток
+// хранятся под прежними ключами.
+func LabelsKey(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name + "=" + strconv.Quote(labels[name]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// SeriesName возвращает ключ ряда метрики: имя, за которым следует каноническая запись меток.
+func SeriesName(id string, labels map[string]string) string {
+	return id + LabelsKey(labels)
+}
+
+// ParseSeriesName разбирает ключ ряда, полученный SeriesName, на имя метрики и метки.
+// Ключ без корректной записи меток целиком считается именем метрики.
+func ParseSeriesName(key string) (string, map[string]string) {
+	i := strings.IndexByte(key, '{')
+	if i < 0 || !strings.HasSuffix(key, "}") {
+		return key, nil
+	}
+	labels, err := parseLabelsKey(key[i:])
+	if err != nil {
+		return key, nil
+	}
+	return key[:i], labels
+}
+
+// parseLabelsKey разбирает каноническую запись набора меток {a="1",b="2"}.
+func parseLabelsKey(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	rest, ok := strings.CutPrefix(s, "{")
+	if !ok {
+		return nil, fmt.Errorf("invalid labels: %q", s)
+	}
+	labels := make(map[string]string)
+	for rest != "}" {
+		name, tail, found := strings.Cut(rest, "=")
+		if !found || !ValidLabelName(name) {
+			return nil, fmt.Errorf("invalid labels: %q", s)
+		}
+		quoted, err := strconv.QuotedPrefix(tail)
+		if err != nil {
+			return nil, fmt.Errorf("invalid labels: %q", s)
+		}
+		labels[name], _ = strconv.Unquote(quoted)
+		rest = tail[len(quoted):]
+		if next, ok := strings.CutPrefix(rest, ","); ok && next != "}" {
+			rest = next
+		} else if rest != "}" {
+			return nil, fmt.Errorf("invalid labels: %q", s)
+		}
+	}
+	return labels, nil
+}
+
+// ValidLabelName проверяет, что имя метки имеет вид [a-zA-Z_][a-zA-Z0-9_]*, как в Prometheus.
+func ValidLabelName(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidID проверяет имя метрики: оно должно быть непустым и не содержать символов '{', '}' и ',',
+// которыми в ключе ряда (SeriesName) записываются метки, иначе ключ разбирался бы в другое имя и метки.
+func ValidID(id string) bool {
+	return id != "" && !strings.ContainsAny(id, "{},")
+}
+
+// ValidLabels проверяет метки метрики: имя каждой метки должно быть допустимым, значение - непустым.
+func ValidLabels(labels map[string]string) bool {
+	for name, value := range labels {
+		if !ValidLabelName(name) || value == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// SanitizeLabels приводит метки из внешних протоколов к допустимому виду: недопустимые символы
+// в именах заменяются на '_', метки с пустым значением отбрасываются.
+func SanitizeLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	sanitized := make(map[string]string, len(labels))
+	for name, value := range labels {
+		if name == "" || value == "" {
+			continue
+		}
+		b := []byte(name)
+		for i, c := range b {
+			if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+				b[i] = '_'
+			}
+		}
+		if b[0] >= '0' && b[0] <= '9' {
+			b = append([]byte{'_'}, b...)
+		}
+		sanitized[string(b)] = value
+	}
+	if len(sanitized) == 0 {
+		return nil
+	}
+	return sanitized
+}
+
+// Matcher описывает условие на значение метки: равенство или, при Negate, неравенство.
+// Отсутствующая метка считается меткой с пустым значением.
+type Matcher struct {
+	Name   string
+	Value  string
+	Negate bool
+}
+
+// ParseMatcher разбирает условие вида name=value или name!=value.
+func ParseMatcher(s string) (Matcher, error) {
+	name, value, found := strings.Cut(s, "=")
+	if !found {
+		return Matcher{}, fmt.Errorf("invalid label matcher: %q", s)
+	}
+	m := Matcher{Name: name, Value: value}
+	if n, ok := strings.CutSuffix(name, "!"); ok {
+		m.Name, m.Negate = n, true
+	}
+	if !ValidLabelName(m.Name) {
+		return Matcher{}, fmt.Errorf("invalid label name: %q", m.Name)
+	}
+	return m, nil
+}
+
+// Match проверяет условие для набора меток labels.
+func (m Matcher) Match(labels map[string]string) bool {
+	return (labels[m.Name] == m.Value) != m.Negate
+}
+
+// MatchLabels проверяет, что набор меток удовлетворяет всем условиям matchers.
+func MatchLabels(labels map[string]string, matchers []Matcher) bool {
+	for _, m := range matchers {
+		if !m.Match(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// SortMetrics упорядочивает метрики по имени, типу и канонической записи меток.
+func SortMetrics(metrics []Metrics) {
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].ID != metrics[j].ID {
+			return metrics[i].ID < metrics[j].ID
+		}
+		if metrics[i].MType != metrics[j].MType {
+			return metrics[i].MType < metrics[j].MType
+		}
+		return LabelsKey(metrics[i].Labels) < LabelsKey(metrics[j].Labels)
+	})
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeriesName(t *testing.T) {
+	assert.Equal(t, "HeapAlloc", SeriesName("HeapAlloc", nil))
+	key := SeriesName("HeapAlloc", map[string]string{"host": "web-1", "agent_id": `a"b,c}`})
+	assert.Equal(t, `HeapAlloc{agent_id="a\"b,c}",host="web-1"}`, key)
+
+	id, labels := ParseSeriesName(key)
+	assert.Equal(t, "HeapAlloc", id)
+	assert.Equal(t, map[string]string{"host": "web-1", "agent_id": `a"b,c}`}, labels)
+
+	// Ключ без корректной записи меток целиком считается именем
+	for _, key := range []string{"HeapAlloc", "a{b}", `a{1="x"}`, `a{b="x",}`} {
+		id, labels := ParseSeriesName(key)
+		assert.Equal(t, key, id)
+		assert.Nil(t, labels)
+	}
+}
+
+func TestValidID(t *testing.T) {
+	assert.True(t, ValidID("HeapAlloc"))
+	assert.True(t, ValidID("http.server.duration"))
+	for _, id := range []string{"", `HeapAlloc{host="web-1"}`, "a}", "a,b"} {
+		assert.False(t, ValidID(id), id)
+	}
+}
+
+func TestParseMatcher(t *testing.T) {
+	m, err := ParseMatcher("host=web-1")
+	require.NoError(t, err)
+	assert.Equal(t, Matcher{Name: "host", Value: "web-1"}, m)
+	assert.True(t, m.Match(map[string]string{"host": "web-1"}))
+	assert.False(t, m.Match(nil))
+
+	m, err = ParseMatcher("env!=prod")
+	require.NoError(t, err)
+	assert.Equal(t, Matcher{Name: "env", Value: "prod", Negate: true}, m)
+	assert.True(t, m.Match(nil))
+	assert.False(t, m.Match(map[string]string{"env": "prod"}))
+
+	// Пустое значение выбирает ряды без метки
+	m, err = ParseMatcher("host=")
+	require.NoError(t, err)
+	assert.True(t, MatchLabels(nil, []Matcher{m}))
+
+	for _, s := range []string{"host", "=x", "1host=x", "a.b=x"} {
+		_, err := ParseMatcher(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestSanitizeLabels(t *testing.T) {
+	assert.Nil(t, SanitizeLabels(nil))
+	assert.Nil(t, SanitizeLabels(map[string]string{"empty": ""}))
+	assert.Equal(t, map[string]string{"service_name": "api", "_1x": "y"},
+		SanitizeLabels(map[string]string{"service.name": "api", "1x": "y", "empty": ""}))
+}
+
+func TestMemoryStorage_Labels(t *testing.T) {
+	s := NewMemoryStorage()
+	web1 := map[string]string{"host": "web-1"}
+	web2 := map[string]string{"host": "web-2"}
+
+	require.NoError(t, s.UpdateMetricsValue([]Metrics{
+		{ID: "HeapAlloc", MType: "gauge", Value: float64Ptr(1)},
+		{ID: "HeapAlloc", MType: "gauge", Value: float64Ptr(2), Labels: web1},
+		{ID: "HeapAlloc", MType: "gauge", Value: float64Ptr(3), Labels: web2},
+		{ID: "PollCount", MType: "counter", Delta: int64Ptr(4), Labels: web1},
+		{ID: "PollCount", MType: "counter", Delta: int64Ptr(5), Labels: web1},
+	}))
+
+	value, err := s.GetMetricByName(Metrics{ID: "HeapAlloc", MType: "gauge"})
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), value)
+	stored, err := s.GetMetric(Metrics{ID: "HeapAlloc", Labels: web2})
+	require.NoError(t, err)
+	assert.Equal(t, Metrics{ID: "HeapAlloc", MType: "gauge", Value: float64Ptr(3), Labels: web2}, stored)
+	_, err = s.GetMetric(Metrics{ID: "PollCount", MType: "counter"})
+	assert.Error(t, err)
+
+	metrics, err := s.ListMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, []Metrics{
+		{ID: "HeapAlloc", MType: "gauge", Value: float64Ptr(1)},
+		{ID: "HeapAlloc", MType: "gauge", Value: float64Ptr(2), Labels: web1},
+		{ID: "HeapAlloc", MType: "gauge", Value: float64Ptr(3), Labels: web2},
+		{ID: "PollCount", MType: "counter", Delta: int64Ptr(9), Labels: web1},
+	}, metrics)
+	assert.Equal(t, "HeapAlloc/1\nHeapAlloc{host=\"web-1\"}/2\nHeapAlloc{host=\"web-2\"}/3\nPollCount{host=\"web-1\"}/9\n", s.GetAllMetrics())
+}
+
+func TestMetricsStorage_LabelsHistory(t *testing.T) {
+	s := TestMetricStorage()
+	web1 := map[string]string{"host": "web-1"}
+	require.NoError(t, s.UpdateMetricsValue([]Metrics{
+		{ID: "HeapAlloc", MType: "gauge", Value: float64Ptr(1)},
+		{ID: "HeapAlloc", MType: "gauge", Value: float64Ptr(2), Labels: web1},
+	}))
+
+	series, err := s.QueryRange(RangeQuery{ID: "HeapAlloc", Labels: web1, To: time.Now().Add(time.Second)})
+	require.NoError(t, err)
+	assert.Equal(t, web1, series.Labels)
+	require.Len(t, series.Points, 1)
+	assert.Equal(t, float64(2), series.Points[0].Value)
+}
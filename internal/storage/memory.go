@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// errDBNotConfigured возвращается при проверке подключения к базе данных у бэкендов без базы.
+var errDBNotConfigured = errors.New("database is not configured")
+
+// MemoryStorage хранит метрики в оперативной памяти: gauge, counter, histogram и summary в раздельных пространствах имён.
+// Ключом служит имя ряда (см. SeriesName), поэтому метрики без меток хранятся под своими именами.
+type MemoryStorage struct {
+	Mu         sync.RWMutex
+	Gauges     map[string]float64
+	Counters   map[string]int64
+	Histograms map[string]*Histogram
+	Summaries  map[string]*Summary
+}
+
+// NewMemoryStorage создает новый экземпляр MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		Gauges:     make(map[string]float64),
+		Counters:   make(map[string]int64),
+		Histograms: make(map[string]*Histogram),
+		Summaries:  make(map[string]*Summary),
+	}
+}
+
+// UpdateMetricValue обновляет значение метрики в хранилище.
+func (s *MemoryStorage) UpdateMetricValue(m Metrics) error {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	switch m.MType {
+	case "counter":
+		if m.Delta == nil {
+			v := int64(1)
+			m.Delta = &v
+		}
+		s.Counters[SeriesName(m.ID, m.Labels)] += *m.Delta
+	case "gauge":
+		if m.Value == nil {
+			return fmt.Errorf("empty value for gauge: %v", m.ID)
+		}
+		s.Gauges[SeriesName(m.ID, m.Labels)] = *m.Value
+	case "histogram":
+		return s.mergeHistogram(m)
+	case "summary":
+		return s.setSummary(m)
+	default:
+		return fmt.Errorf("unknown metric type: %v", m.MType)
+	}
+	return nil
+}
+
+// mergeHistogram прибавляет значения гистограммы m к хранимой. Вызывается под блокировкой на запись.
+func (s *MemoryStorage) mergeHistogram(m Metrics) error {
+	if m.Histogram == nil {
+		return fmt.Errorf("empty histogram: %v", m.ID)
+	}
+	if err := m.Histogram.Validate(); err != nil {
+		return fmt.Errorf("%v: %w", m.ID, err)
+	}
+	if s.Histograms == nil {
+		s.Histograms = make(map[string]*Histogram)
+	}
+	key := SeriesName(m.ID, m.Labels)
+	stored, exists := s.Histograms[key]
+	if !exists {
+		s.Histograms[key] = m.Histogram.Clone()
+		return nil
+	}
+	if err := stored.Merge(m.Histogram); err != nil {
+		return fmt.Errorf("%v: %w", m.ID, err)
+	}
+	return nil
+}
+
+// setSummary заменяет хранимую сводку сводкой m. Вызывается под блокировкой на запись.
+func (s *MemoryStorage) setSummary(m Metrics) error {
+	if m.Summary == nil {
+		return fmt.Errorf("empty summary: %v", m.ID)
+	}
+	if err := m.Summary.Validate(); err != nil {
+		return fmt.Errorf("%v: %w", m.ID, err)
+	}
+	if s.Summaries == nil {
+		s.Summaries = make(map[string]*Summary)
+	}
+	s.Summaries[SeriesName(m.ID, m.Labels)] = m.Summary.Clone()
+	return nil
+}
+
+// UpdateMetricsValue обновляет значения нескольких метрик в хранилище.
+func (s *MemoryStorage) UpdateMetricsValue(metrics []Metrics) error {
+	var err error
+	for _, m := range metrics {
+		err = errors.Join(err, s.UpdateMetricValue(m))
+	}
+	return err
+}
+
+// GetMetric получает метрику по имени, меткам и типу с сохранением её типа значения.
+// Если тип не указан, сначала ищется gauge, затем counter, histogram и summary.
+func (s *MemoryStorage) GetMetric(m Metrics) (Metrics, error) {
+	s.Mu.RLock()
+	defer s.Mu.RUnlock()
+
+	key := SeriesName(m.ID, m.Labels)
+	if m.MType == "" || m.MType == "gauge" {
+		if value, exists := s.Gauges[key]; exists {
+			return Metrics{ID: m.ID, MType: "gauge", Value: &value, Labels: m.Labels}, nil
+		}
+	}
+	if m.MType == "" || m.MType == "counter" {
+		if delta, exists := s.Counters[key]; exists {
+			return Metrics{ID: m.ID, MType: "counter", Delta: &delta, Labels: m.Labels}, nil
+		}
+	}
+	if m.MType == "" || m.MType == "histogram" {
+		if h, exists := s.Histograms[key]; exists {
+			return Metrics{ID: m.ID, MType: "histogram", Histogram: h.Clone(), Labels: m.Labels}, nil
+		}
+	}
+	if m.MType == "" || m.MType == "summary" {
+		if sum, exists := s.Summaries[key]; exists {
+			return Metrics{ID: m.ID, MType: "summary", Summary: sum.Clone(), Labels: m.Labels}, nil
+		}
+	}
+	return Metrics{}, fmt.Errorf("undefind metricName: %v", m.ID)
+}
+
+// GetMetricByName получает значение метрики по её имени и типу.
+func (s *MemoryStorage) GetMetricByName(m Metrics) (float64, error) {
+	stored, err := s.GetMetric(m)
+	if err != nil {
+		return 0, err
+	}
+	return stored.Float64(), nil
+}
+
+// ListMetrics возвращает все метрики хранилища, упорядоченные по имени, типу и меткам.
+func (s *MemoryStorage) ListMetrics() ([]Metrics, error) {
+	s.Mu.RLock()
+	defer s.Mu.RUnlock()
+
+	metrics := make([]Metrics, 0, len(s.Gauges)+len(s.Counters)+len(s.Histograms)+len(s.Summaries))
+	for key, delta := range s.Counters {
+		delta := delta
+		id, labels := ParseSeriesName(key)
+		metrics = append(metrics, Metrics{ID: id, MType: "counter", Delta: &delta, Labels: labels})
+	}
+	for key, value := range s.Gauges {
+		value := value
+		id, labels := ParseSeriesName(key)
+		metrics = append(metrics, Metrics{ID: id, MType: "gauge", Value: &value, Labels: labels})
+	}
+	for key, h := range s.Histograms {
+		id, labels := ParseSeriesName(key)
+		metrics = append(metrics, Metrics{ID: id, MType: "histogram", Histogram: h.Clone(), Labels: labels})
+	}
+	for key, sum := range s.Summaries {
+		id, labels := ParseSeriesName(key)
+		metrics = append(metrics, Metrics{ID: id, MType: "summary", Summary: sum.Clone(), Labels: labels})
+	}
+	SortMetrics(metrics)
+	return metrics, nil
+}
+
+// SortMetricByName сортирует названия метрик по алфавиту.
+func (s *MemoryStorage) SortMetricByName() []string {
+	var keys []string
+	s.Mu.RLock()
+	for key := range s.Gauges {
+		keys = append(keys, key)
+	}
+	for key := range s.Counters {
+		if _, exists := s.Gauges[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	for key := range s.Histograms {
+		_, gauge := s.Gauges[key]
+		_, counter := s.Counters[key]
+		if !gauge && !counter {
+			keys = append(keys, key)
+		}
+	}
+	for key := range s.Summaries {
+		_, gauge := s.Gauges[key]
+		_, counter := s.Counters[key]
+		_, histogram := s.Histograms[key]
+		if !gauge && !counter && !histogram {
+			keys = append(keys, key)
+		}
+	}
+	s.Mu.RUnlock()
+	sort.Strings(keys)
+	return keys
+}
+
+// GetAllMetrics возвращает все метрики в виде строки.
+// Для имени, занятого метриками нескольких типов, выводятся counter, gauge, histogram, затем summary.
+func (s *MemoryStorage) GetAllMetrics() string {
+	keys := s.SortMetricByName()
+	var result string
+	s.Mu.RLock()
+	for _, key := range keys {
+		if delta, exists := s.Counters[key]; exists {
+			result += fmt.Sprintf("%v/%v\n", key, delta)
+		}
+		if value, exists := s.Gauges[key]; exists {
+			result += fmt.Sprintf("%v/%v\n", key, value)
+		}
+		if h, exists := s.Histograms[key]; exists {
+			result += fmt.Sprintf("%v/%v\n", key, h)
+		}
+		if sum, exists := s.Summaries[key]; exists {
+			result += fmt.Sprintf("%v/%v\n", key, sum)
+		}
+	}
+	s.Mu.RUnlock()
+	return result
+}
+
+// PingDB возвращает ошибку, так как хранилище в памяти не использует базу данных.
+func (s *MemoryStorage) PingDB() error {
+	return errDBNotConfigured
+}
+
+// Shutdown ничего не делает: хранилищу в памяти нечего сохранять.
+func (s *MemoryStorage) Shutdown() {}
+
+// ForTenant возвращает пустое хранилище в памяти для арендатора.
+func (s *MemoryStorage) ForTenant(tenant string) Backend {
+	return NewMemoryStorage()
+}
+
+// snapshot представляет собой сохраняемое на диск состояние хранилища.
+// Seq - номер последней записи журнала предзаписи, учтённой в снимке.
+type snapshot struct {
+	Seq        uint64                `json:"seq,omitempty"`
+	Gauges     map[string]float64    `json:"gauges"`
+	Counters   map[string]int64      `json:"counters"`
+	Histograms map[string]*Histogram `json:"histograms,omitempty"`
+	Summaries  map[string]*Summary   `json:"summaries,omitempty"`
+}
+
+// snapshot возвращает копию текущего состояния хранилища.
+func (s *MemoryStorage) snapshot() snapshot {
+	s.Mu.RLock()
+	defer s.Mu.RUnlock()
+
+	snap := snapshot{
+		Gauges:   make(map[string]float64, len(s.Gauges)),
+		Counters: make(map[string]int64, len(s.Counters)),
+	}
+	for key, value := range s.Gauges {
+		snap.Gauges[key] = value
+	}
+	for key, delta := range s.Counters {
+		snap.Counters[key] = delta
+	}
+	if len(s.Histograms) > 0 {
+		snap.Histograms = make(map[string]*Histogram, len(s.Histograms))
+		for key, h := range s.Histograms {
+			snap.Histograms[key] = h.Clone()
+		}
+	}
+	if len(s.Summaries) > 0 {
+		snap.Summaries = make(map[string]*Summary, len(s.Summaries))
+		for key, sum := range s.Summaries {
+			snap.Summaries[key] = sum.Clone()
+		}
+	}
+	return snap
+}
+
+// restore заменяет состояние хранилища сохранённым снимком.
+func (s *MemoryStorage) restore(snap snapshot) {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	s.Gauges = make(map[string]float64, len(snap.Gauges))
+	s.Counters = make(map[string]int64, len(snap.Counters))
+	s.Histograms = make(map[string]*Histogram, len(snap.Histograms))
+	s.Summaries = make(map[string]*Summary, len(snap.Summaries))
+	for key, value := range snap.Gauges {
+		s.Gauges[key] = value
+	}
+	for key, delta := range snap.Counters {
+		s.Counters[key] = delta
+	}
+	for key, h := range snap.Histograms {
+		if h != nil {
+			s.Histograms[key] = h.Clone()
+		}
+	}
+	for key, sum := range snap.Summaries {
+		if sum != nil {
+			s.Summaries[key] = sum.Clone()
+		}
+	}
+}
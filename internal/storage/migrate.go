@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// migrationLockID - ключ advisory-блокировки, чтобы несколько серверов не применяли миграции одновременно.
+const migrationLockID = 7_305_482_104
+
+// migration представляет собой одну версию схемы базы данных.
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+// loadMigrations считывает встроенные в бинарный файл миграции вида NNNN_name.up.sql и NNNN_name.down.sql,
+// упорядоченные по версии.
+func loadMigrations() ([]migration, error) {
+	files, err := fs.Glob(migrationsFS, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*migration)
+	for _, file := range files {
+		base := strings.TrimPrefix(file, "migrations/")
+		prefix, rest, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name: %v", base)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %v: %w", base, err)
+		}
+
+		body, err := migrationsFS.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &migration{version: version}
+			byVersion[version] = m
+		}
+		switch {
+		case strings.HasSuffix(rest, ".up.sql"):
+			m.name = strings.TrimSuffix(rest, ".up.sql")
+			m.up = string(body)
+		case strings.HasSuffix(rest, ".down.sql"):
+			m.down = string(body)
+		default:
+			return nil, fmt.Errorf("migration %v must end with .up.sql or .down.sql", base)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %d must have both up and down files", m.version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	return migrations, nil
+}
+
+// beginMigration открывает транзакцию, блокирует миграции в других процессах
+// и возвращает текущую версию схемы.
+func beginMigration(ctx context.Context, db *sql.DB) (*sql.Tx, int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", migrationLockID); err != nil {
+		tx.Rollback()
+		return nil, 0, err
+	}
+	_, err = tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_version (
+            version integer PRIMARY KEY,
+            applied_at timestamptz NOT NULL DEFAULT now()
+        )`)
+	if err != nil {
+		tx.Rollback()
+		return nil, 0, err
+	}
+
+	var version int
+	err = tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	if err != nil {
+		tx.Rollback()
+		return nil, 0, err
+	}
+	return tx, version, nil
+}
+
+// MigrateUp применяет к базе данных все ещё не применённые миграции.
+// Миграции выполняются в одной транзакции и никогда не удаляют существующие данные при старте.
+func MigrateUp(ctx context.Context, db *sql.DB) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	tx, current, err := beginMigration(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, m.up); err != nil {
+			return fmt.Errorf("apply migration %d_%v: %w", m.version, m.name, err)
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO schema_version (version) VALUES ($1)", m.version); err != nil {
+			return err
+		}
+		fmt.Printf("Применена миграция %d_%v\n", m.version, m.name)
+	}
+	return tx.Commit()
+}
+
+// MigrateDown откатывает миграции, пока версия схемы не станет равной target.
+func MigrateDown(ctx context.Context, db *sql.DB, target int) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	tx, current, err := beginMigration(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.version <= target || m.version > current {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, m.down); err != nil {
+			return fmt.Errorf("revert migration %d_%v: %w", m.version, m.name, err)
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM schema_version WHERE version = $1", m.version); err != nil {
+			return err
+		}
+		fmt.Printf("Откачена миграция %d_%v\n", m.version, m.name)
+	}
+	return tx.Commit()
+}
+
+// SchemaVersion возвращает текущую версию схемы базы данных.
+func SchemaVersion(ctx context.Context, db *sql.DB) (int, error) {
+	tx, version, err := beginMigration(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	return version, tx.Commit()
+}
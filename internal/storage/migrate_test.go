@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	// Версии идут по порядку, у каждой миграции есть up и down
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.version)
+		assert.NotEmpty(t, m.name)
+		assert.NotEmpty(t, m.up)
+		assert.NotEmpty(t, m.down)
+	}
+
+	// Миграции не должны удалять таблицу метрик при применении
+	assert.NotContains(t, migrations[0].up, "DROP TABLE IF EXISTS metrics;")
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	s := newTestPostgresStorage(t)
+	ctx := context.Background()
+
+	migrations, err := loadMigrations()
+	require.NoError(t, err)
+	latest := migrations[len(migrations)-1].version
+
+	require.NoError(t, s.UpdateMetricValue(Metrics{ID: "kept", MType: "gauge", Value: float64Ptr(1)}))
+
+	// Повторное применение миграций не трогает данные
+	require.NoError(t, MigrateUp(ctx, s.DB))
+	version, err := SchemaVersion(ctx, s.DB)
+	require.NoError(t, err)
+	assert.Equal(t, latest, version)
+
+	value, err := s.GetMetricByName(Metrics{ID: "kept", MType: "gauge"})
+	assert.NoError(t, err)
+	assert.Equal(t, float64(1), value)
+
+	require.NoError(t, MigrateDown(ctx, s.DB, 0))
+	version, err = SchemaVersion(ctx, s.DB)
+	require.NoError(t, err)
+	assert.Equal(t, 0, version)
+
+	require.NoError(t, MigrateUp(ctx, s.DB))
+}
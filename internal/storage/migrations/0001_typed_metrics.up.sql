@@ -0,0 +1,35 @@
+-- Прежние версии сервера хранили метрики в таблице metrics в виде jsonb.
+-- Переименовываем её, чтобы перенести данные в типизированную таблицу.
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'metrics' AND column_name = 'metric_data'
+    ) THEN
+        ALTER TABLE metrics RENAME TO metrics_legacy;
+    END IF;
+END $$;
+
+CREATE TABLE IF NOT EXISTS metrics (
+    type  text             NOT NULL CHECK (type IN ('gauge', 'counter')),
+    name  text             NOT NULL,
+    delta bigint,
+    value double precision,
+    PRIMARY KEY (type, name)
+);
+
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.tables
+        WHERE table_schema = current_schema() AND table_name = 'metrics_legacy'
+    ) THEN
+        INSERT INTO metrics (type, name, delta, value)
+        SELECT metric_data->>'type', name, (metric_data->>'delta')::bigint, (metric_data->>'value')::double precision
+        FROM metrics_legacy
+        WHERE metric_data->>'type' IN ('gauge', 'counter')
+        ON CONFLICT (type, name) DO NOTHING;
+
+        DROP TABLE metrics_legacy;
+    END IF;
+END $$;
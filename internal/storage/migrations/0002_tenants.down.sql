@@ -0,0 +1,6 @@
+-- Метрики арендаторов, кроме арендатора по умолчанию, при откате удаляются.
+DELETE FROM metrics WHERE tenant <> '';
+
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_pkey;
+ALTER TABLE metrics ADD PRIMARY KEY (type, name);
+ALTER TABLE metrics DROP COLUMN IF EXISTS tenant;
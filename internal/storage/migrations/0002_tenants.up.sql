@@ -0,0 +1,6 @@
+-- Метрики арендаторов хранятся в общей таблице и различаются столбцом tenant.
+-- Существующие метрики относятся к арендатору по умолчанию с пустым именем.
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS tenant text NOT NULL DEFAULT '';
+
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_pkey;
+ALTER TABLE metrics ADD PRIMARY KEY (tenant, type, name);
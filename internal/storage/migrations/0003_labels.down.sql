@@ -0,0 +1,6 @@
+-- Ряды с метками при откате удаляются.
+DELETE FROM metrics WHERE labels <> '';
+
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_pkey;
+ALTER TABLE metrics ADD PRIMARY KEY (tenant, type, name);
+ALTER TABLE metrics DROP COLUMN IF EXISTS labels;
@@ -0,0 +1,5 @@
+-- Метки ряда хранятся в канонической записи {a="1",b="2"}; у метрик без меток запись пустая.
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS labels text NOT NULL DEFAULT '';
+
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_pkey;
+ALTER TABLE metrics ADD PRIMARY KEY (tenant, type, name, labels);
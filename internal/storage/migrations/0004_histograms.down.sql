@@ -0,0 +1,6 @@
+-- Гистограммы при откате удаляются.
+DELETE FROM metrics WHERE type = 'histogram';
+
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_type_check;
+ALTER TABLE metrics ADD CONSTRAINT metrics_type_check CHECK (type IN ('gauge', 'counter'));
+ALTER TABLE metrics DROP COLUMN IF EXISTS histogram;
@@ -0,0 +1,5 @@
+-- Гистограммы хранятся в столбце histogram в виде JSON с границами корзин, числами значений и суммой.
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS histogram jsonb;
+
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_type_check;
+ALTER TABLE metrics ADD CONSTRAINT metrics_type_check CHECK (type IN ('gauge', 'counter', 'histogram'));
@@ -0,0 +1,5 @@
+-- Сводки хранятся в столбце summary в виде JSON с квантилями, числом и суммой значений.
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS summary jsonb;
+
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_type_check;
+ALTER TABLE metrics ADD CONSTRAINT metrics_type_check CHECK (type IN ('gauge', 'counter', 'histogram', 'summary'));
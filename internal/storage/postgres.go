@@ -0,0 +1,357 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// execer описывает общие методы *sql.DB и *sql.Tx для выполнения запросов.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// PostgresStorage хранит метрики в базе данных PostgreSQL.
+// Метрики арендаторов хранятся в той же таблице и различаются столбцом tenant,
+// метки ряда хранятся в столбце labels в канонической записи LabelsKey, гистограммы - в столбце histogram,
+// сводки - в столбце summary.
+type PostgresStorage struct {
+	DB     *sql.DB
+	tenant string
+}
+
+// NewPostgresStorage создает новый экземпляр PostgresStorage и применяет миграции схемы.
+func NewPostgresStorage(c *Config) *PostgresStorage {
+	db, err := sql.Open("pgx", c.DatabaseDSN)
+	if err != nil {
+		panic(err)
+	}
+	db.SetMaxOpenConns(c.MaxConnections)
+
+	if err := MigrateUp(context.Background(), db); err != nil {
+		panic(err)
+	}
+	return &PostgresStorage{DB: db}
+}
+
+// UpdateMetricValue обновляет значение метрики в базе данных.
+// Гистограмма обновляется в транзакции, так как требует чтения хранимого значения.
+func (s *PostgresStorage) UpdateMetricValue(m Metrics) error {
+	if m.MType == "histogram" {
+		return s.UpdateMetricsValue([]Metrics{m})
+	}
+	return upsertMetric(context.Background(), s.DB, s.tenant, m)
+}
+
+// UpdateMetricsValue обновляет значения нескольких метрик в одной транзакции.
+func (s *PostgresStorage) UpdateMetricsValue(metrics []Metrics) error {
+	ctx := context.Background()
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, m := range metrics {
+		if err := upsertMetric(ctx, tx, s.tenant, m); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// upsertMetric вставляет метрику арендатора tenant или обновляет существующую: счетчик увеличивается на Delta,
+// gauge и сводка заменяются, к гистограмме прибавляются значения.
+func upsertMetric(ctx context.Context, db execer, tenant string, m Metrics) error {
+	switch m.MType {
+	case "histogram":
+		return upsertHistogram(ctx, db, tenant, m)
+	case "summary":
+		return upsertSummary(ctx, db, tenant, m)
+	}
+	if m.MType == "counter" {
+		delta := int64(1)
+		if m.Delta != nil {
+			delta = *m.Delta
+		}
+		_, err := db.ExecContext(ctx, `
+			INSERT INTO metrics (tenant, type, name, labels, delta)
+			VALUES ($1, 'counter', $2, $3, $4)
+			ON CONFLICT (tenant, type, name, labels) DO UPDATE
+			SET delta = metrics.delta + EXCLUDED.delta
+		`, tenant, m.ID, LabelsKey(m.Labels), delta)
+		return err
+	}
+
+	if m.Value == nil {
+		return fmt.Errorf("empty value for gauge: %v", m.ID)
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO metrics (tenant, type, name, labels, value)
+		VALUES ($1, 'gauge', $2, $3, $4)
+		ON CONFLICT (tenant, type, name, labels) DO UPDATE
+		SET value = EXCLUDED.value
+	`, tenant, m.ID, LabelsKey(m.Labels), *m.Value)
+	return err
+}
+
+// upsertHistogram вставляет гистограмму или прибавляет её значения к хранимой.
+// Хранимая гистограмма блокируется до конца транзакции, поэтому db должна быть транзакцией.
+func upsertHistogram(ctx context.Context, db execer, tenant string, m Metrics) error {
+	if m.Histogram == nil {
+		return fmt.Errorf("empty histogram: %v", m.ID)
+	}
+	if err := m.Histogram.Validate(); err != nil {
+		return fmt.Errorf("%v: %w", m.ID, err)
+	}
+	data, err := json.Marshal(m.Histogram)
+	if err != nil {
+		return err
+	}
+
+	labels := LabelsKey(m.Labels)
+	var inserted int
+	err = db.QueryRowContext(ctx, `
+		INSERT INTO metrics (tenant, type, name, labels, histogram)
+		VALUES ($1, 'histogram', $2, $3, $4)
+		ON CONFLICT (tenant, type, name, labels) DO NOTHING
+		RETURNING 1
+	`, tenant, m.ID, labels, data).Scan(&inserted)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	var stored []byte
+	err = db.QueryRowContext(ctx, `
+		SELECT histogram FROM metrics
+		WHERE tenant = $1 AND type = 'histogram' AND name = $2 AND labels = $3
+		FOR UPDATE
+	`, tenant, m.ID, labels).Scan(&stored)
+	if err != nil {
+		return err
+	}
+	var h Histogram
+	if err := json.Unmarshal(stored, &h); err != nil {
+		return err
+	}
+	if err := h.Merge(m.Histogram); err != nil {
+		return fmt.Errorf("%v: %w", m.ID, err)
+	}
+	if data, err = json.Marshal(&h); err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
+		UPDATE metrics SET histogram = $4
+		WHERE tenant = $1 AND type = 'histogram' AND name = $2 AND labels = $3
+	`, tenant, m.ID, labels, data)
+	return err
+}
+
+// upsertSummary вставляет сводку или заменяет хранимую.
+func upsertSummary(ctx context.Context, db execer, tenant string, m Metrics) error {
+	if m.Summary == nil {
+		return fmt.Errorf("empty summary: %v", m.ID)
+	}
+	if err := m.Summary.Validate(); err != nil {
+		return fmt.Errorf("%v: %w", m.ID, err)
+	}
+	data, err := json.Marshal(m.Summary)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO metrics (tenant, type, name, labels, summary)
+		VALUES ($1, 'summary', $2, $3, $4)
+		ON CONFLICT (tenant, type, name, labels) DO UPDATE
+		SET summary = EXCLUDED.summary
+	`, tenant, m.ID, LabelsKey(m.Labels), data)
+	return err
+}
+
+// scanMetric заполняет значение метрики типа m.MType из столбцов delta, value, histogram и summary строки таблицы metrics.
+func scanMetric(m *Metrics, delta sql.NullInt64, value sql.NullFloat64, histogram, summary []byte) error {
+	switch m.MType {
+	case "counter":
+		m.Delta = &delta.Int64
+	case "histogram":
+		m.Histogram = &Histogram{}
+		return json.Unmarshal(histogram, m.Histogram)
+	case "summary":
+		m.Summary = &Summary{}
+		return json.Unmarshal(summary, m.Summary)
+	default:
+		m.Value = &value.Float64
+	}
+	return nil
+}
+
+// GetMetric получает метрику по имени, меткам и, если он указан, типу из базы данных.
+func (s *PostgresStorage) GetMetric(m Metrics) (Metrics, error) {
+	var (
+		mType     string
+		delta     sql.NullInt64
+		value     sql.NullFloat64
+		histogram []byte
+		summary   []byte
+	)
+	err := s.DB.QueryRowContext(context.Background(), `
+		SELECT type, delta, value, histogram, summary FROM metrics
+		WHERE tenant = $1 AND name = $2 AND labels = $3 AND ($4 = '' OR type = $4)
+		ORDER BY `+typeOrder+`
+		LIMIT 1
+	`, s.tenant, m.ID, LabelsKey(m.Labels), m.MType).Scan(&mType, &delta, &value, &histogram, &summary)
+	if err != nil {
+		return Metrics{}, fmt.Errorf("undefind metricName: %v", m.ID)
+	}
+
+	stored := Metrics{ID: m.ID, MType: mType, Labels: m.Labels}
+	if err := scanMetric(&stored, delta, value, histogram, summary); err != nil {
+		return Metrics{}, err
+	}
+	return stored, nil
+}
+
+// GetMetricByName получает значение метрики по её имени и, если он указан, типу из базы данных.
+func (s *PostgresStorage) GetMetricByName(m Metrics) (float64, error) {
+	stored, err := s.GetMetric(m)
+	if err != nil {
+		return 0, err
+	}
+	return stored.Float64(), nil
+}
+
+// ListMetrics возвращает все метрики из базы данных, упорядоченные по имени, типу и меткам.
+func (s *PostgresStorage) ListMetrics() ([]Metrics, error) {
+	rows, err := s.DB.QueryContext(context.Background(),
+		"SELECT type, name, labels, delta, value, histogram, summary FROM metrics WHERE tenant = $1 ORDER BY name, type, labels", s.tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metrics []Metrics
+	for rows.Next() {
+		var (
+			m         Metrics
+			labels    string
+			delta     sql.NullInt64
+			value     sql.NullFloat64
+			histogram []byte
+			summary   []byte
+		)
+		if err := rows.Scan(&m.MType, &m.ID, &labels, &delta, &value, &histogram, &summary); err != nil {
+			return nil, err
+		}
+		if m.Labels, err = parseLabelsKey(labels); err != nil {
+			return nil, err
+		}
+		if err := scanMetric(&m, delta, value, histogram, summary); err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, rows.Err()
+}
+
+// typeOrder задает порядок поиска метрики без указанного типа, как в MemoryStorage: gauge, counter, histogram, summary.
+const typeOrder = "CASE type WHEN 'gauge' THEN 0 WHEN 'counter' THEN 1 WHEN 'histogram' THEN 2 ELSE 3 END"
+
+// SortMetricByName возвращает имена рядов метрик из базы данных в алфавитном порядке.
+func (s *PostgresStorage) SortMetricByName() []string {
+	rows, err := s.DB.QueryContext(context.Background(),
+		"SELECT DISTINCT name || labels AS series FROM metrics WHERE tenant = $1 ORDER BY series", s.tenant)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			fmt.Println(err)
+			return keys
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		fmt.Println(err)
+	}
+	return keys
+}
+
+// GetAllMetrics возвращает все метрики из базы данных в виде строки.
+func (s *PostgresStorage) GetAllMetrics() string {
+	rows, err := s.DB.QueryContext(context.Background(),
+		"SELECT type, name || labels AS series, delta, value, histogram, summary FROM metrics WHERE tenant = $1 ORDER BY series, type", s.tenant)
+	if err != nil {
+		fmt.Println(err)
+		return ""
+	}
+	defer rows.Close()
+
+	var result string
+	for rows.Next() {
+		var (
+			m         Metrics
+			key       string
+			delta     sql.NullInt64
+			value     sql.NullFloat64
+			histogram []byte
+			summary   []byte
+		)
+		if err := rows.Scan(&m.MType, &key, &delta, &value, &histogram, &summary); err != nil {
+			fmt.Println(err)
+			return result
+		}
+		if err := scanMetric(&m, delta, value, histogram, summary); err != nil {
+			fmt.Println(err)
+			return result
+		}
+		switch {
+		case m.Histogram != nil:
+			result += fmt.Sprintf("%v/%v\n", key, m.Histogram)
+		case m.Summary != nil:
+			result += fmt.Sprintf("%v/%v\n", key, m.Summary)
+		default:
+			result += fmt.Sprintf("%v/%v\n", key, m.Float64())
+		}
+	}
+	if err := rows.Err(); err != nil {
+		fmt.Println(err)
+	}
+	return result
+}
+
+// PingDB проверяет подключение к базе данных.
+func (s *PostgresStorage) PingDB() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return s.DB.PingContext(ctx)
+}
+
+// ForTenant возвращает хранилище арендатора, использующее то же подключение к базе данных.
+func (s *PostgresStorage) ForTenant(tenant string) Backend {
+	return &PostgresStorage{DB: s.DB, tenant: tenant}
+}
+
+// Shutdown закрывает подключение к базе данных.
+// Хранилища арендаторов разделяют подключение основного хранилища и не закрывают его.
+func (s *PostgresStorage) Shutdown() {
+	if s.tenant != "" {
+		return
+	}
+	if err := s.DB.Close(); err != nil {
+		fmt.Println(err)
+	}
+}
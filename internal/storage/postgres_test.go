@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestPostgresStorage создает PostgresStorage для базы из TEST_DATABASE_DSN или пропускает тест.
+func newTestPostgresStorage(t *testing.T) *PostgresStorage {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	s := NewPostgresStorage(&Config{DatabaseDSN: dsn, MaxConnections: 10})
+	t.Cleanup(s.Shutdown)
+
+	_, err := s.DB.Exec("TRUNCATE metrics")
+	require.NoError(t, err)
+	return s
+}
+
+func TestPostgresStorage_UpdateMetricValue(t *testing.T) {
+	s := newTestPostgresStorage(t)
+
+	require.NoError(t, s.PingDB())
+
+	require.NoError(t, s.UpdateMetricValue(Metrics{ID: "gauge1", MType: "gauge", Value: float64Ptr(1.5)}))
+	require.NoError(t, s.UpdateMetricValue(Metrics{ID: "gauge1", MType: "gauge", Value: float64Ptr(2.5)}))
+	require.NoError(t, s.UpdateMetricsValue([]Metrics{
+		{ID: "counter1", MType: "counter", Delta: int64Ptr(2)},
+		{ID: "counter1", MType: "counter", Delta: int64Ptr(3)},
+	}))
+
+	// gauge и counter с одинаковым именем хранятся раздельно
+	require.NoError(t, s.UpdateMetricValue(Metrics{ID: "same", MType: "gauge", Value: float64Ptr(0.5)}))
+	require.NoError(t, s.UpdateMetricValue(Metrics{ID: "same", MType: "counter", Delta: int64Ptr(7)}))
+
+	value, err := s.GetMetricByName(Metrics{ID: "gauge1", MType: "gauge"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2.5, value)
+
+	value, err = s.GetMetricByName(Metrics{ID: "counter1", MType: "counter"})
+	assert.NoError(t, err)
+	assert.Equal(t, float64(5), value)
+
+	value, err = s.GetMetricByName(Metrics{ID: "same", MType: "gauge"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0.5, value)
+
+	value, err = s.GetMetricByName(Metrics{ID: "same", MType: "counter"})
+	assert.NoError(t, err)
+	assert.Equal(t, float64(7), value)
+
+	_, err = s.GetMetricByName(Metrics{ID: "unknown", MType: "gauge"})
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{"counter1", "gauge1", "same"}, s.SortMetricByName())
+	assert.Equal(t, "counter1/5\ngauge1/2.5\nsame/7\nsame/0.5\n", s.GetAllMetrics())
+
+	metrics, err := s.ListMetrics()
+	require.NoError(t, err)
+	require.Len(t, metrics, 4)
+	assert.Equal(t, Metrics{ID: "same", MType: "counter", Delta: int64Ptr(7)}, metrics[2])
+	assert.Equal(t, Metrics{ID: "same", MType: "gauge", Value: float64Ptr(0.5)}, metrics[3])
+}
+
+func TestPostgresStorage_ForTenant(t *testing.T) {
+	s := newTestPostgresStorage(t)
+	teamA := s.ForTenant("team-a")
+	defer teamA.Shutdown()
+
+	require.NoError(t, s.UpdateMetricValue(Metrics{ID: "HeapAlloc", MType: "gauge", Value: float64Ptr(1)}))
+	require.NoError(t, teamA.UpdateMetricsValue([]Metrics{
+		{ID: "HeapAlloc", MType: "gauge", Value: float64Ptr(2)},
+		{ID: "PollCount", MType: "counter", Delta: int64Ptr(3)},
+	}))
+
+	value, err := s.GetMetricByName(Metrics{ID: "HeapAlloc", MType: "gauge"})
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), value)
+	value, err = teamA.GetMetricByName(Metrics{ID: "HeapAlloc", MType: "gauge"})
+	require.NoError(t, err)
+	assert.Equal(t, float64(2), value)
+
+	_, err = s.GetMetric(Metrics{ID: "PollCount", MType: "counter"})
+	assert.Error(t, err)
+	assert.Equal(t, []string{"HeapAlloc"}, s.SortMetricByName())
+	assert.Equal(t, "HeapAlloc/2\nPollCount/3\n", teamA.GetAllMetrics())
+
+	// Хранилище арендатора не закрывает общее подключение
+	teamA.Shutdown()
+	assert.NoError(t, s.PingDB())
+}
+
+func TestPostgresStorage_Labels(t *testing.T) {
+	s := newTestPostgresStorage(t)
+	web1 := map[string]string{"host": "web-1"}
+
+	require.NoError(t, s.UpdateMetricsValue([]Metrics{
+		{ID: "HeapAlloc", MType: "gauge", Value: float64Ptr(1)},
+		{ID: "HeapAlloc", MType: "gauge", Value: float64Ptr(2), Labels: web1},
+		{ID: "PollCount", MType: "counter", Delta: int64Ptr(3), Labels: web1},
+		{ID: "PollCount", MType: "counter", Delta: int64Ptr(4), Labels: web1},
+	}))
+
+	stored, err := s.GetMetric(Metrics{ID: "HeapAlloc", MType: "gauge", Labels: web1})
+	require.NoError(t, err)
+	assert.Equal(t, float64(2), stored.Float64())
+	_, err = s.GetMetric(Metrics{ID: "PollCount", MType: "counter"})
+	assert.Error(t, err)
+
+	metrics, err := s.ListMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, []Metrics{
+		{ID: "HeapAlloc", MType: "gauge", Value: float64Ptr(1)},
+		{ID: "HeapAlloc", MType: "gauge", Value: float64Ptr(2), Labels: web1},
+		{ID: "PollCount", MType: "counter", Delta: int64Ptr(7), Labels: web1},
+	}, metrics)
+	assert.Equal(t, "HeapAlloc/1\nHeapAlloc{host=\"web-1\"}/2\nPollCount{host=\"web-1\"}/7\n", s.GetAllMetrics())
+}
+
+func TestPostgresStorage_Histogram(t *testing.T) {
+	s := newTestPostgresStorage(t)
+	bounds := []float64{0.1, 1}
+
+	require.NoError(t, s.UpdateMetricValue(Metrics{ID: "latency", MType: "histogram",
+		Histogram: &Histogram{Bounds: bounds, Counts: []uint64{1, 1, 0}, Sum: 0.5}}))
+	require.NoError(t, s.UpdateMetricsValue([]Metrics{
+		{ID: "latency", MType: "histogram", Histogram: &Histogram{Bounds: bounds, Counts: []uint64{2, 0, 1}, Sum: 3}},
+		{ID: "latency", MType: "gauge", Value: float64Ptr(1)},
+	}))
+	assert.Error(t, s.UpdateMetricValue(Metrics{ID: "latency", MType: "histogram",
+		Histogram: &Histogram{Bounds: []float64{1}, Counts: []uint64{1, 1}}}))
+
+	stored, err := s.GetMetric(Metrics{ID: "latency", MType: "histogram"})
+	require.NoError(t, err)
+	assert.Equal(t, &Histogram{Bounds: bounds, Counts: []uint64{3, 1, 1}, Sum: 3.5}, stored.Histogram)
+
+	// Без типа, как и в памяти, сначала ищется gauge
+	stored, err = s.GetMetric(Metrics{ID: "latency"})
+	require.NoError(t, err)
+	assert.Equal(t, "gauge", stored.MType)
+
+	metrics, err := s.ListMetrics()
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	assert.Equal(t, "histogram", metrics[1].MType)
+	assert.Equal(t, "latency/1\nlatency/count=5 sum=3.5 p50=0.08333333333333333 p90=1 p99=1\n", s.GetAllMetrics())
+}
+
+func TestPostgresStorage_Summary(t *testing.T) {
+	s := newTestPostgresStorage(t)
+
+	require.NoError(t, s.UpdateMetricValue(Metrics{ID: "latency", MType: "summary",
+		Summary: &Summary{Quantiles: []Quantile{{0.5, 1}}, Count: 4, Sum: 5}}))
+	require.NoError(t, s.UpdateMetricsValue([]Metrics{{ID: "latency", MType: "summary",
+		Summary: &Summary{Quantiles: []Quantile{{0.5, 2}, {0.9, 4}}, Count: 6, Sum: 9}}}))
+	assert.Error(t, s.UpdateMetricValue(Metrics{ID: "latency", MType: "summary",
+		Summary: &Summary{Quantiles: []Quantile{{2, 1}}}}))
+
+	stored, err := s.GetMetric(Metrics{ID: "latency"})
+	require.NoError(t, err)
+	assert.Equal(t, "summary", stored.MType)
+	assert.Equal(t, &Summary{Quantiles: []Quantile{{0.5, 2}, {0.9, 4}}, Count: 6, Sum: 9}, stored.Summary)
+
+	metrics, err := s.ListMetrics()
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	assert.Equal(t, "latency/count=6 sum=9 q0.5=2 q0.9=4\n", s.GetAllMetrics())
+}
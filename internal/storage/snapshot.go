@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// snapshotMagic - префикс заголовка файла снимка.
+// Файлы без заголовка считаются снимками прежнего формата и читаются без проверки контрольной суммы.
+const snapshotMagic = "METRICS-SNAPSHOT"
+
+// snapshotFormatVersion - текущая версия формата файла снимка.
+const snapshotFormatVersion = 2
+
+// errSnapshotChecksum возвращается, если содержимое снимка не совпадает с контрольной суммой из заголовка.
+var errSnapshotChecksum = errors.New("snapshot checksum mismatch")
+
+// encodeSnapshotFile добавляет к телу снимка заголовок с версией формата и контрольной суммой SHA-256.
+func encodeSnapshotFile(body []byte) []byte {
+	sum := sha256.Sum256(body)
+	header := fmt.Sprintf("%s %d sha256:%s\n", snapshotMagic, snapshotFormatVersion, hex.EncodeToString(sum[:]))
+	return append([]byte(header), body...)
+}
+
+// decodeSnapshotFile проверяет заголовок и контрольную сумму файла снимка и разбирает его тело.
+func decodeSnapshotFile(data []byte) (snapshot, error) {
+	if !bytes.HasPrefix(data, []byte(snapshotMagic+" ")) {
+		return decodeSnapshot(data)
+	}
+
+	header, body, found := bytes.Cut(data, []byte("\n"))
+	if !found {
+		return snapshot{}, fmt.Errorf("truncated snapshot header")
+	}
+	fields := bytes.Fields(header)
+	if len(fields) != 3 {
+		return snapshot{}, fmt.Errorf("malformed snapshot header: %q", header)
+	}
+	version, err := strconv.Atoi(string(fields[1]))
+	if err != nil || version != snapshotFormatVersion {
+		return snapshot{}, fmt.Errorf("unsupported snapshot format version: %s", fields[1])
+	}
+	checksum, ok := bytes.CutPrefix(fields[2], []byte("sha256:"))
+	if !ok {
+		return snapshot{}, fmt.Errorf("unsupported snapshot checksum: %s", fields[2])
+	}
+	sum := sha256.Sum256(body)
+	if hex.EncodeToString(sum[:]) != string(checksum) {
+		return snapshot{}, errSnapshotChecksum
+	}
+	return decodeSnapshot(body)
+}
+
+// snapshotPaths возвращает пути к хранимым снимкам от нового к старому: path, path.1, ..., path.<keep-1>.
+func snapshotPaths(path string, keep int) []string {
+	if keep < 1 {
+		keep = 1
+	}
+	paths := []string{path}
+	for i := 1; i < keep; i++ {
+		paths = append(paths, fmt.Sprintf("%s.%d", path, i))
+	}
+	return paths
+}
+
+// writeSnapshotFile атомарно записывает снимок: данные пишутся во временный файл, сбрасываются на диск
+// и переименовываются в path. Прежние снимки сдвигаются, хранится не более keep последних.
+func writeSnapshotFile(path string, data []byte, keep int) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	paths := snapshotPaths(path, keep)
+	for i := len(paths) - 1; i > 0; i-- {
+		if err := os.Rename(paths[i-1], paths[i]); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir сбрасывает на диск содержимое каталога, чтобы переименование файлов пережило сбой.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
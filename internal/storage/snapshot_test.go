@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeSnapshotFile(t *testing.T) {
+	data := encodeSnapshotFile([]byte(`{"seq":3,"gauges":{"gauge1":1.5},"counters":{"counter1":2}}`))
+
+	snap, err := decodeSnapshotFile(data)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), snap.Seq)
+	assert.Equal(t, 1.5, snap.Gauges["gauge1"])
+	assert.Equal(t, int64(2), snap.Counters["counter1"])
+
+	// Оборванный при записи файл не проходит проверку контрольной суммы
+	_, err = decodeSnapshotFile(data[:len(data)-5])
+	assert.ErrorIs(t, err, errSnapshotChecksum)
+
+	// Неизвестная версия формата
+	_, err = decodeSnapshotFile([]byte("METRICS-SNAPSHOT 9 sha256:00\n{}"))
+	assert.Error(t, err)
+
+	// Файлы без заголовка читаются как снимки прежнего формата
+	snap, err = decodeSnapshotFile([]byte(`{"gauges":{"gauge1":2.5},"counters":{}}`))
+	require.NoError(t, err)
+	assert.Equal(t, 2.5, snap.Gauges["gauge1"])
+}
+
+func TestWriteSnapshotFile_KeepsLatest(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "metrics.json")
+
+	for _, body := range []string{"first", "second", "third", "fourth"} {
+		require.NoError(t, writeSnapshotFile(path, []byte(body), 3))
+	}
+
+	for i, want := range []string{"fourth", "third", "second"} {
+		data, err := os.ReadFile(snapshotPaths(path, 3)[i])
+		require.NoError(t, err)
+		assert.Equal(t, want, string(data))
+	}
+
+	// Временные файлы не остаются в каталоге
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+}
+
+func TestFileStorage_RestoreFallback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	storage := NewFileStorage(&Config{FileStoragePath: path, StoreInterval: 300, SnapshotKeep: 3})
+
+	require.NoError(t, storage.UpdateMetricValue(Metrics{ID: "gauge1", MType: "gauge", Value: float64Ptr(1.5)}))
+	require.NoError(t, storage.writeToDisk())
+	require.NoError(t, storage.UpdateMetricValue(Metrics{ID: "gauge1", MType: "gauge", Value: float64Ptr(2.5)}))
+	require.NoError(t, storage.writeToDisk())
+	require.NoError(t, storage.wal.close())
+
+	// Последний снимок оборван при сбое - восстанавливается предыдущий
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data[:len(data)/2], 0644))
+
+	restored := NewFileStorage(&Config{FileStoragePath: path, StoreInterval: 300, SnapshotKeep: 3, RestoreFlag: true})
+	value, err := restored.GetMetricByName(Metrics{ID: "gauge1", MType: "gauge"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1.5, value)
+
+	// Если целых снимков нет, ReadFromDisk сообщает об ошибке
+	for _, p := range snapshotPaths(path, 3) {
+		require.NoError(t, os.WriteFile(p, []byte("METRICS-SNAPSHOT 2 sha256:00\n{}"), 0644))
+	}
+	broken := &FileStorage{MemoryStorage: NewMemoryStorage(), c: &Config{FileStoragePath: path, SnapshotKeep: 3}}
+	assert.Error(t, broken.ReadFromDisk())
+}
@@ -1,407 +1,274 @@
 package storage
 
 import (
-	"context"
-	"database/sql"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"math/rand"
-	"os"
-	"runtime"
-	"sort"
 	"sync"
 	"time"
 
-	"github.com/jackc/pgx/v5"
-	_ "github.com/jackc/pgx/v5/stdlib"
-	"github.com/shirou/gopsutil/cpu"
-	"github.com/shirou/gopsutil/mem"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
-// metricKeys содержит названия метрик, которые будут сохраняться в базе данных.
-var metricKeys = []string{
-	"Alloc",
-	"BuckHashSys",
-	"Frees",
-	"GCCPUFraction",
-	"GCSys",
-	"HeapAlloc",
-	"HeapIdle",
-	"HeapInuse",
-	"HeapObjects",
-	"HeapReleased",
-	"HeapSys",
-	"LastGC",
-	"Lookups",
-	"MCacheInuse",
-	"MCacheSys",
-	"MSpanInuse",
-	"MSpanSys",
-	"Mallocs",
-	"NextGC",
-	"NumForcedGC",
-	"NumGC",
-	"OtherSys",
-	"PauseTotalNs",
-	"StackInuse",
-	"StackSys",
-	"Sys",
-	"TotalAlloc",
-	"PollCount",
-	"RandomValue",
+// Metrics представляет собой структуру данных для хранения информации о метрике.
+// Метрики с одинаковым именем и разными метками хранятся как отдельные ряды.
+type Metrics struct {
+	ID        string            `json:"id"`                  // имя метрики
+	MType     string            `json:"type"`                // параметр, принимающий значение gauge, counter, histogram или summary
+	Delta     *int64            `json:"delta,omitempty"`     // значение метрики в случае передачи counter
+	Value     *float64          `json:"value,omitempty"`     // значение метрики в случае передачи gauge
+	Histogram *Histogram        `json:"histogram,omitempty"` // значения метрики в случае передачи histogram
+	Summary   *Summary          `json:"summary,omitempty"`   // значения метрики в случае передачи summary
+	Labels    map[string]string `json:"labels,omitempty"`    // необязательные метки ряда
 }
 
-// createDB создает и настраивает базу данных для хранения метрик.
-func createDB(DBConn string) {
-
-	// Установка соединения с базой данных
-	conn, err := pgx.Connect(context.Background(), DBConn)
-	if err != nil {
-		panic(err)
+// Float64 возвращает значение метрики в виде числа с плавающей точкой независимо от её типа.
+// Для histogram и summary это общее число значений.
+func (m Metrics) Float64() float64 {
+	if m.MType == "counter" && m.Delta != nil {
+		return float64(*m.Delta)
 	}
-
-	_, err = conn.Exec(context.Background(), "DROP TABLE IF EXISTS metrics")
-	if err != nil {
-		panic(err)
+	if m.MType == "histogram" && m.Histogram != nil {
+		return float64(m.Histogram.Count())
 	}
-	var tableExists bool
-	err = conn.QueryRow(context.Background(), "SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = $1)", "metrics").Scan(&tableExists)
-	if err != nil {
-		panic(err)
+	if m.MType == "summary" && m.Summary != nil {
+		return float64(m.Summary.Count)
 	}
-
-	// Если таблица metrics не существует, создаем её
-	if !tableExists {
-		_, err = conn.Exec(context.Background(), `CREATE TABLE metrics (
-            name text PRIMARY KEY,
-            metric_data jsonb
-        )`)
-		if err != nil {
-			panic(err)
-		}
-		fmt.Println("Таблица 'metrics' создана.")
-
-		// Создайте записи с нулевыми значениями для каждого ключа в metricKeys
-		for _, key := range metricKeys {
-			delta := int64(0)
-			value := 0.0
-			metricData := Metrics{
-				ID:    key,
-				MType: "",
-				Delta: &delta,
-				Value: &value,
-			}
-			metricDataJSON, err := json.Marshal(metricData)
-			if err != nil {
-				fmt.Println(err)
-				continue
-			}
-
-			_, err = conn.Exec(context.Background(), `
-                INSERT INTO metrics (name, metric_data)
-                VALUES ($1, $2)
-                ON CONFLICT (name) DO UPDATE
-                SET metric_data = $2
-            `, key, metricDataJSON)
-
-			if err != nil {
-				fmt.Println(err)
-			}
-		}
-	} else {
-		fmt.Println("Таблица 'metrics' уже существует.")
+	if m.Value != nil {
+		return *m.Value
 	}
+	return 0
+}
 
+// CounterMetric создает метрику counter с приращением delta.
+func CounterMetric(name string, delta int64) Metrics {
+	return Metrics{ID: name, MType: "counter", Delta: &delta}
 }
 
-// MetricsStorageInternal представляет внутреннюю структуру для хранения и обработки метрик.
-type MetricsStorageInternal struct {
-	Mu         sync.RWMutex
-	MetricsMap map[string]float64
-	c          *Config
-	DB         *sql.DB
+// GaugeMetric создает метрику gauge со значением value.
+func GaugeMetric(name string, value float64) Metrics {
+	return Metrics{ID: name, MType: "gauge", Value: &value}
 }
 
-// TestMetricStorage создает тестовый экземпляр MetricsStorage.
-func TestMetricStorage() *MetricsStorage {
-	m := &MetricsStorageInternal{
-		MetricsMap: make(map[string]float64),
-	}
+// ErrHistoryDisabled возвращается при запросе истории, если её хранение отключено.
+var ErrHistoryDisabled = errors.New("metric history is disabled")
 
-	return &MetricsStorage{m}
-}
+// MetricsStorage представляет собой хранилище метрик поверх выбранного бэкенда
+// с историей значений, которая пополняется при каждом обновлении.
+// Хранилища арендаторов создаются при первом обращении и полностью изолированы от основного.
+type MetricsStorage struct {
+	Backend
+	history *History
 
-// PingDB проверяет подключение к базе данных.
-func (s *MetricsStorage) PingDB() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if err := s.DB.PingContext(ctx); err != nil {
-		return err
-	}
-	return nil
+	mu      sync.Mutex
+	tenants map[string]*MetricsStorage
+
+	stop      chan struct{}  // закрывается при завершении работы, останавливая уплотнение истории
+	compactor sync.WaitGroup // горутина периодического уплотнения истории
 }
 
-// NewMetricsStorage создает новый экземпляр MetricsStorage с учетом конфигурации.
+// NewMetricsStorage создает новый экземпляр MetricsStorage с бэкендом, выбранным по конфигурации,
+// и запускает периодическое уплотнение истории.
 func NewMetricsStorage(c *Config) *MetricsStorage {
-
-	m := &MetricsStorageInternal{}
-	m.MetricsMap = make(map[string]float64)
-	m.c = c
-	m.DB = nil
-	if c.DatabaseDSN != "" {
-		db, err := sql.Open("pgx", c.DatabaseDSN)
-		if err != nil {
-			panic(err)
-		}
-		createDB(c.DatabaseDSN)
-		m.DB = db
+	s := &MetricsStorage{Backend: NewBackend(c)}
+	if c.HistoryRetention <= 0 {
+		return s
 	}
 
-	if c.RestoreFlag {
-		_ = m.ReadFromDisk()
-	}
-	go func() {
-		if c.StoreInterval > 0 {
-			t := time.NewTicker(time.Duration(c.StoreInterval) * time.Second)
-			for i := range t.C {
-				err := m.writeToDisk()
-				if err != nil {
-					fmt.Println(i)
-					fmt.Println(err)
+	s.history = NewHistory(time.Duration(c.HistoryRetention)*time.Second, historyTiers(c)...)
+	if c.CompactInterval > 0 {
+		s.stop = make(chan struct{})
+		s.compactor.Add(1)
+		go func() {
+			defer s.compactor.Done()
+			t := time.NewTicker(time.Duration(c.CompactInterval) * time.Second)
+			defer t.Stop()
+			for {
+				select {
+				case now := <-t.C:
+					s.compact(now)
+				case <-s.stop:
+					return
 				}
 			}
-		}
-
-	}()
-	return &MetricsStorage{m}
+		}()
+	}
+	return s
 }
 
-// ReadFromDisk считывает данные о метриках с диска.
-func (s *MetricsStorageInternal) ReadFromDisk() error {
-	bytes, err := os.ReadFile(s.c.FileStoragePath)
-	if err != nil {
-		return err
+// historyTiers возвращает уровни уплотнения истории 1m и 1h, включённые в конфигурации.
+func historyTiers(c *Config) []TierConfig {
+	var tiers []TierConfig
+	if c.MinuteRetention > 0 {
+		tiers = append(tiers, TierConfig{Resolution: time.Minute, Retention: time.Duration(c.MinuteRetention) * time.Second})
 	}
-	err = json.Unmarshal(bytes, &s.MetricsMap)
-	if err != nil {
-		return fmt.Errorf("unmarshal file  %w : %s", err, string(bytes))
+	if c.HourRetention > 0 {
+		tiers = append(tiers, TierConfig{Resolution: time.Hour, Retention: time.Duration(c.HourRetention) * time.Second})
 	}
-	return nil
+	return tiers
 }
 
-// Shutdown сохраняет данные о метриках при завершении работы.
-func (s *MetricsStorageInternal) Shutdown() {
-	_ = s.writeToDisk()
+// TestMetricStorage создает тестовый экземпляр MetricsStorage, хранящий метрики и их историю в памяти.
+func TestMetricStorage() *MetricsStorage {
+	return &MetricsStorage{
+		Backend: NewMemoryStorage(),
+		history: NewHistory(time.Hour),
+	}
 }
 
-// writeToDisk сохраняет данные о метриках на диск.
-func (s *MetricsStorageInternal) writeToDisk() error {
-	s.Mu.Lock()
-	defer s.Mu.Unlock()
-
-	copyMetricsMap := make(map[string]float64)
-	for key, value := range s.MetricsMap {
-		copyMetricsMap[key] = value
+// Tenant возвращает хранилище арендатора tenant, создавая его при первом обращении.
+// Пустое имя соответствует основному хранилищу.
+func (s *MetricsStorage) Tenant(tenant string) *MetricsStorage {
+	if tenant == "" {
+		return s
 	}
 
-	bytes, err := json.Marshal(copyMetricsMap)
-	if err != nil {
-		return err
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, exists := s.tenants[tenant]; exists {
+		return t
 	}
-
-	return os.WriteFile(s.c.FileStoragePath, bytes, 0644)
+	t := &MetricsStorage{Backend: s.Backend.ForTenant(tenant)}
+	if s.history != nil {
+		t.history = s.history.empty()
+	}
+	if s.tenants == nil {
+		s.tenants = make(map[string]*MetricsStorage)
+	}
+	s.tenants[tenant] = t
+	return t
 }
 
-// Metrics представляет собой структуру данных для хранения информации о метрике.
-type Metrics struct {
-	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
-	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
+// tenantStorages возвращает созданные хранилища арендаторов.
+func (s *MetricsStorage) tenantStorages() []*MetricsStorage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tenants := make([]*MetricsStorage, 0, len(s.tenants))
+	for _, t := range s.tenants {
+		tenants = append(tenants, t)
+	}
+	return tenants
 }
 
-// WriteMetrics записывает данные о метриках в хранилище.
-func (s *MetricsStorageInternal) WriteMetrics(m runtime.MemStats) {
-	s.Mu.Lock()
-	s.MetricsMap["Alloc"] = float64(m.Alloc)
-	s.MetricsMap["BuckHashSys"] = float64(m.BuckHashSys)
-	s.MetricsMap["Frees"] = float64(m.Frees)
-	s.MetricsMap["GCCPUFraction"] = m.GCCPUFraction
-	s.MetricsMap["GCSys"] = float64(m.GCSys)
-	s.MetricsMap["HeapAlloc"] = float64(m.HeapAlloc)
-	s.MetricsMap["HeapIdle"] = float64(m.HeapIdle)
-	s.MetricsMap["HeapInuse"] = float64(m.HeapInuse)
-	s.MetricsMap["HeapObjects"] = float64(m.HeapObjects)
-	s.MetricsMap["HeapReleased"] = float64(m.HeapReleased)
-	s.MetricsMap["HeapSys"] = float64(m.HeapSys)
-	s.MetricsMap["LastGC"] = float64(m.LastGC)
-	s.MetricsMap["Lookups"] = float64(m.Lookups)
-	s.MetricsMap["MCacheInuse"] = float64(m.MCacheInuse)
-	s.MetricsMap["MCacheSys"] = float64(m.MCacheSys)
-	s.MetricsMap["MSpanInuse"] = float64(m.MSpanInuse)
-	s.MetricsMap["MSpanSys"] = float64(m.MSpanSys)
-	s.MetricsMap["Mallocs"] = float64(m.Mallocs)
-	s.MetricsMap["NextGC"] = float64(m.NextGC)
-	s.MetricsMap["NumForcedGC"] = float64(m.NumForcedGC)
-	s.MetricsMap["NumGC"] = float64(m.NumGC)
-	s.MetricsMap["OtherSys"] = float64(m.OtherSys)
-	s.MetricsMap["PauseTotalNs"] = float64(m.PauseTotalNs)
-	s.MetricsMap["StackInuse"] = float64(m.StackInuse)
-	s.MetricsMap["StackSys"] = float64(m.StackSys)
-	s.MetricsMap["Sys"] = float64(m.Sys)
-	s.MetricsMap["TotalAlloc"] = float64(m.TotalAlloc)
-	s.MetricsMap["PollCount"] = 1
-	s.MetricsMap["RandomValue"] = rand.Float64()
-	s.Mu.Unlock()
-	if s.c.StoreInterval == 0 {
-		_ = s.writeToDisk()
+// compact уплотняет историю основного хранилища и хранилищ арендаторов.
+func (s *MetricsStorage) compact(now time.Time) {
+	s.history.Compact(now)
+	for _, t := range s.tenantStorages() {
+		t.history.Compact(now)
 	}
-	collectNewMetrics(s)
 }
 
-// collectNewMetrics собирает новые метрики, такие как использование памяти и CPU.
-func collectNewMetrics(metricsStorage *MetricsStorageInternal) {
-	memInfo, err := mem.VirtualMemory()
-	if err == nil {
-		metricsStorage.Mu.Lock()
-		metricsStorage.MetricsMap["TotalMemory"] = float64(memInfo.Total)
-		metricsStorage.MetricsMap["FreeMemory"] = float64(memInfo.Free)
-		metricsStorage.Mu.Unlock()
+// Shutdown останавливает уплотнение истории и завершает работу хранилищ арендаторов и основного бэкенда.
+func (s *MetricsStorage) Shutdown() {
+	if s.stop != nil {
+		close(s.stop)
+		s.compactor.Wait()
 	}
-
-	cpuInfo, err := cpu.Info()
-	if err == nil {
-		metricsStorage.Mu.Lock()
-		for i, cpuStat := range cpuInfo {
-			metricsStorage.MetricsMap[fmt.Sprintf("CPUUtilization%d", i)] = float64(cpuStat.CPU)
-		}
-		metricsStorage.Mu.Unlock()
+	for _, t := range s.tenantStorages() {
+		t.Backend.Shutdown()
 	}
-
+	s.Backend.Shutdown()
 }
 
-// keyExists проверяет наличие ключей.
-func keyExists(key string) bool {
-	for _, k := range metricKeys {
-		if k == key {
-			return true
-		}
+// UpdateMetricValue обновляет значение метрики с использованием механизма повторных попыток.
+func (s *MetricsStorage) UpdateMetricValue(m Metrics) error {
+	err := Retry(func() error {
+		return s.Backend.UpdateMetricValue(m)
+	})
+	if err != nil {
+		return err
 	}
-	metricKeys = append(metricKeys, key)
-	return false
+	s.record([]Metrics{m}, nil)
+	return nil
 }
 
-// UpdateMetricValue обновляет значение метрики в хранилище.
-func (s *MetricsStorageInternal) UpdateMetricValue(m Metrics) error {
+// UpdateMetricsValue обновляет значения нескольких метрик с использованием механизма повторных попыток.
+func (s *MetricsStorage) UpdateMetricsValue(m []Metrics) error {
+	err := Retry(func() error {
+		return s.Backend.UpdateMetricsValue(m)
+	})
+	if err != nil {
+		return err
+	}
+	s.record(m, nil)
+	return nil
+}
 
-	s.Mu.Lock()
-	defer s.Mu.Unlock()
+// UpdateMetricsValueAt обновляет значения нескольких метрик, как UpdateMetricsValue, но записывает их в историю
+// с собственными метками времени: times[i] - время значения metrics[i]. Метки из будущего заменяются текущим временем,
+// чтобы одно неверное значение не вытеснило из истории все остальные.
+func (s *MetricsStorage) UpdateMetricsValueAt(m []Metrics, times []time.Time) error {
+	if len(times) != len(m) {
+		return fmt.Errorf("got %d timestamps for %d metrics", len(times), len(m))
+	}
+	err := Retry(func() error {
+		return s.Backend.UpdateMetricsValue(m)
+	})
+	if err != nil {
+		return err
+	}
+	s.record(m, times)
+	return nil
+}
 
-	if m.MType == "counter" {
-		if m.Delta == nil {
-			v := int64(1)
-			m.Delta = &v
-		}
-		s.MetricsMap[m.ID] += float64(*m.Delta)
+// QueryRange возвращает историю значений метрики за интервал времени.
+func (s *MetricsStorage) QueryRange(q RangeQuery) (Series, error) {
+	if s.history == nil {
+		return Series{}, ErrHistoryDisabled
+	}
+	return s.history.QueryRange(q)
+}
 
-		d := int64(s.MetricsMap[m.ID])
-		metricData := Metrics{
-			ID:    m.ID,
-			MType: m.MType,
-			Delta: &d,
-			Value: m.Value,
-		}
+// record добавляет в историю значения обновлённых метрик с метками времени times или, если они не заданы, с текущим временем.
+// Для gauge без меток времени берётся последнее переданное значение, а с метками - каждое значение в свой момент.
+// Для counter записывается накопленное значение из бэкенда на момент последнего обновления в пакете.
+func (s *MetricsStorage) record(metrics []Metrics, times []time.Time) {
+	if s.history == nil {
+		return
+	}
 
-		metricDataJSON, err := json.Marshal(metricData)
-		if err != nil {
-			return err
+	now := time.Now()
+	at := func(i int) time.Time {
+		if times == nil || times[i].After(now) {
+			return now
 		}
-		if s.DB != nil {
-			if !keyExists(m.ID) {
-				_, err = s.DB.ExecContext(context.Background(), "INSERT INTO metrics (name, metric_data) VALUES ($1, $2)", m.ID, metricDataJSON)
-				if err != nil {
-					return err
-				}
-			}
-			_, err = s.DB.ExecContext(context.Background(), "UPDATE metrics SET metric_data = $1 WHERE name = $2", metricDataJSON, m.ID)
-			if err != nil {
-				return err
-			}
+		return times[i]
+	}
+	seen := make(map[seriesKey]bool, len(metrics))
+	for i := len(metrics) - 1; i >= 0; i-- {
+		m := metrics[i]
+		key := seriesKey{MType: m.MType, ID: m.ID, Labels: LabelsKey(m.Labels)}
+		if seen[key] && (times == nil || m.MType != "gauge") {
+			continue
 		}
-		return nil
+		seen[key] = true
 
-	} else {
-		d := int64(0)
-		s.MetricsMap[m.ID] = *m.Value
-
-		metricData := Metrics{
-			ID:    m.ID,
-			MType: m.MType,
-			Delta: &d,
-			Value: m.Value,
+		if m.MType == "gauge" && m.Value != nil {
+			s.history.Append(m, at(i))
+			continue
 		}
-
-		metricDataJSON, err := json.Marshal(metricData)
+		stored, err := s.Backend.GetMetric(Metrics{ID: m.ID, MType: m.MType, Labels: m.Labels})
 		if err != nil {
-			return err
+			continue
 		}
-		if s.DB != nil {
-			if !keyExists(m.ID) {
-				_, err = s.DB.ExecContext(context.Background(), "INSERT INTO metrics (name, metric_data) VALUES ($1, $2)", m.ID, metricDataJSON)
-				if err != nil {
-					return err
-				}
-			}
+		s.history.Append(stored, at(i))
+	}
+}
 
-			_, err = s.DB.ExecContext(context.Background(), "UPDATE metrics SET metric_data = $1 WHERE name = $2", metricDataJSON, m.ID)
-			if err != nil {
-				return err
+// Retry выполняет функцию fn с повторными попытками в случае ошибок, связанных с подключением к базе данных.
+func Retry(fn func() error) error {
+	err := fn()
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgerrcode.IsConnectionException(pgErr.Code) {
+		if err != nil {
+			try := 1
+			for err != nil && try < 4 {
+				time.Sleep(time.Duration(2*(try-1) + 1))
+				err = fn()
+				try++
 			}
 		}
-	}
 
-	return nil
-}
-
-// UpdateMetricsValue обновляет значения нескольких метрик в хранилище.
-func (s *MetricsStorageInternal) UpdateMetricsValue(metrics []Metrics) error {
-	var err error
-	for _, m := range metrics {
-		err = errors.Join(err, s.UpdateMetricValue(m))
 	}
 	return err
 }
-
-// GetMetricByName получает значение метрики по её имени.
-func (s *MetricsStorageInternal) GetMetricByName(m Metrics) (float64, error) {
-	s.Mu.RLock()
-	value, exists := s.MetricsMap[m.ID]
-	s.Mu.RUnlock()
-	if exists {
-		return value, nil
-	}
-	return 0, fmt.Errorf("undefind metricName: %v", m.ID)
-}
-
-// SortMetricByName сортирует названия метрик по алфавиту.
-func (s *MetricsStorageInternal) SortMetricByName() []string {
-	var keys []string
-	s.Mu.RLock()
-	for key := range s.MetricsMap {
-		keys = append(keys, key)
-	}
-	s.Mu.RUnlock()
-	sort.Strings(keys)
-	return keys
-}
-
-// GetAllMetrics возвращает все метрики в виде строки.
-func (s *MetricsStorageInternal) GetAllMetrics() string {
-	keys := s.SortMetricByName()
-	var result string
-	for _, key := range keys {
-		result += fmt.Sprintf("%v/%v\n", key, s.MetricsMap[key])
-	}
-	return result
-}
@@ -1,72 +1,15 @@
 package storage
 
 import (
-	"database/sql"
 	"encoding/json"
-	"fmt"
 	"os"
-	"runtime"
-	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func TestWriteMetrics(t *testing.T) {
-	// Создаем объект MetricsStorageInternal
-	metricsStorage := &MetricsStorageInternal{
-		MetricsMap: make(map[string]float64),
-		Mu:         sync.RWMutex{},
-		c: &Config{
-			StoreInterval: 0, // установка StoreInterval в 0 для вызова writeToDisk внутри функции
-		},
-		DB: &sql.DB{}, // Предполагая, что у вас есть тип sql.DB
-	}
-
-	// Инициализируем структуру MemStats для передачи в WriteMetrics
-	memStats := runtime.MemStats{
-		Alloc:       100,
-		BuckHashSys: 200,
-	}
-
-	// Вызываем функцию WriteMetrics
-	metricsStorage.WriteMetrics(memStats)
-
-	// Проверяем, что метрики были корректно записаны в MetricsMap
-	assert.Equal(t, float64(100), metricsStorage.MetricsMap["Alloc"])
-	assert.Equal(t, float64(200), metricsStorage.MetricsMap["BuckHashSys"])
-
-	// Проверяем, что PollCount был установлен в 1
-	assert.Equal(t, float64(1), metricsStorage.MetricsMap["PollCount"])
-
-	// Проверяем, что RandomValue был установлен (в пределах разумного)
-	assert.NotNil(t, metricsStorage.MetricsMap["RandomValue"])
-}
-
-func TestCollectNewMetrics(t *testing.T) {
-	// Создание объекта MetricsStorageInternal
-	metricsStorage := &MetricsStorageInternal{
-		MetricsMap: make(map[string]float64),
-		Mu:         sync.RWMutex{},
-		c:          &Config{}, // Предполагая, что у вас есть тип Config
-		DB:         &sql.DB{}, // Предполагая, что у вас есть тип sql.DB
-	}
-
-	// Тест для случая успешного сбора метрик
-	t.Run("Collect New Metrics Successfully", func(t *testing.T) {
-		// Вызываем функцию для сбора метрик
-		collectNewMetrics(metricsStorage)
-
-		// Проверяем, что метрики были успешно добавлены в MetricsMap
-		assert.GreaterOrEqual(t, metricsStorage.MetricsMap["TotalMemory"], float64(0))
-		assert.GreaterOrEqual(t, metricsStorage.MetricsMap["FreeMemory"], float64(0))
-
-		key := fmt.Sprintf("CPUUtilization%d", 0)
-		assert.GreaterOrEqual(t, metricsStorage.MetricsMap[key], float64(0))
-
-	})
-
-}
 func TestNewConfig(t *testing.T) {
 	// Тест для создания нового Config с значениями по умолчанию
 	config := NewConfig(false)
@@ -99,10 +42,8 @@ func TestGetEnvAsBool(t *testing.T) {
 }
 
 func TestUpdateMetricValue(t *testing.T) {
-	// Инициализация MetricsStorageInternal
-	storage := &MetricsStorageInternal{
-		MetricsMap: make(map[string]float64),
-	}
+	// Инициализация MemoryStorage
+	storage := NewMemoryStorage()
 
 	// Тест для counter с Delta равным nil
 	t.Run("UpdateMetricValue Counter with Nil Delta", func(t *testing.T) {
@@ -114,13 +55,13 @@ func TestUpdateMetricValue(t *testing.T) {
 		err := storage.UpdateMetricValue(metrics)
 
 		assert.NoError(t, err)
-		assert.Equal(t, float64(1), storage.MetricsMap["metric1"])
+		assert.Equal(t, int64(1), storage.Counters["metric1"])
 	})
 
 	// Тест для counter с указанным Delta
 	t.Run("UpdateMetricValue Counter with Non-nil Delta", func(t *testing.T) {
 		// Предварительная установка значения метрики
-		storage.MetricsMap["metric2"] = 5.0
+		storage.Counters["metric2"] = 5
 
 		metrics := Metrics{
 			ID:    "metric2",
@@ -131,7 +72,7 @@ func TestUpdateMetricValue(t *testing.T) {
 		err := storage.UpdateMetricValue(metrics)
 
 		assert.NoError(t, err)
-		assert.Equal(t, float64(8), storage.MetricsMap["metric2"])
+		assert.Equal(t, int64(8), storage.Counters["metric2"])
 	})
 
 	// Тестирование JSON маршалинга
@@ -164,7 +105,7 @@ func TestUpdateMetricValue(t *testing.T) {
 		err := storage.UpdateMetricValue(metrics)
 
 		assert.NoError(t, err)
-		assert.Equal(t, float64(7.5), storage.MetricsMap["metric5"])
+		assert.Equal(t, float64(7.5), storage.Gauges["metric5"])
 	})
 
 	// Тест для случая ошибки при маршалинге JSON (вторая часть функции)
@@ -196,20 +137,6 @@ func int64Ptr(v int64) *int64 {
 func float64Ptr(v float64) *float64 {
 	return &v
 }
-func TestKeyExists(t *testing.T) {
-	// Тест случая, когда ключ существует
-	existingKey := "HeapAlloc"
-	assert.True(t, keyExists(existingKey), "Expected KeyExists to return true for existing key, but got false")
-
-	// Тест случая, когда ключ отсутствует
-	nonExistingKey := "NonExistingKey"
-	assert.False(t, keyExists(nonExistingKey), "Expected KeyExists to return false for non-existing key, but got true")
-
-	// Тест случая, когда ключ добавляется в массив
-	newKey := "NewKey"
-	assert.False(t, keyExists(newKey), "Expected KeyExists to return false for new key before it's added, but got true")
-	assert.True(t, keyExists(newKey), "Expected KeyExists to return true for new key after it's added, but got false")
-}
 
 // FileSystem интерфейс для работы с файловой системой.
 type FileSystem interface {
@@ -224,111 +151,16 @@ func (fs DefaultFileSystem) WriteFile(filename string, data []byte, perm os.File
 	return os.WriteFile(filename, data, perm)
 }
 
-// Тест для функции writeToDisk
-func TestWriteToDisk(t *testing.T) {
-	// Создаем временный файл
-	tempFile, err := os.CreateTemp("", "testfile")
-	if err != nil {
-		t.Fatalf("Could not create temp file: %v", err)
-	}
-	defer os.Remove(tempFile.Name())
-
-	// Создаем экземпляр хранилища метрик для использования в тестах.
-	storage := &MetricsStorageInternal{
-		MetricsMap: make(map[string]float64),
-		c: &Config{
-			FileStoragePath: tempFile.Name(),
-		},
-	}
-
-	// Добавляем тестовые данные
-	storage.MetricsMap["metric1"] = 123.45
-	storage.MetricsMap["metric2"] = 678.90
-
-	// Записываем в файл
-	err = storage.writeToDisk()
-	if err != nil {
-		t.Fatalf("writeToDisk failed: %v", err)
-	}
-
-	// Читаем данные из файла
-	content, err := os.ReadFile(tempFile.Name())
-	if err != nil {
-		t.Fatalf("Could not read from temp file: %v", err)
-	}
-
-	// Распаковываем данные и сравниваем
-	var readMetrics map[string]float64
-	err = json.Unmarshal(content, &readMetrics)
-	if err != nil {
-		t.Fatalf("Failed to unmarshal JSON: %v", err)
-	}
-
-	// Проверяем, что прочитанные метрики совпадают с ожидаемыми
-	if len(storage.MetricsMap) != len(readMetrics) {
-		t.Errorf("Number of metrics does not match. Expected: %v, Got: %v", len(storage.MetricsMap), len(readMetrics))
-	}
-
-	for key, expectedValue := range storage.MetricsMap {
-		if actualValue, ok := readMetrics[key]; ok {
-			if expectedValue != actualValue {
-				t.Errorf("Metric value mismatch for key %s. Expected: %v, Got: %v", key, expectedValue, actualValue)
-			}
-		} else {
-			t.Errorf("Key %s not found in read metrics", key)
-		}
-	}
-}
-
-// t
-func TestMetricsStorageInternal_ReadFromDisk(t *testing.T) {
-	// Подготовка временного файла с данными
-	tempFile, err := os.CreateTemp("", "testfile.json")
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer os.Remove(tempFile.Name())
-
-	// Подготовка тестовых данных
-	testMetrics := map[string]float64{"metric1": 123, "metric2": 0}
-	testMetricsBytes, err := json.Marshal(testMetrics)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	// Запись данных во временный файл
-	if err := os.WriteFile(tempFile.Name(), testMetricsBytes, 0644); err != nil {
-		t.Fatal(err)
-	}
-
-	// Подготовка объекта MetricsStorageInternal для теста
-	storage := MetricsStorageInternal{
-		c: &Config{FileStoragePath: tempFile.Name()},
-		// Добавьте необходимые инициализации для теста
-	}
-
-	// Вызов тестируемой функции
-	err = storage.ReadFromDisk()
-
-	// Проверка результата
-	assert.NoError(t, err)
-	assert.Equal(t, testMetrics, storage.MetricsMap)
-}
-
-func TestMetricsStorageInternal_GetMetricByName(t *testing.T) {
+func TestMemoryStorage_GetMetricByName(t *testing.T) {
 	// Подготовка тестовых данных
 	testMetricsMap := map[string]float64{
 		"metric1": 123,
 		"metric2": 456,
 	}
-	config := &Config{} // Добавьте необходимые данные в конфигурацию
-
-	// Инициализация объекта MetricsStorageInternal для теста
-	storage := MetricsStorageInternal{
-		Mu:         sync.RWMutex{},
-		MetricsMap: testMetricsMap,
-		c:          config,
-		// Добавьте необходимые инициализации для теста
+	// Инициализация объекта MemoryStorage для теста
+	storage := MemoryStorage{
+		Gauges:   testMetricsMap,
+		Counters: map[string]int64{},
 	}
 
 	// Вызов тестируемой функции
@@ -347,21 +179,17 @@ func TestMetricsStorageInternal_GetMetricByName(t *testing.T) {
 	assert.Equal(t, 0.0, result)
 }
 
-func TestMetricsStorageInternal_SortMetricByName(t *testing.T) {
+func TestMemoryStorage_SortMetricByName(t *testing.T) {
 	// Подготовка тестовых данных
 	testMetricsMap := map[string]float64{
 		"metric1": 123,
 		"metric3": 456,
 		"metric2": 789,
 	}
-	config := &Config{} // Добавьте необходимые данные в конфигурацию
-
-	// Инициализация объекта MetricsStorageInternal для теста
-	storage := MetricsStorageInternal{
-		Mu:         sync.RWMutex{},
-		MetricsMap: testMetricsMap,
-		c:          config,
-		// Добавьте необходимые инициализации для теста
+	// Инициализация объекта MemoryStorage для теста
+	storage := MemoryStorage{
+		Gauges:   testMetricsMap,
+		Counters: map[string]int64{},
 	}
 
 	// Вызов тестируемой функции
@@ -374,21 +202,17 @@ func TestMetricsStorageInternal_SortMetricByName(t *testing.T) {
 	assert.Equal(t, expectedOrder, result)
 }
 
-func TestMetricsStorageInternal_GetAllMetrics(t *testing.T) {
+func TestMemoryStorage_GetAllMetrics(t *testing.T) {
 	// Подготовка тестовых данных
 	testMetricsMap := map[string]float64{
 		"metric1": 123,
 		"metric3": 456,
 		"metric2": 789,
 	}
-	config := &Config{} // Добавьте необходимые данные в конфигурацию
-
-	// Инициализация объекта MetricsStorageInternal для теста
-	storage := MetricsStorageInternal{
-		Mu:         sync.RWMutex{},
-		MetricsMap: testMetricsMap,
-		c:          config,
-		// Добавьте необходимые инициализации для теста
+	// Инициализация объекта MemoryStorage для теста
+	storage := MemoryStorage{
+		Gauges:   testMetricsMap,
+		Counters: map[string]int64{},
 	}
 
 	// Вызов тестируемой функции
@@ -400,3 +224,122 @@ func TestMetricsStorageInternal_GetAllMetrics(t *testing.T) {
 	// Проверка результата
 	assert.Equal(t, expectedResult, result)
 }
+
+func TestMemoryStorage_ListMetrics(t *testing.T) {
+	storage := NewMemoryStorage()
+	storage.Gauges["metric2"] = 1.5
+	storage.Gauges["same"] = 0.5
+	storage.Counters["same"] = 7
+	storage.Counters["metric1"] = 3
+
+	metrics, err := storage.ListMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, []Metrics{
+		{ID: "metric1", MType: "counter", Delta: int64Ptr(3)},
+		{ID: "metric2", MType: "gauge", Value: float64Ptr(1.5)},
+		{ID: "same", MType: "counter", Delta: int64Ptr(7)},
+		{ID: "same", MType: "gauge", Value: float64Ptr(0.5)},
+	}, metrics)
+}
+
+func TestNewBackend(t *testing.T) {
+	// Без DSN и пути к файлу используется хранилище в памяти
+	assert.IsType(t, &MemoryStorage{}, NewBackend(&Config{}))
+
+	// При заданном пути к файлу используется файловое хранилище
+	path := t.TempDir() + "/metrics.json"
+	assert.IsType(t, &FileStorage{}, NewBackend(&Config{FileStoragePath: path, StoreInterval: 0}))
+}
+
+func TestNewMetricsStorage_Shutdown(t *testing.T) {
+	s := NewMetricsStorage(&Config{HistoryRetention: 60, CompactInterval: 1})
+	require.NotNil(t, s.stop)
+
+	// Shutdown останавливает горутину уплотнения истории и дожидается её завершения
+	done := make(chan struct{})
+	go func() {
+		s.Shutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown не дождался остановки уплотнения истории")
+	}
+	select {
+	case <-s.stop:
+	default:
+		t.Fatal("уплотнение истории не остановлено")
+	}
+}
+
+func TestMemoryStorage_PingDB(t *testing.T) {
+	assert.ErrorIs(t, NewMemoryStorage().PingDB(), errDBNotConfigured)
+}
+
+func TestMetricsStorage_UpdateMetricsValue(t *testing.T) {
+	s := TestMetricStorage()
+
+	err := s.UpdateMetricsValue([]Metrics{
+		{ID: "counter1", MType: "counter", Delta: int64Ptr(2)},
+		{ID: "gauge1", MType: "gauge"},
+	})
+
+	// Ошибка одной метрики не мешает сохранить остальные
+	assert.Error(t, err)
+	value, err := s.GetMetricByName(Metrics{ID: "counter1"})
+	assert.NoError(t, err)
+	assert.Equal(t, float64(2), value)
+}
+
+func TestMemoryStorage_SeparateNamespaces(t *testing.T) {
+	s := NewMemoryStorage()
+
+	// gauge и counter с одинаковым именем не перезаписывают друг друга
+	assert.NoError(t, s.UpdateMetricValue(Metrics{ID: "foo", MType: "gauge", Value: float64Ptr(0.5)}))
+	assert.NoError(t, s.UpdateMetricValue(Metrics{ID: "foo", MType: "counter", Delta: int64Ptr(1 << 60)}))
+	assert.NoError(t, s.UpdateMetricValue(Metrics{ID: "foo", MType: "counter", Delta: int64Ptr(1)}))
+
+	gauge, err := s.GetMetric(Metrics{ID: "foo", MType: "gauge"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0.5, *gauge.Value)
+
+	// Значение counter возвращается без потери точности
+	counter, err := s.GetMetric(Metrics{ID: "foo", MType: "counter"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1<<60+1), *counter.Delta)
+
+	_, err = s.GetMetric(Metrics{ID: "bar", MType: "counter"})
+	assert.Error(t, err)
+
+	assert.Error(t, s.UpdateMetricValue(Metrics{ID: "foo", MType: "unknown", Value: float64Ptr(1)}))
+
+	assert.Equal(t, []string{"foo"}, s.SortMetricByName())
+	assert.Equal(t, "foo/1152921504606846977\nfoo/0.5\n", s.GetAllMetrics())
+}
+
+func TestMetricsStorage_Tenant(t *testing.T) {
+	s := TestMetricStorage()
+	teamA := s.Tenant("team-a")
+	teamB := s.Tenant("team-b")
+
+	assert.Same(t, s, s.Tenant(""))
+	assert.Same(t, teamA, s.Tenant("team-a"))
+
+	// Одинаковые имена метрик у разных арендаторов не пересекаются
+	require.NoError(t, s.UpdateMetricValue(Metrics{ID: "HeapAlloc", MType: "gauge", Value: float64Ptr(1)}))
+	require.NoError(t, teamA.UpdateMetricValue(Metrics{ID: "HeapAlloc", MType: "gauge", Value: float64Ptr(2)}))
+	require.NoError(t, teamB.UpdateMetricValue(Metrics{ID: "PollCount", MType: "counter", Delta: int64Ptr(3)}))
+
+	for storage, expected := range map[*MetricsStorage]string{s: "HeapAlloc/1\n", teamA: "HeapAlloc/2\n", teamB: "PollCount/3\n"} {
+		assert.Equal(t, expected, storage.GetAllMetrics())
+	}
+
+	// История арендатора ведется отдельно
+	series, err := teamA.QueryRange(RangeQuery{ID: "HeapAlloc", MType: "gauge", From: time.Now().Add(-time.Minute), To: time.Now()})
+	require.NoError(t, err)
+	require.Len(t, series.Points, 1)
+	assert.Equal(t, float64(2), series.Points[0].Value)
+	_, err = teamB.QueryRange(RangeQuery{ID: "HeapAlloc", MType: "gauge", From: time.Now().Add(-time.Minute), To: time.Now()})
+	assert.Error(t, err)
+}
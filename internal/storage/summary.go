@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Summary представляет собой значение метрики типа summary: квантили, посчитанные клиентом,
+// общее число значений Count и их сумму Sum. В отличие от гистограммы, квантили разных источников
+// нельзя сложить, поэтому сводка, как gauge, заменяется последней присланной, а Count и Sum
+// передаются накопленными с момента запуска клиента. Сводки нескольких агентов различаются метками.
+type Summary struct {
+	Quantiles []Quantile `json:"quantiles"`
+	Count     uint64     `json:"count"`
+	Sum       float64    `json:"sum"`
+}
+
+// Quantile - значение Value квантиля Quantile из [0, 1].
+type Quantile struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+// Validate проверяет, что квантили лежат в [0, 1] и строго возрастают, а значения и сумма конечны.
+func (s *Summary) Validate() error {
+	for i, q := range s.Quantiles {
+		if math.IsNaN(q.Quantile) || q.Quantile < 0 || q.Quantile > 1 {
+			return fmt.Errorf("invalid summary quantile: %v", q.Quantile)
+		}
+		if i > 0 && q.Quantile <= s.Quantiles[i-1].Quantile {
+			return fmt.Errorf("summary quantiles must be increasing")
+		}
+		if math.IsNaN(q.Value) || math.IsInf(q.Value, 0) {
+			return fmt.Errorf("invalid summary value: %v", q.Value)
+		}
+	}
+	if math.IsNaN(s.Sum) || math.IsInf(s.Sum, 0) {
+		return fmt.Errorf("invalid summary sum: %v", s.Sum)
+	}
+	return nil
+}
+
+// Value возвращает значение квантиля q или false, если сводка его не содержит.
+func (s *Summary) Value(q float64) (float64, bool) {
+	for _, quantile := range s.Quantiles {
+		if quantile.Quantile == q {
+			return quantile.Value, true
+		}
+	}
+	return 0, false
+}
+
+// Clone возвращает копию сводки.
+func (s *Summary) Clone() *Summary {
+	return &Summary{
+		Quantiles: append([]Quantile(nil), s.Quantiles...),
+		Count:     s.Count,
+		Sum:       s.Sum,
+	}
+}
+
+// String возвращает сводку для списка метрик: число и сумму значений и квантили в виде q0.5=значение.
+func (s *Summary) String() string {
+	str := "count=" + strconv.FormatUint(s.Count, 10) + " sum=" + strconv.FormatFloat(s.Sum, 'g', -1, 64)
+	for _, q := range s.Quantiles {
+		str += " q" + strconv.FormatFloat(q.Quantile, 'g', -1, 64) + "=" + strconv.FormatFloat(q.Value, 'g', -1, 64)
+	}
+	return str
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummary_Validate(t *testing.T) {
+	assert.NoError(t, (&Summary{Quantiles: []Quantile{{0.5, 1}, {0.99, 3}}, Count: 10, Sum: 12}).Validate())
+	assert.NoError(t, (&Summary{Count: 2, Sum: 1}).Validate())
+
+	for _, s := range []Summary{
+		{Quantiles: []Quantile{{1.5, 1}}},
+		{Quantiles: []Quantile{{math.NaN(), 1}}},
+		{Quantiles: []Quantile{{0.9, 1}, {0.5, 1}}},
+		{Quantiles: []Quantile{{0.5, math.Inf(1)}}},
+		{Sum: math.NaN()},
+	} {
+		assert.Error(t, s.Validate(), s)
+	}
+}
+
+func TestSummary_Value(t *testing.T) {
+	s := &Summary{Quantiles: []Quantile{{0.5, 1}, {0.99, 3}}, Count: 10, Sum: 12}
+	value, ok := s.Value(0.99)
+	assert.True(t, ok)
+	assert.Equal(t, 3.0, value)
+	_, ok = s.Value(0.9)
+	assert.False(t, ok)
+	assert.Equal(t, "count=10 sum=12 q0.5=1 q0.99=3", s.String())
+}
+
+func TestMemoryStorage_Summary(t *testing.T) {
+	s := NewMemoryStorage()
+	web1 := map[string]string{"host": "web-1"}
+
+	// Сводка заменяется последней присланной
+	require.NoError(t, s.UpdateMetricValue(Metrics{ID: "latency", MType: "summary",
+		Summary: &Summary{Quantiles: []Quantile{{0.5, 1}}, Count: 4, Sum: 5}}))
+	require.NoError(t, s.UpdateMetricsValue([]Metrics{{ID: "latency", MType: "summary",
+		Summary: &Summary{Quantiles: []Quantile{{0.5, 2}}, Count: 6, Sum: 9}}}))
+	require.NoError(t, s.UpdateMetricValue(Metrics{ID: "latency", MType: "summary", Labels: web1,
+		Summary: &Summary{Count: 1, Sum: 1}}))
+
+	stored, err := s.GetMetric(Metrics{ID: "latency"})
+	require.NoError(t, err)
+	assert.Equal(t, "summary", stored.MType)
+	assert.Equal(t, &Summary{Quantiles: []Quantile{{0.5, 2}}, Count: 6, Sum: 9}, stored.Summary)
+
+	// Возвращается копия: изменение ответа не меняет хранилище
+	stored.Summary.Quantiles[0].Value = 100
+	value, err := s.GetMetricByName(Metrics{ID: "latency", MType: "summary"})
+	require.NoError(t, err)
+	assert.Equal(t, 6.0, value)
+
+	assert.Error(t, s.UpdateMetricValue(Metrics{ID: "latency", MType: "summary",
+		Summary: &Summary{Quantiles: []Quantile{{2, 1}}}}))
+	assert.Error(t, s.UpdateMetricValue(Metrics{ID: "latency", MType: "summary"}))
+
+	metrics, err := s.ListMetrics()
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	assert.Equal(t, web1, metrics[1].Labels)
+	assert.Contains(t, s.GetAllMetrics(), "latency/count=6 sum=9 q0.5=2")
+
+	// Сводки сохраняются в снимке хранилища
+	restored := NewMemoryStorage()
+	restored.restore(s.snapshot())
+	assert.Equal(t, s.Summaries, restored.Summaries)
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// walMaxSize - размер журнала предзаписи, после которого в синхронном режиме делается контрольная точка.
+const walMaxSize = 4 << 20
+
+// walRecord представляет собой запись журнала предзаписи: пакет обновлений метрик с порядковым номером.
+type walRecord struct {
+	Seq     uint64    `json:"seq"`
+	Metrics []Metrics `json:"metrics"`
+}
+
+// wal представляет собой журнал предзаписи обновлений метрик.
+// Каждое обновление дописывается в конец файла и сбрасывается на диск до того, как применяется в памяти.
+type wal struct {
+	mu   sync.Mutex
+	file *os.File
+	seq  uint64
+	size int64
+}
+
+// walPath возвращает путь к журналу предзаписи для файла снимка метрик.
+func walPath(snapshotPath string) string {
+	return snapshotPath + ".wal"
+}
+
+// walTail описывает конец целой части журнала предзаписи.
+type walTail struct {
+	// seq - наибольший номер записи в журнале, в том числе уже учтённой в снимке.
+	seq uint64
+	// size - смещение конца последней целой записи.
+	size int64
+}
+
+// openWAL открывает журнал предзаписи для дозаписи, продолжая нумерацию с seq.
+// Журнал обрезается до size байт: так удаляется оборванная при сбое запись, а при size, равном 0, - всё прежнее содержимое.
+func openWAL(path string, seq uint64, size int64) (*wal, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.Size() > size {
+		if err := file.Truncate(size); err != nil {
+			file.Close()
+			return nil, err
+		}
+		if err := file.Sync(); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return &wal{file: file, seq: seq, size: min(info.Size(), size)}, nil
+}
+
+// append записывает пакет обновлений в журнал, сбрасывает его на диск и затем применяет обновления через apply.
+// Возвращает размер журнала после записи.
+func (w *wal) append(metrics []Metrics, apply func() error) (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	data, err := json.Marshal(walRecord{Seq: w.seq + 1, Metrics: metrics})
+	if err != nil {
+		return w.size, err
+	}
+	data = append(data, '\n')
+	if _, err := w.file.Write(data); err != nil {
+		return w.size, err
+	}
+	if err := w.file.Sync(); err != nil {
+		return w.size, err
+	}
+	w.seq++
+	w.size += int64(len(data))
+
+	return w.size, apply()
+}
+
+// checkpoint сохраняет снимок через write с номером последней записи и очищает журнал.
+// Пока выполняется контрольная точка, новые записи в журнал не принимаются.
+func (w *wal) checkpoint(write func(seq uint64) error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := write(w.seq); err != nil {
+		return err
+	}
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	w.size = 0
+	return w.file.Sync()
+}
+
+// close закрывает файл журнала.
+func (w *wal) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
+
+// readWAL считывает из журнала записи с номером больше after и возвращает конец его целой части.
+// Оборванная при сбое последняя запись пропускается.
+func readWAL(path string, after uint64) ([]walRecord, walTail, error) {
+	var tail walTail
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, tail, err
+	}
+	defer file.Close()
+
+	var records []walRecord
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				fmt.Println("Пропущена оборванная запись журнала предзаписи:", path)
+			}
+			return records, tail, nil
+		}
+		if err != nil {
+			return records, tail, err
+		}
+
+		var record walRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return records, tail, fmt.Errorf("corrupted wal record after seq %d: %w", tail.seq, err)
+		}
+		tail.size += int64(len(line))
+		tail.seq = max(tail.seq, record.Seq)
+		if record.Seq > after {
+			records = append(records, record)
+			after = record.Seq
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadWAL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json.wal")
+	w, err := openWAL(path, 0, 0)
+	require.NoError(t, err)
+
+	apply := func() error { return nil }
+	for _, delta := range []int64{1, 2, 3} {
+		_, err := w.append([]Metrics{{ID: "counter1", MType: "counter", Delta: int64Ptr(delta)}}, apply)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.close())
+
+	records, tail, err := readWAL(path, 1)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, uint64(2), records[0].Seq)
+	assert.Equal(t, int64(3), *records[1].Metrics[0].Delta)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, walTail{seq: 3, size: info.Size()}, tail)
+}
+
+func TestReadWAL_TornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json.wal")
+
+	// Последняя запись оборвана при сбое и пропускается
+	require.NoError(t, os.WriteFile(path, []byte(
+		`{"seq":1,"metrics":[{"id":"gauge1","type":"gauge","value":1.5}]}`+"\n"+
+			`{"seq":2,"metrics":[{"id":"gau`), 0644))
+
+	records, tail, err := readWAL(path, 0)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, 1.5, *records[0].Metrics[0].Value)
+
+	// Перед дозаписью оборванная запись отрезается, и новые записи читаются после перезапуска
+	w, err := openWAL(path, tail.seq, tail.size)
+	require.NoError(t, err)
+	_, err = w.append([]Metrics{{ID: "gauge1", MType: "gauge", Value: float64Ptr(2.5)}}, func() error { return nil })
+	require.NoError(t, err)
+	require.NoError(t, w.close())
+
+	records, _, err = readWAL(path, 0)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, uint64(2), records[1].Seq)
+	assert.Equal(t, 2.5, *records[1].Metrics[0].Value)
+
+	// Повреждённая запись в середине журнала - ошибка
+	require.NoError(t, os.WriteFile(path, []byte("garbage\n"+
+		`{"seq":1,"metrics":[]}`+"\n"), 0644))
+	_, _, err = readWAL(path, 0)
+	assert.Error(t, err)
+}
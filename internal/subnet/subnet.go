@@ -0,0 +1,81 @@
+// Package subnet проверяет, что адреса клиентов входят в доверенную подсеть агентов.
+//
+// Адрес клиента - это адрес соединения. Адрес, переданный клиентом в заголовке X-Real-IP (или в метаданных
+// x-real-ip gRPC), учитывается только для соединений от доверенных прокси: иначе любой клиент мог бы указать
+// в заголовке адрес из доверенной подсети.
+package subnet
+
+import (
+	"fmt"
+	"net"
+)
+
+// Trusted описывает доверенную подсеть агентов и прокси, которым разрешено передавать адрес клиента.
+type Trusted struct {
+	subnet  *net.IPNet
+	proxies []*net.IPNet
+}
+
+// Parse разбирает доверенную подсеть subnet и подсети доверенных прокси proxies в формате CIDR.
+// Для пустой подсети возвращает nil: проверка отключена и разрешены любые адреса.
+func Parse(subnet string, proxies []string) (*Trusted, error) {
+	if subnet == "" {
+		return nil, nil
+	}
+	t := &Trusted{}
+	var err error
+	if _, t.subnet, err = net.ParseCIDR(subnet); err != nil {
+		return nil, fmt.Errorf("trusted subnet: %w", err)
+	}
+	for _, p := range proxies {
+		_, proxy, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy: %w", err)
+		}
+		t.proxies = append(t.proxies, proxy)
+	}
+	return t, nil
+}
+
+// Allows сообщает, входит ли клиент в доверенную подсеть. remoteAddr - адрес соединения в виде host:port
+// или host, realIP - адрес, переданный клиентом, или пустая строка. Для nil разрешены любые адреса.
+func (t *Trusted) Allows(remoteAddr, realIP string) bool {
+	if t == nil {
+		return true
+	}
+	ip := t.ClientIP(remoteAddr, realIP)
+	return ip != nil && t.subnet.Contains(ip)
+}
+
+// AllowsAddr сообщает, входит ли в доверенную подсеть адрес соединения addr.
+// Используется для протоколов, в которых клиент не передает свой адрес.
+func (t *Trusted) AllowsAddr(addr net.Addr) bool {
+	if t == nil {
+		return true
+	}
+	return addr != nil && t.Allows(addr.String(), "")
+}
+
+// ClientIP возвращает адрес клиента: realIP, если соединение пришло от доверенного прокси, иначе адрес соединения.
+// Для некорректного адреса возвращает nil.
+func (t *Trusted) ClientIP(remoteAddr, realIP string) net.IP {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if realIP == "" || ip == nil || !t.fromProxy(ip) {
+		return ip
+	}
+	return net.ParseIP(realIP)
+}
+
+// fromProxy сообщает, принадлежит ли адрес ip доверенному прокси.
+func (t *Trusted) fromProxy(ip net.IP) bool {
+	for _, proxy := range t.proxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package subnet
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	trusted, err := Parse("", []string{"bad"})
+	require.NoError(t, err)
+	assert.Nil(t, trusted)
+	assert.True(t, trusted.Allows("10.0.0.1:1234", ""))
+	assert.True(t, trusted.AllowsAddr(nil))
+
+	_, err = Parse("192.168.1.0", nil)
+	assert.Error(t, err)
+	_, err = Parse("192.168.1.0/24", []string{"10.0.0.1"})
+	assert.Error(t, err)
+}
+
+func TestTrusted_Allows(t *testing.T) {
+	trusted, err := Parse("192.168.1.0/24", []string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		realIP     string
+		want       bool
+	}{
+		{name: "connection in subnet", remoteAddr: "192.168.1.20:1234", want: true},
+		{name: "connection outside subnet", remoteAddr: "127.0.0.1:1234"},
+		{name: "bare host", remoteAddr: "192.168.1.20", want: true},
+		{name: "header from proxy", remoteAddr: "10.0.0.1:1234", realIP: "192.168.1.10", want: true},
+		{name: "header from proxy outside subnet", remoteAddr: "10.0.0.1:1234", realIP: "172.16.0.1"},
+		{name: "invalid header from proxy", remoteAddr: "10.0.0.1:1234", realIP: "not-an-ip"},
+		{name: "proxy without header", remoteAddr: "10.0.0.1:1234"},
+		{name: "header ignored from client", remoteAddr: "127.0.0.1:1234", realIP: "192.168.1.10"},
+		{name: "header cannot move client out", remoteAddr: "192.168.1.20:1234", realIP: "127.0.0.1", want: true},
+		{name: "invalid address", remoteAddr: "pipe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, trusted.Allows(tt.remoteAddr, tt.realIP))
+		})
+	}
+
+	assert.True(t, trusted.AllowsAddr(&net.UDPAddr{IP: net.ParseIP("192.168.1.5"), Port: 8125}))
+	assert.False(t, trusted.AllowsAddr(&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 2003}))
+	assert.False(t, trusted.AllowsAddr(nil))
+}
@@ -0,0 +1,105 @@
+// Package tenant описывает арендаторов сервера: их имена, ключи подписи и передачу
+// аутентифицированного арендатора через контекст запроса.
+//
+// Арендатор передает свое имя в заголовке X-Tenant, время подписи в заголовке X-Timestamp и подписывает
+// одним из своих ключей метод, путь со строкой запроса, время подписи и тело запроса (см. SignedData).
+// Поэтому подпись запроса чтения без тела нельзя повторить для другого ресурса или спустя MaxSkew.
+// Запросы без имени относятся к арендатору по умолчанию с пустым именем.
+//
+// Арендаторы поддерживаются только в HTTP API. Метрики, принятые по gRPC, StatsD, Graphite и по протоколу
+// InfluxDB поверх TCP, всегда записываются в пространство арендатора по умолчанию: в этих протоколах
+// нет способа передать имя арендатора вместе с подписью, поэтому их следует открывать только
+// для агентов арендатора по умолчанию.
+package tenant
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/SerjZimmer/devops/internal/hash"
+)
+
+// Header - HTTP-заголовок с именем арендатора.
+const Header = "X-Tenant"
+
+// TimestampHeader - HTTP-заголовок с временем подписи запроса арендатора в секундах Unix.
+const TimestampHeader = "X-Timestamp"
+
+// MaxSkew - допустимое расхождение времени подписи запроса арендатора с часами сервера.
+const MaxSkew = 5 * time.Minute
+
+// validName ограничивает имена арендаторов символами, безопасными в именах файлов.
+var validName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidName проверяет, что name можно использовать как имя арендатора.
+func ValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
+// Keys сопоставляет именам арендаторов их ключи подписи.
+type Keys map[string][]string
+
+// Match ищет ключ арендатора tenant, которым подписаны данные data.
+// Перебираются все ключи, чтобы время проверки не зависело от того, какой из них подошел.
+func (k Keys) Match(tenant string, data []byte, sign string) (string, bool) {
+	var (
+		matched string
+		found   bool
+	)
+	for _, key := range k[tenant] {
+		if hash.Verify(key, data, sign) && !found {
+			matched, found = key, true
+		}
+	}
+	return matched, found
+}
+
+// SignedData возвращает данные, которые подписывает арендатор: метод, путь со строкой запроса uri
+// и время подписи timestamp, каждое в отдельной строке, а за ними тело запроса body.
+func SignedData(method, uri, timestamp string, body []byte) []byte {
+	var b bytes.Buffer
+	b.Grow(len(method) + len(uri) + len(timestamp) + len(body) + 3)
+	b.WriteString(method)
+	b.WriteByte('\n')
+	b.WriteString(uri)
+	b.WriteByte('\n')
+	b.WriteString(timestamp)
+	b.WriteByte('\n')
+	b.Write(body)
+	return b.Bytes()
+}
+
+// Timestamp возвращает значение заголовка TimestampHeader для времени t.
+func Timestamp(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+// CheckTimestamp проверяет, что время подписи timestamp отличается от now не больше чем на MaxSkew.
+func CheckTimestamp(timestamp string, now time.Time) error {
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("некорректное время подписи")
+	}
+	if d := now.Sub(time.Unix(sec, 0)); d > MaxSkew || d < -MaxSkew {
+		return errors.New("время подписи истекло")
+	}
+	return nil
+}
+
+// contextKey - ключ контекста с именем арендатора.
+type contextKey struct{}
+
+// NewContext возвращает контекст с именем аутентифицированного арендатора.
+func NewContext(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, contextKey{}, tenant)
+}
+
+// FromContext возвращает имя арендатора из контекста или пустую строку для арендатора по умолчанию.
+func FromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(contextKey{}).(string)
+	return tenant
+}
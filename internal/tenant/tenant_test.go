@@ -0,0 +1,61 @@
+package tenant
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SerjZimmer/devops/internal/hash"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidName(t *testing.T) {
+	for _, name := range []string{"team-a", "team_b", "T1"} {
+		assert.True(t, ValidName(name), name)
+	}
+	for _, name := range []string{"", "../etc", "team a", "team.a", string(make([]byte, 65))} {
+		assert.False(t, ValidName(name), name)
+	}
+}
+
+func TestKeys_Match(t *testing.T) {
+	keys := Keys{"team-a": {"old", "new"}, "team-b": {"other"}}
+	data := []byte("metrics")
+
+	key, ok := keys.Match("team-a", data, hash.Sign("new", data))
+	assert.True(t, ok)
+	assert.Equal(t, "new", key)
+
+	key, ok = keys.Match("team-a", data, hash.Sign("old", data))
+	assert.True(t, ok)
+	assert.Equal(t, "old", key)
+
+	_, ok = keys.Match("team-a", data, hash.Sign("other", data))
+	assert.False(t, ok)
+	_, ok = keys.Match("team-c", data, hash.Sign("other", data))
+	assert.False(t, ok)
+}
+
+func TestContext(t *testing.T) {
+	assert.Equal(t, "", FromContext(context.Background()))
+	assert.Equal(t, "team-a", FromContext(NewContext(context.Background(), "team-a")))
+}
+
+func TestSignedData(t *testing.T) {
+	data := SignedData("GET", "/value/gauge/a?match=host=web-1", "100", nil)
+	assert.Equal(t, "GET\n/value/gauge/a?match=host=web-1\n100\n", string(data))
+	assert.NotEqual(t, data, SignedData("GET", "/value/gauge/b", "100", nil))
+	assert.NotEqual(t, data, SignedData("GET", "/value/gauge/a?match=host=web-1", "101", nil))
+	assert.Equal(t, "POST\n/update/\n100\n{}", string(SignedData("POST", "/update/", "100", []byte("{}"))))
+}
+
+func TestCheckTimestamp(t *testing.T) {
+	now := time.Unix(1000, 0)
+	assert.NoError(t, CheckTimestamp(Timestamp(now), now))
+	assert.NoError(t, CheckTimestamp(Timestamp(now.Add(-MaxSkew)), now))
+	assert.NoError(t, CheckTimestamp(Timestamp(now.Add(MaxSkew)), now))
+	assert.Error(t, CheckTimestamp(Timestamp(now.Add(-MaxSkew-time.Second)), now))
+	assert.Error(t, CheckTimestamp(Timestamp(now.Add(MaxSkew+time.Second)), now))
+	assert.Error(t, CheckTimestamp("", now))
+	assert.Error(t, CheckTimestamp("soon", now))
+}
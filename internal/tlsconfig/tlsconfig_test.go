@@ -0,0 +1,145 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCert представляет собой сертификат с ключом, записанные во временные файлы.
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// newTestCert выпускает сертификат, подписанный parent (или самоподписанный при nil parent).
+func newTestCert(t *testing.T, name string, parent *testCert, isCA bool) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	c := &testCert{cert: cert, key: key, certFile: filepath.Join(dir, name+".crt"), keyFile: filepath.Join(dir, name+".key")}
+	require.NoError(t, os.WriteFile(c.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(c.keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600))
+	return c
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, true)
+	serverCert := newTestCert(t, "server", ca, false)
+	agentCert := newTestCert(t, "agent", ca, false)
+	otherCA := newTestCert(t, "other-ca", nil, true)
+	strangerCert := newTestCert(t, "stranger", otherCA, false)
+
+	serverConfig, err := Server(serverCert.certFile, serverCert.keyFile, ca.certFile)
+	require.NoError(t, err)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = serverConfig
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(config *tls.Config) (string, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body := make([]byte, 64)
+		n, _ := resp.Body.Read(body)
+		return string(body[:n]), nil
+	}
+
+	agentConfig, err := Client(ca.certFile, agentCert.certFile, agentCert.keyFile)
+	require.NoError(t, err)
+	name, err := get(agentConfig)
+	require.NoError(t, err)
+	assert.Equal(t, "agent", name)
+
+	// Клиент без сертификата.
+	noCertConfig, err := Client(ca.certFile, "", "")
+	require.NoError(t, err)
+	_, err = get(noCertConfig)
+	assert.Error(t, err)
+
+	// Сертификат, подписанный чужим удостоверяющим центром.
+	strangerConfig, err := Client(ca.certFile, strangerCert.certFile, strangerCert.keyFile)
+	require.NoError(t, err)
+	_, err = get(strangerConfig)
+	assert.Error(t, err)
+
+	// Клиент, не доверяющий удостоверяющему центру сервера.
+	untrustedConfig, err := Client(otherCA.certFile, agentCert.certFile, agentCert.keyFile)
+	require.NoError(t, err)
+	_, err = get(untrustedConfig)
+	assert.Error(t, err)
+}
+
+func TestServer_WithoutClientCA(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, true)
+	serverCert := newTestCert(t, "server", ca, false)
+
+	config, err := Server(serverCert.certFile, serverCert.keyFile, "")
+	require.NoError(t, err)
+	assert.Equal(t, tls.NoClientCert, config.ClientAuth)
+	assert.Len(t, config.Certificates, 1)
+}
+
+func TestLoadErrors(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.pem")
+	notPEM := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(notPEM, []byte("not a certificate"), 0600))
+	ca := newTestCert(t, "ca", nil, true)
+
+	_, err := Server(missing, missing, "")
+	assert.Error(t, err)
+	_, err = Server(ca.certFile, ca.keyFile, notPEM)
+	assert.Error(t, err)
+	_, err = Client(notPEM, "", "")
+	assert.Error(t, err)
+	_, err = Client("", ca.certFile, "")
+	assert.Error(t, err)
+}
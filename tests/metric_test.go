@@ -2,16 +2,28 @@ package tests
 
 import (
 	"bytes"
+	stdgzip "compress/gzip"
+	"crypto/rand"
+	"crypto/rsa"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/SerjZimmer/devops/internal/api"
+	"github.com/SerjZimmer/devops/internal/encryption"
+	"github.com/SerjZimmer/devops/internal/gzip"
+	"github.com/SerjZimmer/devops/internal/hash"
 	"github.com/SerjZimmer/devops/internal/storage"
+	"github.com/SerjZimmer/devops/internal/tenant"
 	"github.com/stretchr/testify/assert"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestUpdateMetricJson(t *testing.T) {
@@ -53,7 +65,7 @@ func TestUpdateMetricJson(t *testing.T) {
 			ExpectedBody:   "Некорректные данные в JSON\n",
 		},
 	}
-	handler := api.NewHandler(storage.TestMetricStorage())
+	handler := api.NewHandler(storage.TestMetricStorage(), "", nil)
 	for _, tc := range testCases {
 		t.Run(tc.Name, func(t *testing.T) {
 
@@ -74,7 +86,7 @@ func TestUpdateMetricJson(t *testing.T) {
 }
 
 func Test_GetMetricsList(t *testing.T) {
-	handler := api.NewHandler(storage.TestMetricStorage())
+	handler := api.NewHandler(storage.TestMetricStorage(), "", nil)
 
 	req, err := http.NewRequest("GET", "/", nil)
 	assert.NoError(t, err)
@@ -91,7 +103,7 @@ func Test_GetMetricsList(t *testing.T) {
 }
 
 func Test_GetMetric(t *testing.T) {
-	handler := api.NewHandler(storage.TestMetricStorage())
+	handler := api.NewHandler(storage.TestMetricStorage(), "", nil)
 
 	tests := []struct {
 		name           string
@@ -160,7 +172,7 @@ func Test_GetMetric(t *testing.T) {
 }
 
 func TestGetMetricJSON(t *testing.T) {
-	handler := api.NewHandler(storage.TestMetricStorage())
+	handler := api.NewHandler(storage.TestMetricStorage(), "", nil)
 
 	testCases := []struct {
 		Name           string
@@ -198,8 +210,55 @@ func TestGetMetricJSON(t *testing.T) {
 	}
 }
 
+func TestGetMetricJSON_SeparateTypes(t *testing.T) {
+	handler := api.NewHandler(storage.TestMetricStorage(), "", nil)
+
+	updates := []string{
+		`{"type": "gauge", "id": "foo", "value": 0.5}`,
+		`{"type": "counter", "id": "foo", "delta": 9007199254740993}`,
+	}
+	for _, body := range updates {
+		req, err := http.NewRequest("POST", "/update/", strings.NewReader(body))
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+		handler.UpdateMetricJSON(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	testCases := []struct {
+		Name         string
+		RequestBody  string
+		ExpectedBody string
+	}{
+		{
+			Name:         "Gauge",
+			RequestBody:  `{"type": "gauge", "id": "foo"}`,
+			ExpectedBody: "{\"id\":\"foo\",\"type\":\"gauge\",\"value\":0.5}\n",
+		},
+		{
+			Name:         "Counter",
+			RequestBody:  `{"type": "counter", "id": "foo"}`,
+			ExpectedBody: "{\"id\":\"foo\",\"type\":\"counter\",\"delta\":9007199254740993}\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/value/", strings.NewReader(tc.RequestBody))
+			assert.NoError(t, err)
+
+			w := httptest.NewRecorder()
+
+			handler.GetMetricJSON(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tc.ExpectedBody, w.Body.String())
+		})
+	}
+}
+
 func TestUpdateMetric(t *testing.T) {
-	handler := api.NewHandler(storage.TestMetricStorage())
+	handler := api.NewHandler(storage.TestMetricStorage(), "", nil)
 
 	testCases := []struct {
 		Name           string
@@ -250,7 +309,7 @@ func TestUpdateMetric(t *testing.T) {
 }
 
 func TestUpdateMetricsJSON(t *testing.T) {
-	handler := api.NewHandler(storage.TestMetricStorage())
+	handler := api.NewHandler(storage.TestMetricStorage(), "", nil)
 
 	// Подготовка тестовых данных
 	testMetrics := []storage.Metrics{
@@ -282,6 +341,243 @@ func TestUpdateMetricsJSON(t *testing.T) {
 	assert.ElementsMatch(t, testMetrics, responseMetrics)
 }
 
+func TestQueryRange(t *testing.T) {
+	handler := api.NewHandler(storage.TestMetricStorage(), "", nil)
+
+	for _, body := range []string{`{"type": "gauge", "id": "HeapAlloc", "value": 1}`, `{"type": "gauge", "id": "HeapAlloc", "value": 2}`} {
+		req, err := http.NewRequest("POST", "/update/", strings.NewReader(body))
+		assert.NoError(t, err)
+		handler.UpdateMetricJSON(httptest.NewRecorder(), req)
+	}
+
+	t.Run("Raw Points", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/api/v1/query_range?name=HeapAlloc", nil)
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+
+		handler.QueryRange(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var series storage.Series
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &series))
+		assert.Equal(t, "gauge", series.MType)
+		if assert.Len(t, series.Points, 2) {
+			assert.Equal(t, float64(1), series.Points[0].Value)
+			assert.Equal(t, float64(2), series.Points[1].Value)
+		}
+	})
+
+	t.Run("Unknown Metric", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/api/v1/query_range?name=Unknown", nil)
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+
+		handler.QueryRange(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Bad Request", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/api/v1/query_range?name=HeapAlloc&step=abc", nil)
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+
+		handler.QueryRange(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestWriteInflux(t *testing.T) {
+	handler := api.NewHandler(storage.TestMetricStorage(), "", nil)
+
+	t.Run("Valid Lines", func(t *testing.T) {
+		body := "# comment\n" +
+			"mem,host=a value=1.5,free=2i 1700000000\n" +
+			"\n" +
+			"requests,metric_type=counter value=3i\n" +
+			"requests,metric_type=counter value=4i\n"
+		req, err := http.NewRequest("POST", "/write?precision=s", strings.NewReader(body))
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+
+		handler.WriteInflux(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assertMetricValue(t, handler, "gauge", "mem", 1.5)
+		assertMetricValue(t, handler, "gauge", "mem_free", 2)
+		assertMetricValue(t, handler, "counter", "requests", 7)
+	})
+
+	t.Run("Line Errors", func(t *testing.T) {
+		body := "disk value=10\n" +
+			"disk value=abc\n" +
+			"disk msg=\"text\"\n"
+		req, err := http.NewRequest("POST", "/write", strings.NewReader(body))
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+
+		handler.WriteInflux(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		var result struct {
+			Accepted int
+			Errors   []struct {
+				Line  int
+				Error string
+			}
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		assert.Equal(t, 1, result.Accepted)
+		if assert.Len(t, result.Errors, 2) {
+			assert.Equal(t, 2, result.Errors[0].Line)
+			assert.Equal(t, 3, result.Errors[1].Line)
+		}
+		assertMetricValue(t, handler, "gauge", "disk", 10)
+	})
+
+	t.Run("Point Timestamps", func(t *testing.T) {
+		ts := time.Now().Add(-5 * time.Minute).Truncate(time.Second)
+		body := fmt.Sprintf("load value=0.5 %d\nload value=0.7 99999999999999999\n", ts.Unix())
+		req, err := http.NewRequest("POST", "/write?precision=s", strings.NewReader(body))
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+
+		handler.WriteInflux(w, req)
+
+		// Метка времени, не помещающаяся в наносекунды, - ошибка строки
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), `"line":2`)
+
+		req, err = http.NewRequest("GET", "/api/v1/query_range?name=load", nil)
+		assert.NoError(t, err)
+		w = httptest.NewRecorder()
+		handler.QueryRange(w, req)
+
+		var series storage.Series
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &series))
+		if assert.Len(t, series.Points, 1) {
+			assert.True(t, ts.Equal(series.Points[0].Timestamp))
+		}
+	})
+
+	t.Run("Bad Precision", func(t *testing.T) {
+		req, err := http.NewRequest("POST", "/write?precision=h", strings.NewReader("mem value=1"))
+		assert.NoError(t, err)
+		w := httptest.NewRecorder()
+
+		handler.WriteInflux(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+// assertMetricValue проверяет значение метрики через JSON API.
+func assertMetricValue(t *testing.T, handler *api.Handler, mType, id string, expected float64) {
+	t.Helper()
+	req, err := http.NewRequest("POST", "/value/", strings.NewReader(`{"type":"`+mType+`","id":"`+id+`"}`))
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+
+	handler.GetMetricJSON(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var m storage.Metrics
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &m))
+	assert.Equal(t, expected, m.Float64())
+}
+
+func TestOTLPMetrics(t *testing.T) {
+	handler := api.NewHandler(storage.TestMetricStorage(), "", nil)
+
+	t.Run("Protobuf", func(t *testing.T) {
+		body, err := proto.Marshal(&colmetricspb.ExportMetricsServiceRequest{
+			ResourceMetrics: []*metricspb.ResourceMetrics{{
+				ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{
+					{Name: "otel.queue", Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+						DataPoints: []*metricspb.NumberDataPoint{{Value: &metricspb.NumberDataPoint_AsInt{AsInt: 7}}},
+					}}},
+					{Name: "otel.latency", Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+						AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+						DataPoints: []*metricspb.HistogramDataPoint{
+							{Count: 5, Sum: float64Ptr(1.5), ExplicitBounds: []float64{0.1, 1}, BucketCounts: []uint64{3, 2, 0}},
+						},
+					}}},
+				}}},
+			}},
+		})
+		assert.NoError(t, err)
+		req, err := http.NewRequest("POST", "/v1/metrics", bytes.NewReader(body))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		w := httptest.NewRecorder()
+
+		handler.OTLPMetrics(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/x-protobuf", w.Header().Get("Content-Type"))
+		assertMetricValue(t, handler, "gauge", "otel.queue", 7)
+		assertMetricValue(t, handler, "histogram", "otel.latency", 5)
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		body := `{"resourceMetrics":[{"scopeMetrics":[{"metrics":[
+			{"name":"otel.requests","sum":{"isMonotonic":true,"aggregationTemporality":1,"dataPoints":[{"asInt":"3"}]}},
+			{"name":"otel.sizes","summary":{"dataPoints":[{"count":"4","sum":10,"quantileValues":[{"quantile":0.5,"value":2}]}]}},
+			{"name":"otel.exp","exponentialHistogram":{"dataPoints":[{}]}}
+		]}]}]}`
+		req, err := http.NewRequest("POST", "/v1/metrics", strings.NewReader(body))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		handler.OTLPMetrics(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"partialSuccess":{"rejectedDataPoints":"1","errorMessage":"unsupported metric type for otel.exp"}}`, w.Body.String())
+		assertMetricValue(t, handler, "counter", "otel.requests", 3)
+		assertMetricValue(t, handler, "summary", "otel.sizes", 4)
+	})
+
+	t.Run("Unsupported Content Type", func(t *testing.T) {
+		req, err := http.NewRequest("POST", "/v1/metrics", strings.NewReader("x"))
+		assert.NoError(t, err)
+		req.Header.Set("Content-Type", "text/plain")
+		w := httptest.NewRecorder()
+
+		handler.OTLPMetrics(w, req)
+
+		assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	})
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	handler := api.NewHandler(storage.TestMetricStorage(), "", nil)
+
+	for _, body := range []string{
+		`{"type": "gauge", "id": "HeapAlloc", "value": 1.5}`,
+		`{"type": "counter", "id": "PollCount", "delta": 3}`,
+	} {
+		req, err := http.NewRequest("POST", "/update/", strings.NewReader(body))
+		assert.NoError(t, err)
+		handler.UpdateMetricJSON(httptest.NewRecorder(), req)
+	}
+
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+
+	handler.PrometheusMetrics(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"))
+	body := w.Body.String()
+	assert.Contains(t, body, "# TYPE HeapAlloc gauge\nHeapAlloc 1.5\n")
+	assert.Contains(t, body, "# TYPE PollCount counter\nPollCount 3\n")
+	assert.Contains(t, body, "# TYPE devops_server_metric_updates_total counter\n")
+	assert.Contains(t, body, "# TYPE devops_server_uptime_seconds gauge\n")
+}
+
 // Вспомогательная функция для создания указателя на float64
 func float64Ptr(value float64) *float64 {
 	return &value
@@ -291,3 +587,256 @@ func float64Ptr(value float64) *float64 {
 func int64Ptr(value int64) *int64 {
 	return &value
 }
+
+func TestSignedGzipRequest(t *testing.T) {
+	const key = "testkey"
+	handler := api.NewHandler(storage.TestMetricStorage(), key, nil)
+	chain := handler.HashSHA256Middleware(gzip.GzipMiddleware(http.HandlerFunc(handler.UpdateMetricJSON)))
+
+	var compressed bytes.Buffer
+	zw := stdgzip.NewWriter(&compressed)
+	zw.Write([]byte(`{"type": "counter", "id": "signed", "delta": 5}`))
+	zw.Close()
+
+	send := func(sign string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader(compressed.Bytes()))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", "gzip")
+		req.Header.Set(hash.Header, sign)
+		w := httptest.NewRecorder()
+		chain.ServeHTTP(w, req)
+		return w
+	}
+
+	// Подпись считается по сжатому телу в том виде, в каком оно передается по сети.
+	w := send(hash.Sign(key, compressed.Bytes()))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, hash.Verify(key, w.Body.Bytes(), w.Header().Get(hash.Header)))
+	assertMetricValue(t, handler, "counter", "signed", 5)
+
+	w = send(hash.Sign(key, []byte(`{"type": "counter", "id": "signed", "delta": 5}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assertMetricValue(t, handler, "counter", "signed", 5)
+}
+
+func TestEncryptedSignedGzipRequest(t *testing.T) {
+	const key = "testkey"
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	handler := api.NewHandler(storage.TestMetricStorage(), key, nil)
+	chain := handler.HashSHA256Middleware(encryption.DecryptMiddleware(privateKey)(
+		gzip.GzipMiddleware(http.HandlerFunc(handler.UpdateMetricsJSON))))
+
+	var compressed bytes.Buffer
+	zw := stdgzip.NewWriter(&compressed)
+	zw.Write([]byte(`[{"type": "counter", "id": "encrypted", "delta": 7}]`))
+	zw.Close()
+	body, err := encryption.Encrypt(&privateKey.PublicKey, compressed.Bytes())
+	assert.NoError(t, err)
+
+	// Агент сжимает, затем шифрует и подписывает зашифрованное тело.
+	req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set(encryption.Header, encryption.Scheme)
+	req.Header.Set(hash.Header, hash.Sign(key, body))
+	w := httptest.NewRecorder()
+	chain.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assertMetricValue(t, handler, "counter", "encrypted", 7)
+}
+
+func TestTenantIsolation(t *testing.T) {
+	handler := api.NewHandler(storage.TestMetricStorage(), "", tenant.Keys{
+		"team-a": {"key-a", "key-a2"},
+		"team-b": {"key-b"},
+	})
+	update := handler.HashSHA256Middleware(http.HandlerFunc(handler.UpdateMetricJSON))
+	list := handler.HashSHA256Middleware(http.HandlerFunc(handler.GetMetricsList))
+
+	doAt := func(h http.Handler, method, target, name, key, body string, at time.Time) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		ts := tenant.Timestamp(at)
+		if name != "" {
+			req.Header.Set(tenant.Header, name)
+			req.Header.Set(tenant.TimestampHeader, ts)
+		}
+		if key != "" {
+			data := []byte(body)
+			if name != "" {
+				data = tenant.SignedData(method, target, ts, data)
+			}
+			req.Header.Set(hash.Header, hash.Sign(key, data))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	do := func(h http.Handler, method, name, key, body string) *httptest.ResponseRecorder {
+		return doAt(h, method, "/", name, key, body, time.Now())
+	}
+	gauge := func(value string) string {
+		return `{"type": "gauge", "id": "HeapAlloc", "value": ` + value + `}`
+	}
+
+	assert.Equal(t, http.StatusOK, do(update, http.MethodPost, "team-a", "key-a", gauge("1")).Code)
+	assert.Equal(t, http.StatusOK, do(update, http.MethodPost, "team-b", "key-b", gauge("2")).Code)
+	assert.Equal(t, http.StatusOK, do(update, http.MethodPost, "", "", gauge("3")).Code)
+	// Второй ключ арендатора тоже принимается, а ответ подписан им
+	w := do(update, http.MethodPost, "team-a", "key-a2", gauge("4"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, hash.Verify("key-a2", w.Body.Bytes(), w.Header().Get(hash.Header)))
+
+	assert.Equal(t, http.StatusUnauthorized, do(update, http.MethodPost, "team-c", "key-a", gauge("5")).Code)
+	assert.Equal(t, http.StatusUnauthorized, do(update, http.MethodPost, "team-a", "", gauge("5")).Code)
+	assert.Equal(t, http.StatusBadRequest, do(update, http.MethodPost, "team-a", "key-b", gauge("5")).Code)
+
+	// Подпись привязана к времени и ресурсу: устаревшая подпись и подпись другого запроса не принимаются
+	assert.Equal(t, http.StatusUnauthorized, doAt(update, http.MethodPost, "/", "team-a", "key-a", gauge("5"), time.Now().Add(-time.Hour)).Code)
+	req := httptest.NewRequest(http.MethodGet, "/?match=host=web-1", nil)
+	ts := tenant.Timestamp(time.Now())
+	req.Header.Set(tenant.Header, "team-a")
+	req.Header.Set(tenant.TimestampHeader, ts)
+	req.Header.Set(hash.Header, hash.Sign("key-a", tenant.SignedData(http.MethodGet, "/", ts, nil)))
+	w = httptest.NewRecorder()
+	list.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Каждый арендатор видит только свои метрики, в том числе в HTML-списке
+	for name, expected := range map[string]string{"team-a": "HeapAlloc/4", "team-b": "HeapAlloc/2", "": "HeapAlloc/3"} {
+		key := map[string]string{"team-a": "key-a", "team-b": "key-b"}[name]
+		w := do(list, http.MethodGet, name, key, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), expected)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	handler := api.NewHandler(storage.TestMetricStorage(), "", nil)
+	do := func(h http.HandlerFunc, method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+
+	// Метрика без меток и единственный ряд с метками
+	assert.Equal(t, http.StatusOK, do(handler.UpdateMetricsJSON, http.MethodPost, "/updates/", `[
+		{"type": "gauge", "id": "HeapAlloc", "value": 1},
+		{"type": "counter", "id": "PollCount", "delta": 2, "labels": {"host": "web-1", "agent_id": "a1"}}
+	]`).Code)
+	assert.Equal(t, "1\n", do(handler.GetMetric, http.MethodGet, "/value/gauge/HeapAlloc", "").Body.String())
+	assert.Equal(t, "2\n", do(handler.GetMetric, http.MethodGet, "/value/counter/PollCount", "").Body.String())
+
+	// Второй ряд с тем же именем требует уточнения меток
+	assert.Equal(t, http.StatusOK, do(handler.UpdateMetricJSON, http.MethodPost, "/update/",
+		`{"type": "counter", "id": "PollCount", "delta": 5, "labels": {"host": "web-2", "agent_id": "a2"}}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(handler.GetMetric, http.MethodGet, "/value/counter/PollCount", "").Code)
+	assert.Equal(t, "5\n", do(handler.GetMetric, http.MethodGet, "/value/counter/PollCount?match=host=web-2", "").Body.String())
+	assert.Equal(t, "2\n", do(handler.GetMetric, http.MethodGet, "/value/counter/PollCount?match=host!=web-2", "").Body.String())
+	assert.Equal(t, http.StatusNotFound, do(handler.GetMetric, http.MethodGet, "/value/counter/PollCount?match=host=web-3", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(handler.GetMetric, http.MethodGet, "/value/counter/PollCount?match=host", "").Code)
+
+	w := do(handler.GetMetricJSON, http.MethodPost, "/value/", `{"type": "counter", "id": "PollCount", "labels": {"agent_id": "a1"}}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"type": "counter", "id": "PollCount", "delta": 2, "labels": {"host": "web-1", "agent_id": "a1"}}`, w.Body.String())
+
+	// Некорректные метки отклоняются
+	assert.Equal(t, http.StatusBadRequest, do(handler.UpdateMetricsJSON, http.MethodPost, "/updates/",
+		`[{"type": "gauge", "id": "HeapAlloc", "value": 1, "labels": {"host name": "x"}}]`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(handler.UpdateMetricsJSON, http.MethodPost, "/updates/",
+		`[{"type": "gauge", "id": "HeapAlloc{host=\"web-9\"}", "value": 1}]`).Code)
+
+	// Списки фильтруются условиями на метки
+	body := do(handler.GetMetricsList, http.MethodGet, "/?match=host=web-1", "").Body.String()
+	assert.Contains(t, body, "PollCount{agent_id=&#34;a1&#34;,host=&#34;web-1&#34;}/2")
+	assert.NotContains(t, body, "web-2")
+	assert.NotContains(t, body, "HeapAlloc")
+
+	body = do(handler.PrometheusMetrics, http.MethodGet, "/metrics?match=host!=web-1", "").Body.String()
+	assert.Equal(t, "# TYPE HeapAlloc gauge\nHeapAlloc 1\n# TYPE PollCount counter\nPollCount{agent_id=\"a2\",host=\"web-2\"} 5\n", body)
+
+	// История ведется по каждому ряду отдельно
+	w = do(handler.QueryRange, http.MethodGet, "/api/v1/query_range?name=PollCount&match=host=web-2&match=agent_id=a2", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"labels":{"agent_id":"a2","host":"web-2"}`)
+	assert.Contains(t, w.Body.String(), `"value":5`)
+
+	// Запрос без меток возвращает ряд без меток, даже если рядом есть ряды с метками,
+	// а неоднозначен, только если ряда без меток нет
+	assert.Equal(t, http.StatusOK, do(handler.UpdateMetricsJSON, http.MethodPost, "/updates/", `[
+		{"type": "gauge", "id": "Alloc", "value": 1},
+		{"type": "gauge", "id": "Alloc", "value": 2, "labels": {"host": "web-1"}},
+		{"type": "gauge", "id": "Alloc", "value": 3, "labels": {"host": "web-2"}}
+	]`).Code)
+	assert.Equal(t, "1\n", do(handler.GetMetric, http.MethodGet, "/value/gauge/Alloc", "").Body.String())
+	assert.Equal(t, "1\n", do(handler.GetMetric, http.MethodGet, "/value/gauge/Alloc?match=host=", "").Body.String())
+	assert.Equal(t, http.StatusBadRequest, do(handler.GetMetric, http.MethodGet, "/value/gauge/Alloc?match=host!=", "").Code)
+	assert.Equal(t, "2\n", do(handler.GetMetric, http.MethodGet, "/value/gauge/Alloc?match=host=web-1", "").Body.String())
+}
+
+func TestHistograms(t *testing.T) {
+	handler := api.NewHandler(storage.TestMetricStorage(), "", nil)
+	do := func(h http.HandlerFunc, method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+
+	// Гистограммы двух агентов с одинаковыми границами складываются
+	assert.Equal(t, http.StatusOK, do(handler.UpdateMetricJSON, http.MethodPost, "/update/",
+		`{"type": "histogram", "id": "latency", "histogram": {"bounds": [0.1, 1], "counts": [4, 4, 0], "sum": 2.5}}`).Code)
+	assert.Equal(t, http.StatusOK, do(handler.UpdateMetricsJSON, http.MethodPost, "/updates/",
+		`[{"type": "histogram", "id": "latency", "histogram": {"bounds": [0.1, 1], "counts": [1, 0, 1], "sum": 3}}]`).Code)
+
+	w := do(handler.GetMetricJSON, http.MethodPost, "/value/", `{"type": "histogram", "id": "latency"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"type": "histogram", "id": "latency", "histogram": {"bounds": [0.1, 1], "counts": [5, 4, 1], "sum": 5.5}}`, w.Body.String())
+
+	w = do(handler.GetMetric, http.MethodGet, "/value/histogram/latency?quantile=0.5", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0.1\n", w.Body.String())
+	assert.Equal(t, http.StatusBadRequest, do(handler.GetMetric, http.MethodGet, "/value/histogram/latency?quantile=2", "").Code)
+	assert.JSONEq(t, `{"bounds": [0.1, 1], "counts": [5, 4, 1], "sum": 5.5}`,
+		do(handler.GetMetric, http.MethodGet, "/value/histogram/latency", "").Body.String())
+
+	// Гистограммы с другими границами или без корзины +Inf отклоняются
+	assert.Equal(t, http.StatusBadRequest, do(handler.UpdateMetricsJSON, http.MethodPost, "/updates/",
+		`[{"type": "histogram", "id": "latency", "histogram": {"bounds": [0.1, 1], "counts": [1, 1]}}]`).Code)
+	assert.Equal(t, http.StatusInternalServerError, do(handler.UpdateMetricJSON, http.MethodPost, "/update/",
+		`{"type": "histogram", "id": "latency", "histogram": {"bounds": [0.5], "counts": [1, 1]}}`).Code)
+
+	body := do(handler.PrometheusMetrics, http.MethodGet, "/metrics", "").Body.String()
+	assert.Contains(t, body, "# TYPE latency histogram\nlatency_bucket{le=\"0.1\"} 5\nlatency_bucket{le=\"1\"} 9\n"+
+		"latency_bucket{le=\"+Inf\"} 10\nlatency_sum 5.5\nlatency_count 10\n")
+}
+
+func TestSummaries(t *testing.T) {
+	handler := api.NewHandler(storage.TestMetricStorage(), "", nil)
+	do := func(h http.HandlerFunc, method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+
+	// Сводка заменяется последней присланной
+	assert.Equal(t, http.StatusOK, do(handler.UpdateMetricJSON, http.MethodPost, "/update/",
+		`{"type": "summary", "id": "rpc", "summary": {"quantiles": [{"quantile": 0.5, "value": 1}], "count": 2, "sum": 3}}`).Code)
+	assert.Equal(t, http.StatusOK, do(handler.UpdateMetricsJSON, http.MethodPost, "/updates/",
+		`[{"type": "summary", "id": "rpc", "summary": {"quantiles": [{"quantile": 0.5, "value": 2}, {"quantile": 0.99, "value": 5}], "count": 4, "sum": 9}}]`).Code)
+
+	w := do(handler.GetMetricJSON, http.MethodPost, "/value/", `{"type": "summary", "id": "rpc"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"type": "summary", "id": "rpc", "summary": {"quantiles": [{"quantile": 0.5, "value": 2}, {"quantile": 0.99, "value": 5}], "count": 4, "sum": 9}}`, w.Body.String())
+
+	assert.Equal(t, "5\n", do(handler.GetMetric, http.MethodGet, "/value/summary/rpc?quantile=0.99", "").Body.String())
+	assert.Equal(t, http.StatusNotFound, do(handler.GetMetric, http.MethodGet, "/value/summary/rpc?quantile=0.9", "").Code)
+
+	// Сводка без данных или с квантилем вне [0, 1] отклоняется
+	assert.Equal(t, http.StatusBadRequest, do(handler.UpdateMetricJSON, http.MethodPost, "/update/", `{"type": "summary", "id": "rpc"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(handler.UpdateMetricsJSON, http.MethodPost, "/updates/",
+		`[{"type": "summary", "id": "rpc", "summary": {"quantiles": [{"quantile": 1.5, "value": 1}]}}]`).Code)
+
+	body := do(handler.PrometheusMetrics, http.MethodGet, "/metrics", "").Body.String()
+	assert.Contains(t, body, "# TYPE rpc summary\nrpc{quantile=\"0.5\"} 2\nrpc{quantile=\"0.99\"} 5\nrpc_sum 9\nrpc_count 4\n")
+}